@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// credential holds the attributes git passes to a credential helper, see gitcredentials(7).
+type credential struct {
+	protocol string
+	host     string // may include a port
+	path     string // only sent when credential.useHttpPath is set
+	username string
+	password string
+}
+
+// parseCredential reads the key=value lines git writes to a helper up to a blank line or the end of the input.
+// Attributes the helper doesn't use are ignored.
+func parseCredential(r io.Reader) (credential, error) {
+	var c credential
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return credential{}, fmt.Errorf("invalid credential line %q", line)
+		}
+		switch key {
+		case "protocol":
+			c.protocol = value
+		case "host":
+			c.host = value
+		case "path":
+			c.path = value
+		case "username":
+			c.username = value
+		case "password":
+			c.password = value
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil {
+				return credential{}, fmt.Errorf("invalid credential url: %w", err)
+			}
+			c.protocol, c.host, c.path = u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/")
+			if u.User != nil {
+				c.username = u.User.Username()
+			}
+		}
+	}
+	return c, scanner.Err()
+}
+
+// url returns the URL the credential is for, a .git suffix on the path is dropped so it matches a record for the
+// repository's web page.
+func (c credential) url() string {
+	protocol := c.protocol
+	if protocol == "" {
+		protocol = "https"
+	}
+	u := protocol + "://" + c.host
+	if path := strings.TrimSuffix(strings.Trim(c.path, "/"), ".git"); path != "" {
+		u += "/" + path
+	}
+	return u
+}
+
+// defaultPorts are the ports used for a host without one.
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// sameOrigin returns true if the record URL is for the credential's protocol and port, MatchURL ignores both when
+// matching hosts. A record URL without a protocol matches any protocol.
+func (c credential) sameOrigin(recordURL string) bool {
+	cu, err := url.Parse(c.url())
+	if err != nil {
+		return false
+	}
+	protocol := strings.ToLower(cu.Scheme)
+	port := cu.Port()
+	if port == "" {
+		port = defaultPorts[protocol]
+	}
+
+	raw := strings.TrimSpace(recordURL)
+	hasProtocol := strings.Contains(raw, "://")
+	if !hasProtocol {
+		raw = protocol + "://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	if hasProtocol && !strings.EqualFold(u.Scheme, protocol) {
+		return false
+	}
+	recordPort := u.Port()
+	if recordPort == "" {
+		recordPort = defaultPorts[protocol]
+	}
+	return recordPort == port
+}
+
+// find returns the records for the credential best match first. A record matches if its URL is for the same protocol,
+// host and port and its username is the credential's when one is given, a record URL without a protocol matches any.
+// When git sends a path the records whose URL path is a parent of it are preferred, the other records for the host are
+// only returned if there are none.
+func find(db *pwsafe.V3, c credential) ([]pwsafe.Record, error) {
+	exact, host, err := matchCredential(db, c)
+	if err != nil {
+		return nil, err
+	}
+	if c.path != "" && len(exact) > 0 {
+		return exact, nil
+	}
+	return append(exact, host...), nil
+}
+
+// matchCredential returns the records matching the credential, see find, split into those whose URL path is a parent
+// of the credential's path and the other records for the host.
+func matchCredential(db *pwsafe.V3, c credential) (exact, host []pwsafe.Record, err error) {
+	if c.host == "" {
+		return nil, nil, nil
+	}
+	matches, err := db.MatchURL(c.url(), pwsafe.MatchHost)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, m := range matches {
+		if c.username != "" && m.Record.Username != c.username {
+			continue
+		}
+		if !c.sameOrigin(m.Record.URL) {
+			continue
+		}
+		if m.Mode == pwsafe.MatchExact {
+			exact = append(exact, m.Record)
+		} else {
+			host = append(host, m.Record)
+		}
+	}
+	return exact, host, nil
+}
+
+// get writes the username and password of the best matching record to w, nothing is written if no record matches so
+// git moves on to its next helper or prompts.
+func get(db *pwsafe.V3, c credential, w io.Writer) error {
+	records, err := find(db, c)
+	if err != nil || len(records) == 0 {
+		return err
+	}
+	record := records[0]
+	_, err = fmt.Fprintf(w, "username=%s\npassword=%s\n", record.Username, record.Password)
+	return err
+}
+
+// store saves the credential after git used it successfully. The password of a matching record with the same username
+// is updated, otherwise a record is added to group. It returns true if the db was changed.
+func store(db *pwsafe.V3, c credential, group string) (bool, error) {
+	if c.host == "" || c.password == "" {
+		return false, nil
+	}
+	records, err := find(db, c)
+	if err != nil {
+		return false, err
+	}
+	for _, record := range records {
+		if record.Username != c.username {
+			continue
+		}
+		if record.Password == c.password {
+			return false, nil
+		}
+		record.Password = c.password
+		db.SetRecord(record)
+		return true, nil
+	}
+
+	title := c.host
+	if path := strings.TrimSuffix(strings.Trim(c.path, "/"), ".git"); path != "" {
+		title += "/" + path
+	}
+	db.SetRecord(pwsafe.Record{
+		Title:    title,
+		Group:    group,
+		URL:      c.url(),
+		Username: c.username,
+		Password: c.password,
+	})
+	return true, nil
+}
+
+// erase deletes the records matching the credential after git was refused with it, when git sends the rejected
+// password only records with that password are deleted. A record for another path on the host, whose URL path isn't
+// a parent of the credential's, is only deleted if it is in group where store adds records, so a rejected repository
+// doesn't erase the user's records for other repositories. It returns true if the db was changed.
+func erase(db *pwsafe.V3, c credential, group string) (bool, error) {
+	exact, host, err := matchCredential(db, c)
+	if err != nil {
+		return false, err
+	}
+	records := exact
+	for _, record := range host {
+		if record.Group == group {
+			records = append(records, record)
+		}
+	}
+	changed := false
+	for _, record := range records {
+		if c.password != "" && record.Password != c.password {
+			continue
+		}
+		db.DeleteRecord(record.UUID)
+		changed = true
+	}
+	return changed, nil
+}
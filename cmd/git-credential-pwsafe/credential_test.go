@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+func TestParseCredential(t *testing.T) {
+	input := "protocol=https\nhost=github.com:8443\npath=org/repo.git\nusername=me\ncapability[]=authtype\nwwwauth[]=Basic\n\nignored=after blank\n"
+	c, err := parseCredential(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, credential{protocol: "https", host: "github.com:8443", path: "org/repo.git", username: "me"}, c)
+	assert.Equal(t, "https://github.com:8443/org/repo", c.url())
+
+	c, err = parseCredential(strings.NewReader("url=https://me@example.com/a/b\r\npassword=secret\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, credential{protocol: "https", host: "example.com", path: "a/b", username: "me", password: "secret"}, c)
+
+	_, err = parseCredential(strings.NewReader("no equals sign\n"))
+	assert.Error(t, err)
+}
+
+func testDB() *pwsafe.V3 {
+	db := pwsafe.NewV3("git", "password")
+	db.SetRecord(pwsafe.Record{Title: "github", Username: "me", Password: "host-pass", URL: "https://github.com"})
+	db.SetRecord(pwsafe.Record{Title: "github bot", Username: "bot", Password: "bot-pass", URL: "github.com"})
+	db.SetRecord(pwsafe.Record{Title: "repo", Username: "me", Password: "repo-pass", URL: "https://github.com/org/repo"})
+	db.SetRecord(pwsafe.Record{Title: "gitlab", Username: "me", Password: "gitlab-pass", URL: "https://gitlab.com"})
+	return db
+}
+
+func TestGet(t *testing.T) {
+	db := testDB()
+	tests := []struct {
+		c      credential
+		output string
+	}{
+		{credential{protocol: "https", host: "gitlab.com"}, "username=me\npassword=gitlab-pass\n"},
+		{credential{protocol: "https", host: "github.com", username: "bot"}, "username=bot\npassword=bot-pass\n"},
+		{credential{protocol: "https", host: "github.com", path: "org/repo.git", username: "me"}, "username=me\npassword=repo-pass\n"},
+		{credential{protocol: "https", host: "github.com", path: "org/other.git", username: "me"}, "username=me\npassword=host-pass\n"},
+		{credential{protocol: "https", host: "github.com", username: "nobody"}, ""},
+		{credential{protocol: "https", host: "bitbucket.org"}, ""},
+		{credential{protocol: "http", host: "gitlab.com"}, ""},
+		{credential{protocol: "https", host: "gitlab.com:8443"}, ""},
+		{credential{protocol: "https", host: "gitlab.com:443"}, "username=me\npassword=gitlab-pass\n"},
+		{credential{protocol: "http", host: "github.com", username: "bot"}, "username=bot\npassword=bot-pass\n"},
+		{credential{protocol: "https"}, ""},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		require.NoError(t, get(db, test.c, &out))
+		assert.Equal(t, test.output, out.String(), test.c)
+	}
+}
+
+func TestStore(t *testing.T) {
+	db := testDB()
+
+	// An unchanged credential leaves the db alone
+	changed, err := store(db, credential{protocol: "https", host: "gitlab.com", username: "me", password: "gitlab-pass"}, "git")
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// A new password updates the record
+	changed, err = store(db, credential{protocol: "https", host: "gitlab.com", username: "me", password: "new-pass"}, "git")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	records, err := find(db, credential{protocol: "https", host: "gitlab.com", username: "me"})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "new-pass", records[0].Password)
+
+	// A new username or host adds a record to the group
+	changed, err = store(db, credential{protocol: "https", host: "example.com", path: "team/app.git", username: "ci", password: "ci-pass"}, "dev.git")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	records, err = find(db, credential{protocol: "https", host: "example.com", path: "team/app.git"})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "example.com/team/app", records[0].Title)
+	assert.Equal(t, "dev.git", records[0].Group)
+	assert.Equal(t, "https://example.com/team/app", records[0].URL)
+	assert.Equal(t, "ci", records[0].Username)
+	assert.Equal(t, "ci-pass", records[0].Password)
+	assert.Len(t, db.Snapshot(), 5)
+
+	changed, err = store(db, credential{protocol: "https", host: "example.com", username: "ci"}, "git")
+	require.NoError(t, err)
+	assert.False(t, changed, "no password to store")
+}
+
+func TestErase(t *testing.T) {
+	db := testDB()
+
+	// Only records with the rejected password are erased
+	changed, err := erase(db, credential{protocol: "https", host: "github.com", username: "bot", password: "other"}, "git")
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	changed, err = erase(db, credential{protocol: "https", host: "github.com", username: "bot", password: "bot-pass"}, "git")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	records, err := find(db, credential{protocol: "https", host: "github.com", username: "bot"})
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	assert.Len(t, db.Snapshot(), 3)
+
+	// A record for another path on the host is only erased if the helper stored it
+	db.SetRecord(pwsafe.Record{Title: "stored", Group: "git", Username: "me", Password: "old-pass", URL: "https://github.com/org/stored"})
+	db.SetRecord(pwsafe.Record{Title: "other", Group: "dev", Username: "me", Password: "old-pass", URL: "https://github.com/org/other"})
+	changed, err = erase(db, credential{protocol: "https", host: "github.com", path: "org/repo.git", username: "me", password: "old-pass"}, "git")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	var titles []string
+	for _, record := range db.Snapshot() {
+		titles = append(titles, record.Title)
+	}
+	assert.ElementsMatch(t, []string{"github", "repo", "gitlab", "other"}, titles)
+
+	// Nor records for another protocol or port
+	changed, err = erase(db, credential{protocol: "https", host: "gitlab.com:8443", username: "me"}, "git")
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
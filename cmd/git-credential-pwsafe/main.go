@@ -0,0 +1,84 @@
+// git-credential-pwsafe is a git credential helper which gets, stores and erases HTTPS credentials in a Password Safe
+// v3 db. Configure git to use it with
+//
+//	git config --global credential.helper 'pwsafe -db /path/to/team.psafe3'
+//
+// git then runs it with get, store or erase and the credential on stdin, see gitcredentials(7). Records match when
+// their URL is for the requested protocol, host and port and, if git sends one, their username is the requested
+// username. With credential.useHttpPath set records whose URL path is a parent of the repository path are preferred.
+// New credentials are stored in the group set by -group, erase only deletes a record for another path on the host if
+// it is in that group.
+//
+// The db password is read from PWSAFE_PASSWORD when set, otherwise it is prompted for on the terminal.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tkuhlman/gopwsafe/internal/passwd"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// dbEnvVar is the environment variable the db path is read from when -db isn't given.
+const dbEnvVar = "PWSAFE_DB"
+
+func main() {
+	dbPath := flag.String("db", os.Getenv(dbEnvVar), "the db file, defaults to $"+dbEnvVar)
+	group := flag.String("group", "git", "the group new credentials are stored in")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: git-credential-pwsafe [flags] <get|store|erase>\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), *dbPath, *group); err != nil {
+		fmt.Fprintf(os.Stderr, "git-credential-pwsafe: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(action, dbPath, group string) error {
+	switch action {
+	case "get", "store", "erase":
+	default:
+		// Helpers must ignore actions they don't know so git can add new ones
+		return nil
+	}
+	if dbPath == "" {
+		return fmt.Errorf("no db file, set -db or %s", dbEnvVar)
+	}
+	c, err := parseCredential(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	// stdin holds the credential so the password can only be prompted for on the terminal
+	password, err := passwd.ReadTTY(dbPath)
+	if err != nil {
+		return err
+	}
+	db, err := pwsafe.OpenPWSafeFile(dbPath, password)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var changed bool
+	switch action {
+	case "get":
+		return get(db, c, os.Stdout)
+	case "store":
+		changed, err = store(db, c, group)
+	case "erase":
+		changed, err = erase(db, c, group)
+	}
+	if err != nil || !changed {
+		return err
+	}
+	return pwsafe.WritePWSafeFile(db, dbPath)
+}
@@ -0,0 +1,161 @@
+// pwsafe-server serves an open Password Safe v3 db over a REST API on localhost so tools and scripts can fetch
+// credentials without decrypting the file themselves.
+//
+// Every request needs the header "Authorization: Bearer <token>". The token is read from PWSAFE_SERVER_TOKEN, or from
+// the -token-file which is created with a random token if it doesn't exist, otherwise a random token is printed on
+// startup. The db password is read from PWSAFE_PASSWORD when set, otherwise it is prompted for.
+//
+// Routes, all under /v1 and returning JSON:
+//
+//	GET    /records[?group=g]   list records without passwords
+//	POST   /records             create a record
+//	GET    /records/{uuid}      get a record including its password
+//	PUT    /records/{uuid}      update a record
+//	DELETE /records/{uuid}      delete a record
+//	GET    /groups              list groups
+//	GET    /search?q=query      search with the pwsafe/query syntax
+//	GET    /generate            generate a password, ?length=32&symbols=excluded
+//	GET    /status              whether the db is locked
+//	POST   /lock                lock the db
+//	POST   /unlock              unlock the db with {"password": "..."}
+//
+// Changes are written to the db file as they are made. The db is locked in memory after it has been idle, -idle 0
+// disables this.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tkuhlman/gopwsafe/internal/passwd"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// tokenEnvVar is the environment variable the bearer token is read from.
+const tokenEnvVar = "PWSAFE_SERVER_TOKEN"
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8750", "loopback address to listen on")
+	idle := flag.Duration("idle", 5*time.Minute, "lock the db after this long without requests, 0 disables")
+	tokenFile := flag.String("token-file", "", "file holding the bearer token, created with a random token if missing")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pwsafe-server [flags] <db file>\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), *addr, *tokenFile, *idle); err != nil {
+		fmt.Fprintf(os.Stderr, "pwsafe-server: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(path, addr, tokenFile string, idle time.Duration) error {
+	if err := checkLoopback(addr); err != nil {
+		return err
+	}
+	token, err := loadToken(tokenFile)
+	if err != nil {
+		return err
+	}
+	password, err := passwd.Read(path)
+	if err != nil {
+		return err
+	}
+	db, err := pwsafe.OpenPWSafeFile(path, password)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := newServer(db, path, token, idle)
+	httpServer := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	done := make(chan struct{})
+	go s.autoLock(done)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		close(done)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving %s on http://%s", path, listener.Addr())
+	if err := httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// checkLoopback only allows listening on the loopback interface, the API serves passwords.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("refusing to listen on %q, only loopback addresses are allowed", addr)
+	}
+	return nil
+}
+
+// loadToken returns the bearer token from the environment or the token file, creating the file or printing a new
+// token when there isn't one.
+func loadToken(tokenFile string) (string, error) {
+	if token := os.Getenv(tokenEnvVar); token != "" {
+		return token, nil
+	}
+	if tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err == nil {
+			token := strings.TrimSpace(string(data))
+			if token == "" {
+				return "", fmt.Errorf("token file %s is empty", tokenFile)
+			}
+			return token, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	if tokenFile != "" {
+		if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0o600); err != nil {
+			return "", err
+		}
+		log.Printf("wrote a new bearer token to %s", tokenFile)
+	} else {
+		fmt.Fprintf(os.Stderr, "bearer token: %s\n", token)
+	}
+	return token, nil
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+	"github.com/tkuhlman/gopwsafe/pwsafe/query"
+)
+
+// server serves the records of an open db over a REST API. Every request must have the bearer token, the db is locked
+// after it has been idle for the idle duration and changes are written to path as they are made.
+type server struct {
+	db    *pwsafe.V3
+	path  string
+	token string
+	idle  time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex // guards lastUse and serializes saves
+	lastUse time.Time
+
+	// dbMu is held for reading by a request for the records from the locked check through its change and save, and
+	// for writing to lock or unlock the db, so the db can't be locked part way through a request.
+	dbMu sync.RWMutex
+}
+
+func newServer(db *pwsafe.V3, path, token string, idle time.Duration) *server {
+	return &server{db: db, path: path, token: token, idle: idle, now: time.Now, lastUse: time.Now()}
+}
+
+// recordSummary is a record in a list without its password or notes.
+type recordSummary struct {
+	UUID     string `json:"uuid"`
+	Title    string `json:"title"`
+	Group    string `json:"group,omitempty"`
+	Username string `json:"username,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+// recordJSON is a record with the fields which can be read and written through the API.
+type recordJSON struct {
+	UUID                   string    `json:"uuid,omitempty"`
+	Title                  string    `json:"title"`
+	Group                  string    `json:"group,omitempty"`
+	Username               string    `json:"username,omitempty"`
+	Password               string    `json:"password"`
+	URL                    string    `json:"url,omitempty"`
+	Email                  string    `json:"email,omitempty"`
+	Notes                  string    `json:"notes,omitempty"`
+	PasswordExpiryInterval uint32    `json:"passwordExpiryInterval,omitempty"`
+	CreateTime             time.Time `json:"createTime,omitzero"`
+	ModTime                time.Time `json:"modTime,omitzero"`
+	PasswordModTime        time.Time `json:"passwordModTime,omitzero"`
+	PasswordExpiry         time.Time `json:"passwordExpiry,omitzero"`
+}
+
+func summarize(record pwsafe.Record) recordSummary {
+	return recordSummary{
+		UUID:     hex.EncodeToString(record.UUID[:]),
+		Title:    record.Title,
+		Group:    record.Group,
+		Username: record.Username,
+		URL:      record.URL,
+	}
+}
+
+func toJSON(record pwsafe.Record) recordJSON {
+	return recordJSON{
+		UUID:                   hex.EncodeToString(record.UUID[:]),
+		Title:                  record.Title,
+		Group:                  record.Group,
+		Username:               record.Username,
+		Password:               record.Password,
+		URL:                    record.URL,
+		Email:                  record.Email,
+		Notes:                  record.Notes,
+		PasswordExpiryInterval: record.PasswordExpiryInterval,
+		CreateTime:             record.CreateTime,
+		ModTime:                record.ModTime,
+		PasswordModTime:        record.PasswordModTime,
+		PasswordExpiry:         record.PasswordExpiry,
+	}
+}
+
+// apply sets the writable fields of the record from r, the times are maintained by SetRecord.
+func (r recordJSON) apply(record *pwsafe.Record) {
+	record.Title = r.Title
+	record.Group = r.Group
+	record.Username = r.Username
+	record.Password = r.Password
+	record.URL = r.URL
+	record.Email = r.Email
+	record.Notes = r.Notes
+	record.PasswordExpiryInterval = r.PasswordExpiryInterval
+}
+
+func (r recordJSON) validate() error {
+	if r.Title == "" || r.Password == "" {
+		return errors.New("title and password are required")
+	}
+	if r.PasswordExpiryInterval > pwsafe.PasswordExpiryIntervalMax {
+		return fmt.Errorf("passwordExpiryInterval exceeds the maximum of %d", pwsafe.PasswordExpiryIntervalMax)
+	}
+	return nil
+}
+
+// handler returns the API routes wrapped in the token check.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/records", s.unlocked(s.listRecords))
+	mux.HandleFunc("POST /v1/records", s.unlocked(s.createRecord))
+	mux.HandleFunc("GET /v1/records/{uuid}", s.unlocked(s.getRecord))
+	mux.HandleFunc("PUT /v1/records/{uuid}", s.unlocked(s.updateRecord))
+	mux.HandleFunc("DELETE /v1/records/{uuid}", s.unlocked(s.deleteRecord))
+	mux.HandleFunc("GET /v1/groups", s.unlocked(s.listGroups))
+	mux.HandleFunc("GET /v1/search", s.unlocked(s.search))
+	mux.HandleFunc("GET /v1/generate", s.generate)
+	mux.HandleFunc("GET /v1/status", s.status)
+	mux.HandleFunc("POST /v1/lock", s.lock)
+	mux.HandleFunc("POST /v1/unlock", s.unlock)
+	return s.authenticated(mux)
+}
+
+// authenticated checks the bearer token and records the request for the idle timer.
+func (s *server) authenticated(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pwsafe"`)
+			writeError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+			return
+		}
+		s.mu.Lock()
+		s.lastUse = s.now()
+		s.mu.Unlock()
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unlocked rejects requests for the records while the db is locked and keeps it unlocked until the request is done.
+func (s *server) unlocked(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.dbMu.RLock()
+		defer s.dbMu.RUnlock()
+		if s.db.Locked() {
+			writeError(w, http.StatusLocked, "the db is locked, POST the password to /v1/unlock")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// lockIfIdle locks the db if there have been no requests for the idle duration, returning true if it locked it.
+func (s *server) lockIfIdle() bool {
+	s.mu.Lock()
+	idle := s.now().Sub(s.lastUse)
+	s.mu.Unlock()
+	if s.idle <= 0 || idle < s.idle {
+		return false
+	}
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+	if s.db.Locked() {
+		return false
+	}
+	if err := s.db.Lock(); err != nil {
+		log.Printf("failed to lock the idle db: %v", err)
+		return false
+	}
+	return true
+}
+
+// autoLock checks for an idle db until done is closed.
+func (s *server) autoLock(done <-chan struct{}) {
+	if s.idle <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(max(s.idle/10, time.Second), time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if s.lockIfIdle() {
+				log.Printf("locked the db after %s idle", s.idle)
+			}
+		}
+	}
+}
+
+// save writes the db to its file, saves are serialized so concurrent changes don't interleave in the file.
+func (s *server) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return pwsafe.WritePWSafeFile(s.db, s.path)
+}
+
+func (s *server) listRecords(w http.ResponseWriter, r *http.Request) {
+	group := r.URL.Query().Get("group")
+	_, filterGroup := r.URL.Query()["group"]
+	records := s.db.Snapshot()
+	sortRecords(records)
+	summaries := []recordSummary{}
+	for _, record := range records {
+		if filterGroup && record.Group != group {
+			continue
+		}
+		summaries = append(summaries, summarize(record))
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *server) search(w http.ResponseWriter, r *http.Request) {
+	q, err := query.Compile(r.URL.Query().Get("q"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	summaries := []recordSummary{}
+	for _, record := range query.Search(s.db, q) {
+		summaries = append(summaries, summarize(record))
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *server) getRecord(w http.ResponseWriter, r *http.Request) {
+	record, ok := s.recordFromPath(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toJSON(record))
+}
+
+func (s *server) createRecord(w http.ResponseWriter, r *http.Request) {
+	var body recordJSON
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if err := body.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var record pwsafe.Record
+	body.apply(&record)
+	id := s.db.SetRecord(record)
+	if !s.saveOrFail(w) {
+		return
+	}
+	created, _ := s.db.Record(id)
+	writeJSON(w, http.StatusCreated, toJSON(created))
+}
+
+func (s *server) updateRecord(w http.ResponseWriter, r *http.Request) {
+	record, ok := s.recordFromPath(w, r)
+	if !ok {
+		return
+	}
+	var body recordJSON
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if err := body.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	body.apply(&record)
+	s.db.SetRecord(record)
+	if !s.saveOrFail(w) {
+		return
+	}
+	updated, _ := s.db.Record(record.UUID)
+	writeJSON(w, http.StatusOK, toJSON(updated))
+}
+
+func (s *server) deleteRecord(w http.ResponseWriter, r *http.Request) {
+	record, ok := s.recordFromPath(w, r)
+	if !ok {
+		return
+	}
+	s.db.DeleteRecord(record.UUID)
+	if !s.saveOrFail(w) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) listGroups(w http.ResponseWriter, r *http.Request) {
+	groups := []string{}
+	for _, group := range s.db.Groups() {
+		if group != "" {
+			groups = append(groups, group)
+		}
+	}
+	for _, group := range s.db.EmptyGroups() {
+		if !slices.Contains(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+	slices.Sort(groups)
+	writeJSON(w, http.StatusOK, groups)
+}
+
+// generate returns a random password, the length and the use of each character set, required, included or
+// excluded, can be set with query parameters, for example ?length=32&symbols=excluded.
+func (s *server) generate(w http.ResponseWriter, r *http.Request) {
+	opts := pwsafe.DefaultPasswordOptions
+	params := r.URL.Query()
+	if length := params.Get("length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 1 || n > 1024 {
+			writeError(w, http.StatusBadRequest, "length must be between 1 and 1024")
+			return
+		}
+		opts.Length = n
+	}
+	if _, ok := params["exclude"]; ok {
+		opts.Exclude = params.Get("exclude")
+	}
+	uses := map[string]pwsafe.CharsetUse{
+		"included": pwsafe.CharsetIncluded,
+		"required": pwsafe.CharsetRequired,
+		"excluded": pwsafe.CharsetExcluded,
+	}
+	for name, set := range map[string]*pwsafe.CharsetUse{
+		"upper": &opts.Upper, "lower": &opts.Lower, "digits": &opts.Digits, "symbols": &opts.Symbols,
+	} {
+		if value := params.Get(name); value != "" {
+			use, ok := uses[value]
+			if !ok {
+				writeError(w, http.StatusBadRequest, name+" must be required, included or excluded")
+				return
+			}
+			*set = use
+		}
+	}
+	password, err := pwsafe.GeneratePassword(opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"password": password})
+}
+
+func (s *server) status(w http.ResponseWriter, r *http.Request) {
+	// The header with the last save time is cleared while locked, changes are saved as they are made anyway
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	locked := s.db.Locked()
+	writeJSON(w, http.StatusOK, map[string]any{"locked": locked, "needsSave": !locked && s.db.NeedsSave()})
+}
+
+func (s *server) lock(w http.ResponseWriter, r *http.Request) {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+	if err := s.db.Lock(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) unlock(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Password string `json:"password"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+	if !s.db.Locked() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := s.db.Unlock(body.Password); errors.Is(err, pwsafe.ErrInvalidPassword) {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recordFromPath returns the record with the UUID in the path writing an error response if there isn't one.
+func (s *server) recordFromPath(w http.ResponseWriter, r *http.Request) (pwsafe.Record, bool) {
+	raw, err := hex.DecodeString(r.PathValue("uuid"))
+	if err != nil || len(raw) != 16 {
+		writeError(w, http.StatusBadRequest, "invalid uuid")
+		return pwsafe.Record{}, false
+	}
+	record, ok := s.db.Record([16]byte(raw))
+	if !ok {
+		writeError(w, http.StatusNotFound, "record not found")
+		return pwsafe.Record{}, false
+	}
+	return record, true
+}
+
+// saveOrFail saves the db writing an error response if it fails.
+func (s *server) saveOrFail(w http.ResponseWriter) bool {
+	if err := s.save(); err != nil {
+		log.Printf("failed to save %s: %v", s.path, err)
+		writeError(w, http.StatusInternalServerError, "the change was made but saving the db failed: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func sortRecords(records []pwsafe.Record) {
+	slices.SortFunc(records, func(a, b pwsafe.Record) int {
+		if c := strings.Compare(a.Group, b.Group); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Title, b.Title)
+	})
+}
+
+// maxBodySize limits request bodies, records are small.
+const maxBodySize = 1 << 20
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
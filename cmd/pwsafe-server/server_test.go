@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+const (
+	testToken    = "test-token"
+	testPassword = "password"
+)
+
+func newTestServer(t *testing.T) (*server, *httptest.Server) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.dat")
+	db := pwsafe.NewV3(testPassword, testPassword)
+	db.SetRecord(pwsafe.Record{Title: "github", Group: "dev", Username: "me", Password: "gh-pass", URL: "https://github.com"})
+	db.SetRecord(pwsafe.Record{Title: "bank", Group: "money", Username: "me", Password: "bank-pass"})
+	require.NoError(t, pwsafe.WritePWSafeFile(db, path))
+
+	s := newServer(db, path, testToken, time.Minute)
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func request(t *testing.T, ts *httptest.Server, method, path string, body any) (int, []byte) {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, data
+}
+
+func TestAuth(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	for _, auth := range []string{"", "Bearer wrong", testToken} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/records", nil)
+		require.NoError(t, err)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, auth)
+		assert.NotEmpty(t, resp.Header.Get("WWW-Authenticate"))
+	}
+
+	status, _ := request(t, ts, http.MethodGet, "/v1/records", nil)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestRecords(t *testing.T) {
+	s, ts := newTestServer(t)
+
+	status, body := request(t, ts, http.MethodGet, "/v1/records", nil)
+	require.Equal(t, http.StatusOK, status)
+	var summaries []recordSummary
+	require.NoError(t, json.Unmarshal(body, &summaries))
+	require.Len(t, summaries, 2)
+	assert.Equal(t, "github", summaries[0].Title)
+	assert.NotContains(t, string(body), "gh-pass")
+
+	status, body = request(t, ts, http.MethodGet, "/v1/records?group=money", nil)
+	require.Equal(t, http.StatusOK, status)
+	require.NoError(t, json.Unmarshal(body, &summaries))
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "bank", summaries[0].Title)
+
+	// Create
+	status, body = request(t, ts, http.MethodPost, "/v1/records", recordJSON{Title: "mail", Group: "web", Password: "mail-pass"})
+	require.Equal(t, http.StatusCreated, status, string(body))
+	var created recordJSON
+	require.NoError(t, json.Unmarshal(body, &created))
+	assert.Len(t, created.UUID, 32)
+	assert.False(t, created.CreateTime.IsZero())
+
+	status, _ = request(t, ts, http.MethodPost, "/v1/records", recordJSON{Title: "no password"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = request(t, ts, http.MethodPost, "/v1/records", map[string]string{"title": "t", "password": "p", "bogus": "x"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// Read
+	status, body = request(t, ts, http.MethodGet, "/v1/records/"+created.UUID, nil)
+	require.Equal(t, http.StatusOK, status)
+	var got recordJSON
+	require.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, "mail-pass", got.Password)
+
+	status, _ = request(t, ts, http.MethodGet, "/v1/records/nothex", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = request(t, ts, http.MethodGet, "/v1/records/00000000000000000000000000000000", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// Update
+	got.Password = "new-pass"
+	got.Notes = "changed"
+	status, body = request(t, ts, http.MethodPut, "/v1/records/"+created.UUID, got)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var updated recordJSON
+	require.NoError(t, json.Unmarshal(body, &updated))
+	assert.Equal(t, "new-pass", updated.Password)
+	assert.Equal(t, "changed", updated.Notes)
+	assert.Equal(t, created.CreateTime.Unix(), updated.CreateTime.Unix())
+
+	// Changes are written through to the file
+	saved, err := pwsafe.OpenPWSafeFile(s.path, testPassword)
+	require.NoError(t, err)
+	records := saved.Snapshot()
+	assert.Len(t, records, 3)
+	for _, record := range records {
+		if record.Title == "mail" {
+			assert.Equal(t, "new-pass", record.Password)
+		}
+	}
+
+	// Delete
+	status, _ = request(t, ts, http.MethodDelete, "/v1/records/"+created.UUID, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = request(t, ts, http.MethodGet, "/v1/records/"+created.UUID, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	saved, err = pwsafe.OpenPWSafeFile(s.path, testPassword)
+	require.NoError(t, err)
+	assert.Len(t, saved.Snapshot(), 2)
+}
+
+func TestGroupsAndSearch(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	status, body := request(t, ts, http.MethodGet, "/v1/groups", nil)
+	require.Equal(t, http.StatusOK, status)
+	var groups []string
+	require.NoError(t, json.Unmarshal(body, &groups))
+	assert.Equal(t, []string{"dev", "money"}, groups)
+
+	status, body = request(t, ts, http.MethodGet, "/v1/search?q=url:github", nil)
+	require.Equal(t, http.StatusOK, status)
+	var summaries []recordSummary
+	require.NoError(t, json.Unmarshal(body, &summaries))
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "github", summaries[0].Title)
+
+	status, _ = request(t, ts, http.MethodGet, "/v1/search?q=%28unclosed", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestGenerate(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	status, body := request(t, ts, http.MethodGet, "/v1/generate?length=32&symbols=excluded&digits=required", nil)
+	require.Equal(t, http.StatusOK, status, string(body))
+	var generated map[string]string
+	require.NoError(t, json.Unmarshal(body, &generated))
+	password := generated["password"]
+	assert.Len(t, password, 32)
+	assert.Regexp(t, `^[A-Za-z0-9]+$`, password)
+	assert.Regexp(t, `[0-9]`, password)
+
+	for _, query := range []string{"length=0", "length=x", "upper=sometimes"} {
+		status, _ = request(t, ts, http.MethodGet, "/v1/generate?"+query, nil)
+		assert.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
+
+func TestLock(t *testing.T) {
+	s, ts := newTestServer(t)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	status, _ := request(t, ts, http.MethodGet, "/v1/records", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.False(t, s.lockIfIdle())
+
+	now = now.Add(2 * time.Minute)
+	assert.True(t, s.lockIfIdle())
+	assert.True(t, s.db.Locked())
+
+	status, _ = request(t, ts, http.MethodGet, "/v1/records", nil)
+	assert.Equal(t, http.StatusLocked, status)
+	status, body := request(t, ts, http.MethodGet, "/v1/status", nil)
+	require.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"locked": true, "needsSave": false}`, string(body))
+
+	status, _ = request(t, ts, http.MethodPost, "/v1/unlock", map[string]string{"password": "wrong"})
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = request(t, ts, http.MethodPost, "/v1/unlock", map[string]string{"password": testPassword})
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = request(t, ts, http.MethodGet, "/v1/records", nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	status, _ = request(t, ts, http.MethodPost, "/v1/lock", nil)
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.True(t, s.db.Locked())
+}
+
+func TestLockWaitsForChanges(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	// Hold a change part way through, after the locked check and before the record is set and saved
+	entered, proceed, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	handler := s.unlocked(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-proceed
+		s.createRecord(w, r)
+	})
+	created := httptest.NewRecorder()
+	go func() {
+		defer close(done)
+		handler(created, httptest.NewRequest(http.MethodPost, "/v1/records", strings.NewReader(`{"title":"new","password":"secret"}`)))
+	}()
+	<-entered
+
+	lockDone := make(chan struct{})
+	locked := httptest.NewRecorder()
+	go func() {
+		defer close(lockDone)
+		s.lock(locked, httptest.NewRequest(http.MethodPost, "/v1/lock", nil))
+	}()
+	select {
+	case <-lockDone:
+		t.Fatal("the db was locked part way through a change")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(proceed)
+	<-done
+	<-lockDone
+	assert.Equal(t, http.StatusCreated, created.Code, created.Body.String())
+	assert.Equal(t, http.StatusNoContent, locked.Code)
+	assert.True(t, s.db.Locked())
+	saved, err := pwsafe.OpenPWSafeFile(s.path, testPassword)
+	require.NoError(t, err)
+	_, err = saved.RecordByPath("", "new")
+	assert.NoError(t, err)
+}
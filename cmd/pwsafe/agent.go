@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe/sshagent"
+)
+
+// runAgent serves the SSH keys stored in the db on an SSH_AUTH_SOCK Unix socket until interrupted.
+func runAgent(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	group := fs.String("group", sshagent.DefaultGroup, "load keys from the records in this group and its subgroups")
+	marker := fs.String("marker", sshagent.DefaultMarker, "also load keys from records whose notes contain this marker")
+	confirm := fs.Bool("confirm", false, "ask before each use of a key with $SSH_ASKPASS or on the terminal")
+	lifetime := fs.Duration("lifetime", 0, "remove the keys from the agent after this long, 0 keeps them")
+	socket := fs.String("socket", "", "the socket path, defaults to a new private temporary directory")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pwsafe agent [flags] <db file>\n\nPrints the SSH_AUTH_SOCK to use then serves the keys until interrupted.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	path, err := dbPathArg(fs)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(path)
+	if err != nil {
+		return err
+	}
+	opts := sshagent.Options{Group: *group, Marker: *marker, Lifetime: *lifetime}
+	if *confirm {
+		opts.Confirm = askConfirm
+	}
+	keys, err := sshagent.LoadKeys(db, opts)
+	// The keys are only kept in the agent
+	db.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skipped keys:\n%s\n", err)
+	}
+	if len(keys) == 0 {
+		return errors.New("no SSH keys found")
+	}
+	a, err := sshagent.New(keys, opts.Confirm)
+	if err != nil {
+		return err
+	}
+
+	if *socket == "" {
+		dir, err := os.MkdirTemp("", "pwsafe-agent-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		*socket = filepath.Join(dir, "agent.sock")
+	}
+	listener, err := net.Listen("unix", *socket)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	if err := os.Chmod(*socket, 0o600); err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	fmt.Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", *socket)
+	fmt.Fprintf(os.Stderr, "serving %d keys, interrupt to stop\n", len(keys))
+	return a.Serve(listener)
+}
+
+// confirmMu serializes confirmation prompts from concurrent connections.
+var confirmMu sync.Mutex
+
+// askConfirm asks whether a key may be used with the program in SSH_ASKPASS like ssh-agent -c, or on the terminal
+// when it isn't set.
+func askConfirm(comment string, key ssh.PublicKey) bool {
+	confirmMu.Lock()
+	defer confirmMu.Unlock()
+	prompt := fmt.Sprintf("Allow use of key %s?\nKey fingerprint %s.", comment, ssh.FingerprintSHA256(key))
+	if askpass := os.Getenv("SSH_ASKPASS"); askpass != "" {
+		cmd := exec.Command(askpass, prompt)
+		cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+		return cmd.Run() == nil
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "refusing use of key %s, set SSH_ASKPASS to confirm without a terminal\n", comment)
+		return false
+	}
+	defer tty.Close()
+	fmt.Fprintf(tty, "%s [y/N] ", prompt)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
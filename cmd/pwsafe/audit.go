@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe/audit"
+)
+
+// runAudit reports weak, reused, recycled and old passwords.
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	minScore := fs.Int("min-score", audit.DefaultOptions.MinScore, "report passwords with a strength score (0-4) below this, 0 disables")
+	maxAge := fs.Int("max-age", audit.DefaultOptions.MaxAgeDays, "report passwords not changed in this many days, 0 disables")
+	asJSON := fs.Bool("json", false, "print the findings as JSON")
+	fs.Parse(args)
+	path, err := dbPathArg(fs)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	findings := audit.Scan(db, audit.Options{MinScore: *minScore, MaxAgeDays: *maxAge})
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(findings)
+	}
+	for _, f := range findings {
+		name := f.Title
+		if f.Group != "" {
+			name = f.Group + "/" + f.Title
+		}
+		fmt.Printf("%-10s  %s: %s\n", f.Kind, name, f.Message)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe/audit"
+)
+
+// runBreached lists the records whose password is in a local Pwned Passwords SHA-1 list.
+func runBreached(args []string) error {
+	fs := flag.NewFlagSet("breached", flag.ExitOnError)
+	hashes := fs.String("hashes", "", "path to the Pwned Passwords SHA-1 file ordered by hash")
+	failBreached := fs.Bool("fail", false, "exit with status 3 when any password is breached")
+	fs.Parse(args)
+	path, err := dbPathArg(fs)
+	if err != nil {
+		return err
+	}
+	if *hashes == "" {
+		return errors.New("the -hashes file is required")
+	}
+
+	list, err := audit.OpenHashList(*hashes)
+	if err != nil {
+		return err
+	}
+	defer list.Close()
+
+	db, err := openDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	breached, err := audit.Breached(db, list)
+	if err != nil {
+		return err
+	}
+	for _, id := range breached {
+		record, _ := db.Record(id)
+		name := record.Title
+		if record.Group != "" {
+			name = record.Group + "/" + record.Title
+		}
+		fmt.Printf("%x  %s\n", id, name)
+	}
+
+	if *failBreached && len(breached) > 0 {
+		return exitStatus(3)
+	}
+	return nil
+}
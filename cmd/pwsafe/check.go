@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// runCheck reports problems with the records and header of a db, with -fix the safe fixes are applied and the db
+// saved. It exits with status 3 when problems remain so it can be used in scripts.
+func runCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fix := fs.Bool("fix", false, "fix the problems which can be fixed without losing data and save the db")
+	fs.Parse(args)
+	path, err := dbPathArg(fs)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return check(os.Stdout, db, path, *fix)
+}
+
+// check writes the problems with db to w, fixing and saving it to path first when fix is set. It returns exitStatus 3
+// when problems remain.
+func check(w io.Writer, db *pwsafe.V3, path string, fix bool) error {
+	if fix {
+		if fixed := db.Fix(); len(fixed) > 0 {
+			if err := pwsafe.WritePWSafeFile(db, path); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "Fixed (%d):\n", len(fixed))
+			writeIssues(w, fixed, false)
+		}
+	}
+
+	issues := db.Validate()
+	if len(issues) == 0 {
+		return nil
+	}
+	if fix {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "Problems (%d):\n", len(issues))
+	writeIssues(w, issues, !fix)
+	return exitStatus(3)
+}
+
+// writeIssues prints one line for each issue, hint marks those -fix can fix.
+func writeIssues(w io.Writer, issues []pwsafe.Issue, hint bool) {
+	for _, issue := range issues {
+		name := "header"
+		if issue.Kind != pwsafe.IssueNamedPolicies {
+			name = fmt.Sprintf("%s (%x)", issue.Title, issue.UUID)
+			if issue.Group != "" {
+				name = issue.Group + "/" + name
+			}
+		}
+		fixable := ""
+		if hint && issue.Fixable {
+			fixable = ", fixable with -fix"
+		}
+		fmt.Fprintf(w, "  %-16s  %s: %s%s\n", issue.Kind, name, issue.Message, fixable)
+	}
+}
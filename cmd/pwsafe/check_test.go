@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+func TestWriteIssues(t *testing.T) {
+	db := pwsafe.NewV3("test", "password")
+	db.SetRecord(pwsafe.Record{Title: "no password", Group: "Work"})
+	db.Records[[16]byte{1}] = pwsafe.Record{Title: "mismatched", Password: "pw"}
+	db.Header.PasswordPolicy = "zz"
+
+	var buf bytes.Buffer
+	writeIssues(&buf, db.Validate(), true)
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 3)
+	out := buf.String()
+	assert.Contains(t, out, "named-policies    header: ")
+	assert.Contains(t, out, "missing-password  Work/no password (")
+	assert.Contains(t, out, "invalid-uuid      mismatched (01000000000000000000000000000000): the UUID 00000000000000000000000000000000 doesn't match its key 01000000000000000000000000000000, fixable with -fix")
+
+	buf.Reset()
+	writeIssues(&buf, db.Fix(), false)
+	assert.Equal(t, "  invalid-uuid      mismatched (01000000000000000000000000000000): the UUID 00000000000000000000000000000000 doesn't match its key 01000000000000000000000000000000\n", buf.String())
+}
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "check.psafe3")
+	db := pwsafe.NewV3("test", "password")
+	db.SetRecord(pwsafe.Record{Title: "policy", Password: "pw", PasswordPolicyName: "Missing"})
+	db.Records[[16]byte{1}] = pwsafe.Record{Title: "mismatched", Password: "pw"}
+
+	var buf bytes.Buffer
+	err := check(&buf, db, path, false)
+	assert.Equal(t, exitStatus(3), err)
+	assert.Contains(t, buf.String(), "Problems (2):\n")
+	assert.NoFileExists(t, path, "the db is only saved with fix")
+
+	buf.Reset()
+	err = check(&buf, db, path, true)
+	assert.Equal(t, exitStatus(3), err, "the unknown policy isn't fixed")
+	assert.Contains(t, buf.String(), "Fixed (1):\n")
+	assert.Contains(t, buf.String(), "Problems (1):\n")
+	saved, err := pwsafe.OpenPWSafeFile(path, "password")
+	require.NoError(t, err)
+	_, ok := saved.Records[[16]byte{1}]
+	assert.True(t, ok)
+	assert.Len(t, saved.Validate(), 1)
+
+	db.DeleteRecord(db.Validate()[0].UUID)
+	buf.Reset()
+	assert.NoError(t, check(&buf, db, path, true))
+	assert.Empty(t, buf.String())
+}
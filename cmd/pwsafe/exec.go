@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/tkuhlman/gopwsafe/internal/passwd"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// envFlags collects repeated -env VAR=reference flags.
+type envFlags []string
+
+func (e *envFlags) String() string { return strings.Join(*e, ",") }
+
+func (e *envFlags) Set(value string) error {
+	if name, _, ok := strings.Cut(value, "="); !ok || name == "" {
+		return errors.New("expected VAR=" + pwsafe.RefScheme + "<group>/<title>/<field>")
+	}
+	*e = append(*e, value)
+	return nil
+}
+
+// runExec runs a command with environment variables set from record fields, exiting with the command's status. The
+// db password isn't passed on in the environment.
+func runExec(args []string) error {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	var envs envFlags
+	fs.Var(&envs, "env", "set VAR to a record field, VAR="+pwsafe.RefScheme+"<group>/<title>/<field> or <uuid>/<field>, may be repeated")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pwsafe exec [flags] <db file> -- <command> [args]\n\n"+
+			"Environment variables whose value is a %s reference are also resolved.\n"+
+			"Example: pwsafe exec -env DB_PASS=pws://Work.DB/prod/password team.psafe3 -- ./migrate\n\n", pwsafe.RefScheme)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	rest := fs.Args()
+	if len(rest) > 1 && rest[1] == "--" {
+		rest = append(rest[:1], rest[2:]...)
+	}
+	if len(rest) < 2 {
+		return errors.New("expected a db file and a command")
+	}
+
+	// stdin is left for the command
+	db, err := openDBNoStdin(rest[0])
+	if err != nil {
+		return err
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if name == passwd.EnvVar {
+			continue
+		}
+		if strings.HasPrefix(value, pwsafe.RefScheme) {
+			if value, err = db.Resolve(value); err != nil {
+				db.Close()
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		env = append(env, name+"="+value)
+	}
+	for _, kv := range envs {
+		name, ref, _ := strings.Cut(kv, "=")
+		value, err := db.Resolve(ref)
+		if err != nil {
+			db.Close()
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		env = append(env, name+"="+value)
+	}
+	db.Close()
+
+	cmd := exec.Command(rest[1], rest[2:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Pass signals on to the command rather than exiting before it
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	err = cmd.Wait()
+	signal.Stop(signals)
+	return commandStatus(err)
+}
+
+// commandStatus returns an exitStatus with the status of a command which ran, a command killed by a signal gives 128
+// plus the signal number as it does in a shell.
+func commandStatus(err error) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return exitStatus(128 + int(status.Signal()))
+	}
+	return exitStatus(exitErr.ExitCode())
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	assert.NoError(t, commandStatus(exec.Command("sh", "-c", "exit 0").Run()))
+	assert.Equal(t, exitStatus(4), commandStatus(exec.Command("sh", "-c", "exit 4").Run()))
+	assert.Equal(t, exitStatus(128+15), commandStatus(exec.Command("sh", "-c", "kill -TERM $$").Run()), "killed by SIGTERM")
+
+	err := errors.New("not started")
+	assert.Equal(t, err, commandStatus(err))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// runExpiring prints records with expired passwords and those expiring within the given number of days.
+// Nothing is printed when there is nothing to report so it can be run from cron which only mails non-empty output.
+func runExpiring(args []string) error {
+	fs := flag.NewFlagSet("expiring", flag.ExitOnError)
+	days := fs.Int("days", 7, "report passwords expiring within this many days")
+	failExpired := fs.Bool("fail", false, "exit with status 3 when any password has expired")
+	fs.Parse(args)
+	path, err := dbPathArg(fs)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	expired, expiring := db.Expiring(time.Duration(*days) * 24 * time.Hour)
+	writeExpiryReport(os.Stdout, expired, expiring, *days)
+
+	if *failExpired && len(expired) > 0 {
+		return exitStatus(3)
+	}
+	return nil
+}
+
+func writeExpiryReport(w io.Writer, expired, expiring []pwsafe.Record, days int) {
+	if len(expired) > 0 {
+		fmt.Fprintf(w, "Expired passwords (%d):\n", len(expired))
+		writeExpiryLines(w, expired)
+	}
+	if len(expiring) > 0 {
+		if len(expired) > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Passwords expiring within %d days (%d):\n", days, len(expiring))
+		writeExpiryLines(w, expiring)
+	}
+}
+
+func writeExpiryLines(w io.Writer, records []pwsafe.Record) {
+	for _, record := range records {
+		name := record.Title
+		if record.Group != "" {
+			name = record.Group + "/" + record.Title
+		}
+		if record.Username != "" {
+			name += " (" + record.Username + ")"
+		}
+		fmt.Fprintf(w, "  %s  %s\n", record.PasswordExpiry.Format("2006-01-02"), name)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// runInject writes a template with its {{ pws://group/title/field }} references replaced by the field values.
+func runInject(args []string) error {
+	fs := flag.NewFlagSet("inject", flag.ExitOnError)
+	out := fs.String("o", "", "write to this file, created with mode 0600, instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pwsafe inject [flags] <db file> [template file]\n\n"+
+			"The template is read from stdin when no file is given.\n"+
+			"Example template line: password = {{ pws://Work.DB/prod/password }}\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return errors.New("expected a db file and an optional template file")
+	}
+
+	var template []byte
+	var err error
+	if fs.NArg() == 2 {
+		template, err = os.ReadFile(fs.Arg(1))
+	} else {
+		template, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+
+	// stdin may hold the template
+	db, err := openDBNoStdin(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rendered, err := renderTemplate(db, string(template))
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err = io.WriteString(os.Stdout, rendered)
+		return err
+	}
+	return os.WriteFile(*out, []byte(rendered), 0o600)
+}
+
+// templateRef matches a reference in a template, {{ pws://group/title/field }}.
+var templateRef = regexp.MustCompile(`\{\{\s*(` + regexp.QuoteMeta(pwsafe.RefScheme) + `[^\s}]*)\s*\}\}`)
+
+// renderTemplate replaces the references in text with their values.
+func renderTemplate(db *pwsafe.V3, text string) (string, error) {
+	var err error
+	rendered := templateRef.ReplaceAllStringFunc(text, func(match string) string {
+		if err != nil {
+			return ""
+		}
+		var value string
+		value, err = db.Resolve(templateRef.FindStringSubmatch(match)[1])
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return rendered, nil
+}
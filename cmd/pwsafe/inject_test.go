@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	db := pwsafe.NewV3("refs", "password")
+	db.SetRecord(pwsafe.Record{Title: "prod", Group: "Work.DB", Username: "admin", Password: "prod-pass"})
+	db.SetRecord(pwsafe.Record{Title: "dup", Group: "Work", Password: "one"})
+	db.SetRecord(pwsafe.Record{Title: "dup", Group: "Work", Password: "two"})
+
+	rendered, err := renderTemplate(db, "user = {{ pws://Work.DB/prod/username }}\npass = {{pws://Work.DB/prod/password}}\nkeep = {{ other }}\n")
+	require.NoError(t, err)
+	assert.Equal(t, "user = admin\npass = prod-pass\nkeep = {{ other }}\n", rendered)
+
+	_, err = renderTemplate(db, "a = {{ pws://Work/dup/password }}")
+	assert.Error(t, err)
+	_, err = renderTemplate(db, "a = {{ pws://Work.DB/prod/email }}")
+	assert.Error(t, err)
+}
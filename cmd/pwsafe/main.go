@@ -0,0 +1,103 @@
+// pwsafe is a command line interface to Password Safe v3 databases.
+// The database password is read from the PWSAFE_PASSWORD environment variable when set, otherwise it is prompted for.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/tkuhlman/gopwsafe/internal/passwd"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// command is a pwsafe subcommand, run is passed the arguments following the command name.
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"agent":          {"serve the SSH keys stored in records as an SSH agent", runAgent},
+	"audit":          {"report weak, reused, recycled and old passwords", runAudit},
+	"breached":       {"list records whose password is in a local Pwned Passwords hash list", runBreached},
+	"check":          {"report problems with the records of a db and optionally fix them", runCheck},
+	"exec":           {"run a command with environment variables set from records", runExec},
+	"expiring":       {"report records with expired or soon to expire passwords", runExpiring},
+	"inject":         {"fill in the record references in a template", runInject},
+	"repair":         {"save what can be read of a damaged db to a new file", runRepair},
+	"search":         {"list records matching a query", runSearch},
+	"secret-service": {"serve the db as the freedesktop.org Secret Service keyring", runSecretService},
+	"url":            {"list records for a URL, best match first", runURL},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		var status exitStatus
+		if errors.As(err, &status) {
+			os.Exit(int(status))
+		}
+		fmt.Fprintf(os.Stderr, "pwsafe %s: %s\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+// exitStatus is returned by a command which has already reported its result to exit with that status, deferred
+// calls such as closing the db are run first.
+type exitStatus int
+
+func (s exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: pwsafe <command> [flags] <db file>\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", name, commands[name].summary)
+	}
+}
+
+// openDB opens the db at path reading the password from PWSAFE_PASSWORD or prompting for it.
+func openDB(path string) (*pwsafe.V3, error) {
+	password, err := passwd.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	return pwsafe.OpenPWSafeFile(path, password)
+}
+
+// openDBNoStdin is openDB for commands which pass stdin on, the password is only prompted for on the terminal.
+func openDBNoStdin(path string) (*pwsafe.V3, error) {
+	password, err := passwd.ReadTTY(path)
+	if err != nil {
+		return nil, err
+	}
+	return pwsafe.OpenPWSafeFile(path, password)
+}
+
+// dbPathArg returns the single db path positional argument of fs.
+func dbPathArg(fs *flag.FlagSet) (string, error) {
+	if fs.NArg() != 1 {
+		return "", errors.New("expected exactly one db file argument")
+	}
+	return fs.Arg(0), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tkuhlman/gopwsafe/internal/passwd"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// runRepair reads what it can of a damaged db and saves it to a new file with the same password, printing each
+// problem skipped. The damaged db is left as it is and an existing output file is never overwritten.
+func runRepair(args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	out := fs.String("o", "", "the file to save the repaired db to, it must not exist")
+	fs.Parse(args)
+	path, err := dbPathArg(fs)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("-o is required")
+	}
+	if filepath.Clean(*out) == filepath.Clean(path) {
+		return errors.New("the repaired db must be saved to a new file")
+	}
+
+	password, err := passwd.Read(path)
+	if err != nil {
+		return err
+	}
+	db, warnings, err := pwsafe.OpenPWSafeFileWithOptions(path, password, pwsafe.DecryptOptions{Recover: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := db.Encrypt(f); err != nil {
+		f.Close()
+		os.Remove(*out)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("saved %d records to %s with %d problems skipped\n", len(db.Snapshot()), *out, len(warnings))
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe/query"
+)
+
+// searchResult is a record found by search without its password.
+type searchResult struct {
+	UUID     string `json:"uuid"`
+	Title    string `json:"title"`
+	Group    string `json:"group"`
+	Username string `json:"username,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+// runSearch lists the records matching a query, the query syntax is described in the pwsafe/query package.
+func runSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the records as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pwsafe search [flags] <db file> <query>\n\n"+
+			"Example query: group:work (github OR gitlab) -user:admin modified:<30d\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		return errors.New("expected a db file argument")
+	}
+	q, err := query.Compile(strings.Join(fs.Args()[1:], " "))
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	records := query.Search(db, q)
+
+	if *asJSON {
+		results := make([]searchResult, 0, len(records))
+		for _, record := range records {
+			results = append(results, searchResult{
+				UUID:     fmt.Sprintf("%x", record.UUID),
+				Title:    record.Title,
+				Group:    record.Group,
+				Username: record.Username,
+				URL:      record.URL,
+			})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	for _, record := range records {
+		name := record.Title
+		if record.Group != "" {
+			name = record.Group + "/" + record.Title
+		}
+		if record.Username != "" {
+			name += " (" + record.Username + ")"
+		}
+		fmt.Printf("%x  %s\n", record.UUID, name)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+	"github.com/tkuhlman/gopwsafe/pwsafe/secretservice"
+)
+
+// runSecretService serves the db as the Secret Service on the session bus until interrupted.
+func runSecretService(args []string) error {
+	fs := flag.NewFlagSet("secret-service", flag.ExitOnError)
+	group := fs.String("group", secretservice.DefaultGroup, "the group items created by applications are added to")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pwsafe secret-service [flags] <db file>\n\nServes the db as the Secret Service on the session bus until interrupted.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	path, err := dbPathArg(fs)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	service, err := secretservice.Export(conn, db, secretservice.Options{
+		Group: *group,
+		Save:  func() error { return pwsafe.WritePWSafeFile(db, path) },
+	})
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+
+	fmt.Fprintf(os.Stderr, "serving %s as %s, interrupt to stop\n", path, secretservice.BusName)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	return nil
+}
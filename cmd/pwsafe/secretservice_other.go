@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func runSecretService(args []string) error {
+	return errors.New("the Secret Service is only available on Linux")
+}
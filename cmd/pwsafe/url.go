@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// urlResult is a record matched by url without its password.
+type urlResult struct {
+	UUID     string `json:"uuid"`
+	Title    string `json:"title"`
+	Group    string `json:"group"`
+	Username string `json:"username,omitempty"`
+	URL      string `json:"url"`
+	Match    string `json:"match"`
+}
+
+var urlMatchModes = map[string]pwsafe.URLMatchMode{
+	"domain": pwsafe.MatchDomain,
+	"host":   pwsafe.MatchHost,
+	"exact":  pwsafe.MatchExact,
+}
+
+// runURL lists the records for a URL, best match first.
+func runURL(args []string) error {
+	fs := flag.NewFlagSet("url", flag.ExitOnError)
+	modeName := fs.String("mode", "domain", "how closely the record URL must match, domain, host or exact")
+	asJSON := fs.Bool("json", false, "print the records as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pwsafe url [flags] <db file> <url>\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New("expected a db file and a url argument")
+	}
+	mode, ok := urlMatchModes[*modeName]
+	if !ok {
+		return fmt.Errorf("unknown mode %q", *modeName)
+	}
+
+	db, err := openDB(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	matches, err := db.MatchURL(fs.Arg(1), mode)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		results := make([]urlResult, 0, len(matches))
+		for _, m := range matches {
+			results = append(results, urlResult{
+				UUID:     fmt.Sprintf("%x", m.Record.UUID),
+				Title:    m.Record.Title,
+				Group:    m.Record.Group,
+				Username: m.Record.Username,
+				URL:      m.Record.URL,
+				Match:    m.Mode.String(),
+			})
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	for _, m := range matches {
+		name := m.Record.Title
+		if m.Record.Group != "" {
+			name = m.Record.Group + "/" + m.Record.Title
+		}
+		if m.Record.Username != "" {
+			name += " (" + m.Record.Username + ")"
+		}
+		fmt.Printf("%x  %-6s  %s  %s\n", m.Record.UUID, m.Mode, name, m.Record.URL)
+	}
+	return nil
+}
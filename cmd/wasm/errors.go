@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// Error codes returned to the PWA with an error message so it can check why a db failed to open without matching
+// the message.
+const (
+	codeInvalidPassword = "invalid_password"
+	codeNotPWS3         = "not_pws3"
+	codeHMACMismatch    = "hmac_mismatch"
+	codeFieldError      = "field_error"
+	codeUnknown         = "unknown"
+)
+
+// errorCode returns the code for an error from the pwsafe package.
+func errorCode(err error) string {
+	var fieldErr *pwsafe.FieldError
+	switch {
+	case errors.Is(err, pwsafe.ErrInvalidPassword):
+		return codeInvalidPassword
+	case errors.Is(err, pwsafe.ErrNotPWS3):
+		return codeNotPWS3
+	case errors.Is(err, pwsafe.ErrHMACMismatch):
+		return codeHMACMismatch
+	case errors.As(err, &fieldErr):
+		return codeFieldError
+	}
+	return codeUnknown
+}
+
+// jsError returns an error as an object with the message and code for JS, { error: string, code: string }.
+func jsError(message string, err error) any {
+	return map[string]any{"error": message, "code": errorCode(err)}
+}
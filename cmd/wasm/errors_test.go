@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+func TestErrorCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code string
+	}{
+		{pwsafe.ErrInvalidPassword, codeInvalidPassword},
+		{fmt.Errorf("failed to unlock: %w", pwsafe.ErrInvalidPassword), codeInvalidPassword},
+		{pwsafe.ErrNotPWS3, codeNotPWS3},
+		{pwsafe.ErrHMACMismatch, codeHMACMismatch},
+		{fmt.Errorf("parsing: %w", &pwsafe.FieldError{RecordIndex: 2, Err: errors.New("bad")}), codeFieldError},
+		{errors.New("other"), codeUnknown},
+	} {
+		assert.Equal(t, tc.code, errorCode(tc.err), tc.err.Error())
+	}
+	assert.Equal(t, map[string]any{"error": "failed", "code": codeInvalidPassword}, jsError("failed", pwsafe.ErrInvalidPassword))
+}
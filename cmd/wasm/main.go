@@ -12,10 +12,13 @@ import (
 	"time"
 
 	"github.com/tkuhlman/gopwsafe/pwsafe"
+	"github.com/tkuhlman/gopwsafe/pwsafe/audit"
+	"github.com/tkuhlman/gopwsafe/pwsafe/query"
 )
 
 var db *pwsafe.V3
 
+// openDB decrypts the db returning null on success, the errors from decrypting are objects with a code, see jsError.
 func openDB(this js.Value, args []js.Value) any {
 	if len(args) != 2 {
 		return "invalid arguments: expected (data, password)"
@@ -34,7 +37,7 @@ func openDB(this js.Value, args []js.Value) any {
 	newDB := &pwsafe.V3{}
 	_, err := newDB.Decrypt(reader, password)
 	if err != nil {
-		return fmt.Sprintf("failed to decrypt: %s", err)
+		return jsError(fmt.Sprintf("failed to decrypt: %s", err), err)
 	}
 
 	db = newDB
@@ -54,7 +57,7 @@ func getDBData(this js.Value, args []js.Value) any {
 
 	var items []Item
 
-	for _, rec := range db.Records {
+	for _, rec := range db.Snapshot() {
 		uuidStr := fmt.Sprintf("%x", rec.UUID)
 		items = append(items, Item{
 			UUID:  uuidStr,
@@ -97,7 +100,7 @@ func getRecord(this js.Value, args []js.Value) any {
 	var uuidBytes [16]byte
 	copy(uuidBytes[:], bytes)
 
-	rec, ok := db.Records[uuidBytes]
+	rec, ok := db.Record(uuidBytes)
 	if !ok {
 		return "record not found"
 	}
@@ -110,6 +113,42 @@ func getRecord(this js.Value, args []js.Value) any {
 	return string(jsonData)
 }
 
+func closeDB(this js.Value, args []js.Value) any {
+	if db != nil {
+		db.Close()
+		db = nil
+	}
+	return nil
+}
+
+func lockDB(this js.Value, args []js.Value) any {
+	if db == nil {
+		return "database not open"
+	}
+	if err := db.Lock(); err != nil {
+		return fmt.Sprintf("failed to lock: %s", err)
+	}
+	return nil
+}
+
+// unlockDB unlocks the db returning null on success, a wrong password is an object with a code, see jsError.
+func unlockDB(this js.Value, args []js.Value) any {
+	if db == nil {
+		return "database not open"
+	}
+	if len(args) != 1 {
+		return "invalid arguments: expected (password)"
+	}
+	if err := db.Unlock(args[0].String()); err != nil {
+		return jsError(fmt.Sprintf("failed to unlock: %s", err), err)
+	}
+	return nil
+}
+
+func isLocked(this js.Value, args []js.Value) any {
+	return db != nil && db.Locked()
+}
+
 func createDatabase(this js.Value, args []js.Value) any {
 	if len(args) != 1 {
 		return "invalid arguments: expected (password)"
@@ -198,29 +237,144 @@ func updateDBInfo(this js.Value, args []js.Value) any {
 	name := args[0].String()
 	description := args[1].String()
 
-	db.Header.Name = name
-	db.Header.Description = description
+	db.SetHeaderInfo(name, description)
 
 	return nil
 }
 
+// historyState reports whether the last change was applied and if there are changes to undo or redo.
+func historyState(changed bool) string {
+	return fmt.Sprintf(`{"changed":%t,"canUndo":%t,"canRedo":%t}`, changed, db.CanUndo(), db.CanRedo())
+}
+
+func undoDB(this js.Value, args []js.Value) any {
+	if db == nil {
+		return `{"error":"database not open"}`
+	}
+	return historyState(db.Undo())
+}
+
+func redoDB(this js.Value, args []js.Value) any {
+	if db == nil {
+		return `{"error":"database not open"}`
+	}
+	return historyState(db.Redo())
+}
+
+func getHistory(this js.Value, args []js.Value) any {
+	if db == nil {
+		return `{"error":"database not open"}`
+	}
+	return historyState(false)
+}
+
+// searchRecords returns the hex UUIDs of the records matching the query, sorted by UUID or when the optional fuzzy
+// argument is true by relevance with the best match first.
 func searchRecords(this js.Value, args []js.Value) any {
 	if db == nil {
 		return "database not open"
 	}
-	if len(args) != 2 {
-		return "invalid arguments: expected (query, namesOnly)"
+	if len(args) != 2 && len(args) != 3 {
+		return "invalid arguments: expected (query, namesOnly[, fuzzy])"
 	}
-	query := args[0].String()
+	input := args[0].String()
 	namesOnly := args[1].Bool()
-	titles := db.Search(query, namesOnly)
-	jsonData, err := json.Marshal(titles)
+	var uuids []string
+	if len(args) == 3 && args[2].Truthy() {
+		uuids = []string{}
+		for _, result := range query.FuzzySearch(db, input, namesOnly) {
+			uuids = append(uuids, fmt.Sprintf("%x", result.Record.UUID))
+		}
+	} else {
+		uuids = db.Search(input, namesOnly)
+	}
+	jsonData, err := json.Marshal(uuids)
 	if err != nil {
 		return fmt.Sprintf("json marshal error: %s", err)
 	}
 	return string(jsonData)
 }
 
+// queryRecords returns the hex UUIDs of the records matching a query in the pwsafe/query syntax.
+func queryRecords(this js.Value, args []js.Value) any {
+	if db == nil {
+		return `{"error":"database not open"}`
+	}
+	if len(args) != 1 {
+		return `{"error":"invalid arguments: expected (query)"}`
+	}
+	q, err := query.Compile(args[0].String())
+	if err != nil {
+		errJSON, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return string(errJSON)
+	}
+	uuids := []string{}
+	for _, record := range query.Search(db, q) {
+		uuids = append(uuids, fmt.Sprintf("%x", record.UUID))
+	}
+	jsonData, err := json.Marshal(uuids)
+	if err != nil {
+		return fmt.Sprintf(`{"error":"json marshal error: %s"}`, err)
+	}
+	return string(jsonData)
+}
+
+// matchURL returns the records for a URL best match first, mode is "domain", "host" or "exact".
+func matchURL(this js.Value, args []js.Value) any {
+	if db == nil {
+		return `{"error":"database not open"}`
+	}
+	if len(args) != 2 {
+		return `{"error":"invalid arguments: expected (url, mode)"}`
+	}
+	modes := map[string]pwsafe.URLMatchMode{"domain": pwsafe.MatchDomain, "host": pwsafe.MatchHost, "exact": pwsafe.MatchExact}
+	mode, ok := modes[args[1].String()]
+	if !ok {
+		return `{"error":"invalid mode, expected domain, host or exact"}`
+	}
+	matches, err := db.MatchURL(args[0].String(), mode)
+	if err != nil {
+		errJSON, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return string(errJSON)
+	}
+
+	type Item struct {
+		UUID  string `json:"uuid"`
+		Title string `json:"title"`
+		Group string `json:"group"`
+		Match string `json:"match"`
+	}
+	items := []Item{}
+	for _, m := range matches {
+		items = append(items, Item{
+			UUID:  fmt.Sprintf("%x", m.Record.UUID),
+			Title: m.Record.Title,
+			Group: m.Record.Group,
+			Match: m.Mode.String(),
+		})
+	}
+	jsonData, err := json.Marshal(items)
+	if err != nil {
+		return fmt.Sprintf(`{"error":"json marshal error: %s"}`, err)
+	}
+	return string(jsonData)
+}
+
+func auditDB(this js.Value, args []js.Value) any {
+	if db == nil {
+		return `{"error":"database not open"}`
+	}
+	findings := audit.Scan(db, audit.DefaultOptions)
+	if findings == nil {
+		findings = []audit.Finding{}
+	}
+	jsonData, err := json.Marshal(findings)
+	if err != nil {
+		return fmt.Sprintf(`{"error":"json marshal error: %s"}`, err)
+	}
+	return string(jsonData)
+}
+
 func getSuggestion(this js.Value, args []js.Value) interface{} {
 	if db == nil {
 		return ""
@@ -236,7 +390,7 @@ func getSuggestion(this js.Value, args []js.Value) interface{} {
 
 	prefixLower := strings.ToLower(prefix)
 	freq := make(map[string]int)
-	for _, rec := range db.Records {
+	for _, rec := range db.Snapshot() {
 		var val string
 		switch field {
 		case "group":
@@ -278,14 +432,24 @@ func main() {
 	js.Global().Set("getDBData", js.FuncOf(getDBData))
 	js.Global().Set("getRecord", js.FuncOf(getRecord))
 	js.Global().Set("createDatabase", js.FuncOf(createDatabase))
+	js.Global().Set("closeDB", js.FuncOf(closeDB))
+	js.Global().Set("lockDB", js.FuncOf(lockDB))
+	js.Global().Set("unlockDB", js.FuncOf(unlockDB))
+	js.Global().Set("isLocked", js.FuncOf(isLocked))
 	js.Global().Set("getDBInfo", js.FuncOf(getDBInfo))
 	js.Global().Set("saveDB", js.FuncOf(saveDB))
 	js.Global().Set("addRecord", js.FuncOf(addRecord))
 	js.Global().Set("updateRecord", js.FuncOf(updateRecord))
 	js.Global().Set("deleteRecord", js.FuncOf(deleteRecord))
 	js.Global().Set("updateDBInfo", js.FuncOf(updateDBInfo))
+	js.Global().Set("undoDB", js.FuncOf(undoDB))
+	js.Global().Set("redoDB", js.FuncOf(redoDB))
+	js.Global().Set("getHistory", js.FuncOf(getHistory))
 	js.Global().Set("searchRecords", js.FuncOf(searchRecords))
+	js.Global().Set("queryRecords", js.FuncOf(queryRecords))
+	js.Global().Set("matchURL", js.FuncOf(matchURL))
 	js.Global().Set("getSuggestion", js.FuncOf(getSuggestion))
+	js.Global().Set("auditDB", js.FuncOf(auditDB))
 
 	fmt.Println("WASM initialized")
 	<-c
@@ -331,7 +495,7 @@ func (dto *RecordDTO) toRecord() (pwsafe.Record, error) {
 	r.PasswordExpiry, _ = time.Parse(time.RFC3339, dto.PasswordExpiry)
 	r.PasswordExpiryInterval = dto.PasswordExpiryInterval
 	r.PasswordHistory = dto.PasswordHistory
-	r.PasswordModTime = dto.PasswordModTime
+	r.PasswordModTime, _ = time.Parse(time.RFC3339, dto.PasswordModTime)
 	r.PasswordPolicy = dto.PasswordPolicy
 	r.PasswordPolicyName = dto.PasswordPolicyName
 	r.ProtectedEntry = dto.ProtectedEntry
@@ -365,6 +529,9 @@ func addRecord(this js.Value, args []js.Value) any {
 	if db == nil {
 		return `{"error":"database not open"}`
 	}
+	if db.Locked() {
+		return `{"error":"database is locked"}`
+	}
 	if len(args) != 1 {
 		return `{"error":"invalid arguments: expected (recordJSON)"}`
 	}
@@ -383,6 +550,9 @@ func updateRecord(this js.Value, args []js.Value) any {
 	if db == nil {
 		return `{"error":"database not open"}`
 	}
+	if db.Locked() {
+		return `{"error":"database is locked"}`
+	}
 	if len(args) != 2 {
 		return `{"error":"invalid arguments: expected (oldUUID, recordJSON)"}`
 	}
@@ -402,10 +572,7 @@ func updateRecord(this js.Value, args []js.Value) any {
 
 	record, _ := dto.toRecord()
 
-	if oldUUID != record.UUID {
-		db.DeleteRecord(oldUUID)
-	}
-	db.SetRecord(record)
+	db.ReplaceRecord(oldUUID, record)
 	return `{"success":true}`
 }
 
@@ -413,6 +580,9 @@ func deleteRecord(this js.Value, args []js.Value) any {
 	if db == nil {
 		return `{"error":"database not open"}`
 	}
+	if db.Locked() {
+		return `{"error":"database is locked"}`
+	}
 	if len(args) != 1 {
 		return `{"error":"invalid arguments: expected (uuid)"}`
 	}
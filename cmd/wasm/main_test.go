@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+func TestRecordChangesWhileLocked(t *testing.T) {
+	db = pwsafe.NewV3("locked", "password")
+	defer func() { db = nil }()
+	id := db.SetRecord(pwsafe.Record{Title: "kept", Password: "password"})
+	uuid := fmt.Sprintf("%x", id)
+	require.NoError(t, db.Lock())
+
+	locked := `{"error":"database is locked"}`
+	assert.Equal(t, locked, addRecord(js.Undefined(), []js.Value{js.ValueOf(`{"title":"new","password":"pw"}`)}))
+	assert.Equal(t, locked, updateRecord(js.Undefined(), []js.Value{js.ValueOf(uuid), js.ValueOf(`{"title":"changed"}`)}))
+	assert.Equal(t, locked, deleteRecord(js.Undefined(), []js.Value{js.ValueOf(uuid)}))
+
+	require.NoError(t, db.Unlock("password"))
+	assert.Len(t, db.Records, 1)
+	record, ok := db.Record(id)
+	assert.True(t, ok)
+	assert.Equal(t, "kept", record.Title)
+}
@@ -3,14 +3,18 @@ module github.com/tkuhlman/gopwsafe
 go 1.25.5
 
 require (
+	github.com/godbus/dbus/v5 v5.2.2
 	github.com/pborman/uuid v1.2.1
 	github.com/stretchr/testify v1.11.1
 	golang.org/x/crypto v0.52.0
+	golang.org/x/net v0.54.0
+	golang.org/x/term v0.43.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/google/uuid v1.0.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.45.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
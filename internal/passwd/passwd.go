@@ -0,0 +1,51 @@
+// Package passwd reads the password of a Password Safe db for the commands.
+package passwd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// EnvVar is the environment variable the password is read from when set.
+const EnvVar = "PWSAFE_PASSWORD"
+
+// Read returns the password for the db at path from the environment, the terminal or the first line of stdin.
+func Read(path string) (string, error) {
+	if passwd, ok := os.LookupEnv(EnvVar); ok {
+		return passwd, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprintf(os.Stderr, "Password for %s: ", path)
+		passwd, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(passwd), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password supplied, set " + EnvVar + " or pipe it on stdin")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// ReadTTY is Read for commands whose stdin is in use, the password is prompted for on the controlling terminal rather
+// than read from stdin.
+func ReadTTY(path string) (string, error) {
+	if passwd, ok := os.LookupEnv(EnvVar); ok {
+		return passwd, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("no password supplied and no terminal to prompt on, set " + EnvVar)
+	}
+	defer tty.Close()
+	fmt.Fprintf(tty, "Password for %s: ", path)
+	passwd, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return string(passwd), err
+}
@@ -67,11 +67,30 @@ export async function loadWasm() {
     console.log("WASM loaded");
 }
 
+// Error codes set on the errors thrown by openDatabase, from cmd/wasm/errors.go.
+export const ErrorCodes = {
+    invalidPassword: "invalid_password",
+    notPWS3: "not_pws3",
+    hmacMismatch: "hmac_mismatch",
+    fieldError: "field_error",
+    unknown: "unknown",
+};
+
+// toError converts an error returned by the WASM bridge, a message or an object with the message and code.
+function toError(err) {
+    if (typeof err === 'string') {
+        return new Error(err);
+    }
+    const e = new Error(err.error);
+    e.code = err.code;
+    return e;
+}
+
 export function openDatabase(fileData, password) {
     // fileData should be Uint8Array
     const err = window.openDB(fileData, password);
     if (err) {
-        throw new Error(err);
+        throw toError(err);
     }
 }
 
@@ -155,14 +174,40 @@ export function updateDBInfo(name, description) {
     }
 }
 
-export function searchRecords(query, namesOnly) {
-    const res = window.searchRecords(query, namesOnly);
+export function undo() {
+    const parsed = JSON.parse(window.undoDB());
+    if (parsed.error) {
+        throw new Error(parsed.error);
+    }
+    return parsed; // { changed, canUndo, canRedo }
+}
+
+export function redo() {
+    const parsed = JSON.parse(window.redoDB());
+    if (parsed.error) {
+        throw new Error(parsed.error);
+    }
+    return parsed;
+}
+
+// searchRecords returns matching UUIDs, with fuzzy set they are ranked best match first
+export function searchRecords(query, namesOnly, fuzzy = false) {
+    const res = window.searchRecords(query, namesOnly, fuzzy);
     if (typeof res === 'string' && res.startsWith("database not open")) {
         throw new Error(res);
     }
     return JSON.parse(res);
 }
 
+// queryRecords returns the UUIDs of records matching a query such as `group:work (github OR gitlab) -user:admin`
+export function queryRecords(query) {
+    const parsed = JSON.parse(window.queryRecords(query));
+    if (parsed.error) {
+        throw new Error(parsed.error);
+    }
+    return parsed;
+}
+
 export function getAutocompleteSuggestion(field, prefix) {
     return window.getSuggestion(field, prefix) || "";
 }
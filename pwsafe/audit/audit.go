@@ -0,0 +1,176 @@
+// Package audit reviews the passwords in a Password Safe v3 database reporting weak, reused, recycled and old passwords.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// Kind identifies the type of problem a Finding reports.
+type Kind string
+
+const (
+	// KindWeak is a password which scores below Options.MinScore.
+	KindWeak Kind = "weak"
+	// KindReused is a password used by more than one record.
+	KindReused Kind = "reused"
+	// KindInHistory is a password which also appears in the record's own password history.
+	KindInHistory Kind = "in-history"
+	// KindOld is a password not changed in more than Options.MaxAgeDays.
+	KindOld Kind = "old"
+)
+
+// Options control which findings Scan reports.
+type Options struct {
+	// MinScore is the lowest Estimate.Score not reported as weak, 0 disables the weak check.
+	MinScore int
+	// MaxAgeDays is the age in days after which a password is reported as old, 0 disables the check.
+	MaxAgeDays int
+	// Now is the time the password age is measured against, the zero value uses time.Now().
+	Now time.Time
+}
+
+// DefaultOptions reports passwords scoring below 3 and passwords older than a year.
+var DefaultOptions = Options{MinScore: 3, MaxAgeDays: 365}
+
+// Finding is a single problem with a single record.
+type Finding struct {
+	Kind    Kind     `json:"kind"`
+	UUID    [16]byte `json:"-"`
+	Title   string   `json:"title"`
+	Group   string   `json:"group"`
+	Message string   `json:"message"`
+	// Strength is set for weak password findings.
+	Strength *Estimate `json:"strength,omitempty"`
+	// Related are the other records sharing the password for reused findings.
+	Related [][16]byte `json:"-"`
+	// Age is the days since the password was changed for old password findings.
+	Age int `json:"age,omitempty"`
+}
+
+// Scan audits all records in the db returning the findings sorted by kind, group and title.
+func Scan(db *pwsafe.V3, opts Options) []Finding {
+	return ScanRecords(db.Snapshot(), opts)
+}
+
+// ScanRecords audits the given records, see Scan.
+func ScanRecords(records []pwsafe.Record, opts Options) []Finding {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	var findings []Finding
+	byPassword := make(map[string][]pwsafe.Record)
+	for _, record := range records {
+		if record.Password == "" {
+			continue
+		}
+		byPassword[record.Password] = append(byPassword[record.Password], record)
+
+		if opts.MinScore > 0 {
+			if est := Strength(record.Password); est.Score < opts.MinScore {
+				findings = append(findings, newFinding(KindWeak, record, weakMessage(est)))
+				findings[len(findings)-1].Strength = &est
+			}
+		}
+
+		if history, err := pwsafe.ParsePasswordHistory(record.PasswordHistory); err == nil {
+			for _, entry := range history.Entries {
+				if entry.Password == record.Password {
+					msg := fmt.Sprintf("password was previously used, set on %s", entry.Set.Format("2006-01-02"))
+					findings = append(findings, newFinding(KindInHistory, record, msg))
+					break
+				}
+			}
+		}
+
+		if opts.MaxAgeDays > 0 {
+			changed := passwordModTime(record)
+			if !changed.IsZero() {
+				age := int(now.Sub(changed).Hours() / 24)
+				if age > opts.MaxAgeDays {
+					msg := fmt.Sprintf("password not changed in %d days, since %s", age, changed.Format("2006-01-02"))
+					findings = append(findings, newFinding(KindOld, record, msg))
+					findings[len(findings)-1].Age = age
+				}
+			}
+		}
+	}
+
+	for _, shared := range byPassword {
+		if len(shared) < 2 {
+			continue
+		}
+		for _, record := range shared {
+			finding := newFinding(KindReused, record, fmt.Sprintf("password is shared with %d other records", len(shared)-1))
+			for _, other := range shared {
+				if other.UUID != record.UUID {
+					finding.Related = append(finding.Related, other.UUID)
+				}
+			}
+			sortUUIDs(finding.Related)
+			findings = append(findings, finding)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return string(a.UUID[:]) < string(b.UUID[:])
+	})
+	return findings
+}
+
+// MarshalJSON encodes the finding with hex encoded UUIDs matching the other WASM bridge responses.
+func (f Finding) MarshalJSON() ([]byte, error) {
+	type finding Finding
+	related := make([]string, 0, len(f.Related))
+	for _, id := range f.Related {
+		related = append(related, fmt.Sprintf("%x", id))
+	}
+	return json.Marshal(struct {
+		finding
+		UUID    string   `json:"uuid"`
+		Related []string `json:"related,omitempty"`
+	}{finding(f), fmt.Sprintf("%x", f.UUID), related})
+}
+
+func newFinding(kind Kind, record pwsafe.Record, msg string) Finding {
+	return Finding{Kind: kind, UUID: record.UUID, Title: record.Title, Group: record.Group, Message: msg}
+}
+
+func weakMessage(est Estimate) string {
+	msg := fmt.Sprintf("weak password, score %d/4, about %.0f bits", est.Score, math.Floor(est.Entropy))
+	for _, m := range est.Sequence {
+		if m.Pattern != "bruteforce" {
+			msg += fmt.Sprintf(", contains %s %q", m.Pattern, m.Token)
+		}
+	}
+	return msg
+}
+
+// passwordModTime returns when the password was last changed falling back to the record creation time.
+func passwordModTime(record pwsafe.Record) time.Time {
+	if !record.PasswordModTime.IsZero() {
+		return record.PasswordModTime
+	}
+	return record.CreateTime
+}
+
+func sortUUIDs(uuids [][16]byte) {
+	sort.Slice(uuids, func(i, j int) bool { return string(uuids[i][:]) < string(uuids[j][:]) })
+}
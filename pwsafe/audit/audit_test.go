@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+func TestScan(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	history := pwsafe.PasswordHistory{
+		Enabled:    true,
+		MaxEntries: 5,
+		Entries:    []pwsafe.PasswordHistoryEntry{{Password: "Vq7#mLp2!xRz8&Tk", Set: now.AddDate(-1, 0, 0)}},
+	}
+
+	db := pwsafe.NewV3("audit", "password")
+	weak := db.SetRecord(pwsafe.Record{Title: "weak", Password: "password1"})
+	shared1 := db.SetRecord(pwsafe.Record{Title: "shared 1", Group: "a", Password: "K3#vT9!qZm2@Lp8w"})
+	shared2 := db.SetRecord(pwsafe.Record{Title: "shared 2", Group: "b", Password: "K3#vT9!qZm2@Lp8w"})
+	recycled := db.SetRecord(pwsafe.Record{Title: "recycled", Password: "Vq7#mLp2!xRz8&Tk", PasswordHistory: history.String()})
+	old := db.SetRecord(pwsafe.Record{Title: "old", Password: "Hy4$wN8^cB1!dF6*", PasswordModTime: now.AddDate(0, 0, -400)})
+	db.SetRecord(pwsafe.Record{Title: "fine", Password: "Zr5%gJ2&uE9#oW3!", PasswordModTime: now.AddDate(0, 0, -10)})
+	db.SetRecord(pwsafe.Record{Title: "no password"})
+
+	findings := Scan(db, Options{MinScore: 3, MaxAgeDays: 365, Now: now})
+
+	byKind := make(map[Kind][]Finding)
+	for _, f := range findings {
+		byKind[f.Kind] = append(byKind[f.Kind], f)
+	}
+	assert.Len(t, findings, 5)
+
+	if assert.Len(t, byKind[KindWeak], 1) {
+		assert.Equal(t, weak, byKind[KindWeak][0].UUID)
+		assert.NotNil(t, byKind[KindWeak][0].Strength)
+		assert.Contains(t, byKind[KindWeak][0].Message, "dictionary")
+	}
+	if assert.Len(t, byKind[KindReused], 2) {
+		assert.Equal(t, shared1, byKind[KindReused][0].UUID)
+		assert.Equal(t, [][16]byte{shared2}, byKind[KindReused][0].Related)
+		assert.Equal(t, shared2, byKind[KindReused][1].UUID)
+		assert.Equal(t, [][16]byte{shared1}, byKind[KindReused][1].Related)
+	}
+	if assert.Len(t, byKind[KindInHistory], 1) {
+		assert.Equal(t, recycled, byKind[KindInHistory][0].UUID)
+	}
+	if assert.Len(t, byKind[KindOld], 1) {
+		assert.Equal(t, old, byKind[KindOld][0].UUID)
+		assert.Equal(t, 400, byKind[KindOld][0].Age)
+	}
+
+	// checks can be disabled
+	findings = Scan(db, Options{Now: now})
+	assert.Len(t, findings, 3)
+}
+
+func TestFindingJSON(t *testing.T) {
+	f := Finding{Kind: KindReused, UUID: [16]byte{1}, Title: "t", Related: [][16]byte{{2}}}
+	data, err := json.Marshal(f)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"kind":"reused","uuid":"01000000000000000000000000000000","title":"t","group":"","message":"",
+		"related":["02000000000000000000000000000000"]}`, string(data))
+}
@@ -0,0 +1,251 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+const (
+	// hashListSamples is the number of evenly spaced lines read when opening a hash list to narrow later searches.
+	hashListSamples = 4096
+	// hashListScanSize is the size of a range below which it is scanned rather than binary searched.
+	hashListScanSize = 8192
+	// hashHexLen is the length of a hex encoded SHA-1 at the start of each line.
+	hashHexLen = 2 * sha1.Size
+)
+
+// HashList is a Pwned Passwords SHA-1 file, one "HASH:COUNT" line per hash sorted by hash, opened for lookups.
+// Lookups binary search the file in place so multi-GB lists need only a small sample index in memory and no network.
+type HashList struct {
+	r       io.ReaderAt
+	size    int64
+	samples []hashSample
+	closer  io.Closer
+}
+
+// hashSample is the upper case hash of the line starting at the offset start.
+type hashSample struct {
+	hash  []byte
+	start int64
+}
+
+// OpenHashList opens the sorted hash list file at path, Close should be called when done.
+func OpenHashList(path string) (*HashList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	list, err := NewHashList(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	list.closer = f
+	return list, nil
+}
+
+// NewHashList creates a HashList reading size bytes from r.
+func NewHashList(r io.ReaderAt, size int64) (*HashList, error) {
+	list := &HashList{r: r, size: size}
+	step := size / hashListSamples
+	if step < hashListScanSize {
+		step = hashListScanSize
+	}
+	for off := int64(0); off < size; off += step {
+		line, start, _, err := list.lineAfter(off)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n := len(list.samples); n > 0 && list.samples[n-1].start == start {
+			continue
+		}
+		hash, err := lineHash(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hash list line at offset %d: %w", start, err)
+		}
+		list.samples = append(list.samples, hashSample{hash: hash, start: start})
+	}
+	for i := 1; i < len(list.samples); i++ {
+		if bytes.Compare(list.samples[i-1].hash, list.samples[i].hash) > 0 {
+			return nil, fmt.Errorf("hash list is not sorted by hash near offset %d", list.samples[i].start)
+		}
+	}
+	return list, nil
+}
+
+// Close closes the underlying file for lists opened with OpenHashList.
+func (l *HashList) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// Count returns the number of times the password appears in the list, 0 if it does not.
+func (l *HashList) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	return l.CountHash(sum)
+}
+
+// CountHash returns the count for the given SHA-1 hash, 0 if it is not in the list.
+func (l *HashList) CountHash(sum [sha1.Size]byte) (int, error) {
+	target := bytes.ToUpper([]byte(hex.EncodeToString(sum[:])))
+
+	// narrow to the range between the surrounding samples
+	i := sort.Search(len(l.samples), func(i int) bool { return bytes.Compare(l.samples[i].hash, target) > 0 })
+	lo, hi := int64(0), l.size
+	if i > 0 {
+		lo = l.samples[i-1].start
+	}
+	if i < len(l.samples) {
+		hi = l.samples[i].start
+	}
+
+	// lo is always a line start and hi an exclusive bound on the start of the lines which may match
+	for hi-lo > hashListScanSize {
+		mid := lo + (hi-lo)/2
+		line, start, next, err := l.lineAfter(mid)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if err == io.EOF || start >= hi {
+			hi = mid
+			continue
+		}
+		hash, err := lineHash(line)
+		if err != nil {
+			return 0, fmt.Errorf("invalid hash list line at offset %d: %w", start, err)
+		}
+		switch cmp := bytes.Compare(hash, target); {
+		case cmp == 0:
+			return lineCount(line)
+		case cmp < 0:
+			lo = next
+		default:
+			hi = start
+		}
+	}
+
+	scanner := bufio.NewScanner(io.NewSectionReader(l.r, lo, l.size-lo))
+	// the list is sorted so the scan stops at the first larger hash, at the latest the line starting at hi
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		hash, err := lineHash(line)
+		if err != nil {
+			return 0, err
+		}
+		switch cmp := bytes.Compare(hash, target); {
+		case cmp == 0:
+			return lineCount(line)
+		case cmp > 0:
+			return 0, nil
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// lineAfter returns the first line starting at or after off, its start offset and the offset of the following line.
+func (l *HashList) lineAfter(off int64) ([]byte, int64, int64, error) {
+	start := off
+	if off > 0 {
+		// the line starts after the first newline at or after off-1
+		buf := make([]byte, 128)
+		for pos := off - 1; ; pos += int64(len(buf)) {
+			n, err := l.r.ReadAt(buf, pos)
+			if idx := bytes.IndexByte(buf[:n], '\n'); idx >= 0 {
+				start = pos + int64(idx) + 1
+				break
+			}
+			if err != nil {
+				return nil, 0, 0, err
+			}
+		}
+	}
+	if start >= l.size {
+		return nil, start, start, io.EOF
+	}
+
+	var line []byte
+	buf := make([]byte, 128)
+	for pos := start; ; pos += int64(len(buf)) {
+		n, err := l.r.ReadAt(buf, pos)
+		if idx := bytes.IndexByte(buf[:n], '\n'); idx >= 0 {
+			line = append(line, buf[:idx]...)
+			return line, start, start + int64(len(line)) + 1, nil
+		}
+		line = append(line, buf[:n]...)
+		if err == io.EOF {
+			return line, start, l.size, nil
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+}
+
+// lineHash returns the upper cased hex hash at the start of the line.
+func lineHash(line []byte) ([]byte, error) {
+	if len(line) < hashHexLen {
+		return nil, errors.New("line too short for a SHA-1 hash")
+	}
+	return bytes.ToUpper(line[:hashHexLen]), nil
+}
+
+// lineCount returns the count following the hash on the line, lines without a count are counted once.
+func lineCount(line []byte) (int, error) {
+	rest := bytes.TrimSpace(line[hashHexLen:])
+	if len(rest) == 0 {
+		return 1, nil
+	}
+	if rest[0] != ':' {
+		return 0, fmt.Errorf("invalid hash list line %q", line)
+	}
+	return strconv.Atoi(string(rest[1:]))
+}
+
+// Breached checks the password of every record in the db against the hash list returning the sorted UUIDs of the
+// records whose password appears in it.
+func Breached(db *pwsafe.V3, list *HashList) ([][16]byte, error) {
+	var breached [][16]byte
+	checked := make(map[string]bool)
+	for _, record := range db.Snapshot() {
+		if record.Password == "" {
+			continue
+		}
+		found, prs := checked[record.Password]
+		if !prs {
+			count, err := list.Count(record.Password)
+			if err != nil {
+				return nil, err
+			}
+			found = count > 0
+			checked[record.Password] = found
+		}
+		if found {
+			breached = append(breached, record.UUID)
+		}
+	}
+	sortUUIDs(breached)
+	return breached, nil
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// writeHashList writes a sorted Pwned Passwords style list for the passwords, each with a count of its index + 1.
+func writeHashList(t *testing.T, passwords []string, lineEnd string) string {
+	lines := make([]string, 0, len(passwords))
+	for i, pw := range passwords {
+		lines = append(lines, fmt.Sprintf("%X:%d", sha1.Sum([]byte(pw)), i+1))
+	}
+	sort.Strings(lines)
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, lineEnd)+lineEnd), 0600))
+	return path
+}
+
+func TestHashList(t *testing.T) {
+	var passwords []string
+	for i := 0; i < 20000; i++ {
+		passwords = append(passwords, fmt.Sprintf("leaked%d", i))
+	}
+
+	for name, lineEnd := range map[string]string{"LF": "\n", "CRLF": "\r\n"} {
+		t.Run(name, func(t *testing.T) {
+			list, err := OpenHashList(writeHashList(t, passwords, lineEnd))
+			assert.NoError(t, err)
+			defer list.Close()
+
+			for i, pw := range passwords {
+				count, err := list.Count(pw)
+				assert.NoError(t, err)
+				if !assert.Equal(t, i+1, count, pw) {
+					return
+				}
+			}
+			for i := 0; i < 2000; i++ {
+				count, err := list.Count(fmt.Sprintf("not leaked %d", i))
+				assert.NoError(t, err)
+				assert.Equal(t, 0, count)
+			}
+		})
+	}
+}
+
+func TestHashListSmallAndLowerCase(t *testing.T) {
+	data := strings.ToLower(fmt.Sprintf("%X:3\n", sha1.Sum([]byte("password"))))
+	list, err := NewHashList(bytes.NewReader([]byte(data)), int64(len(data)))
+	assert.NoError(t, err)
+	count, err := list.Count("password")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	count, err = list.Count("Password")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	empty, err := NewHashList(bytes.NewReader(nil), 0)
+	assert.NoError(t, err)
+	count, err = empty.Count("password")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestHashListUnsorted(t *testing.T) {
+	var lines []string
+	for i := 0; i < 2000; i++ {
+		lines = append(lines, fmt.Sprintf("%X:1", sha1.Sum([]byte(fmt.Sprint(i)))))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(lines)))
+	data := strings.Join(lines, "\n")
+	_, err := NewHashList(strings.NewReader(data), int64(len(data)))
+	assert.ErrorContains(t, err, "not sorted")
+}
+
+func TestBreached(t *testing.T) {
+	db := pwsafe.NewV3("breach", "password")
+	leaked1 := db.SetRecord(pwsafe.Record{Title: "one", Password: "hunter2"})
+	leaked2 := db.SetRecord(pwsafe.Record{Title: "two", Password: "hunter2"})
+	leaked3 := db.SetRecord(pwsafe.Record{Title: "three", Password: "letmein"})
+	db.SetRecord(pwsafe.Record{Title: "safe", Password: "Zr5%gJ2&uE9#oW3!"})
+
+	list, err := OpenHashList(writeHashList(t, []string{"hunter2", "letmein", "123456"}, "\r\n"))
+	assert.NoError(t, err)
+	defer list.Close()
+
+	breached, err := Breached(db, list)
+	assert.NoError(t, err)
+	expected := [][16]byte{leaked1, leaked2, leaked3}
+	sortUUIDs(expected)
+	assert.Equal(t, expected, breached)
+}
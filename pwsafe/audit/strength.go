@@ -0,0 +1,460 @@
+package audit
+
+import (
+	"bufio"
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// maxEstimateLength bounds the pattern search, longer passwords are estimated by character set alone.
+const maxEstimateLength = 100
+
+// The guess count thresholds for the 0-4 Score, these match the zxcvbn thresholds.
+var scoreThresholds = []float64{1e3 + 5, 1e6 + 5, 1e8 + 5, 1e10 + 5}
+
+//go:embed dictionary.txt
+var dictionaryFile string
+
+// dictionary maps lower case common passwords and words to their rank, the most common being 1.
+var dictionary = loadDictionary(dictionaryFile)
+
+// l33t substitutions commonly used in place of letters.
+var l33tTable = map[rune][]rune{
+	'4': {'a'},
+	'@': {'a'},
+	'8': {'b'},
+	'(': {'c'},
+	'3': {'e'},
+	'6': {'g'},
+	'1': {'i', 'l'},
+	'!': {'i'},
+	'|': {'i', 'l'},
+	'0': {'o'},
+	'$': {'s'},
+	'5': {'s'},
+	'7': {'t'},
+	'+': {'t'},
+	'2': {'z'},
+}
+
+// keyboardRows are the qwerty rows used to find spatial patterns like "asdf".
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./", "1234567890"}
+
+// Match is a pattern found in a password along with the guesses needed to find it.
+type Match struct {
+	Pattern string  `json:"pattern"` // dictionary, sequence, repeat, spatial, year or bruteforce
+	Token   string  `json:"token"`
+	Guesses float64 `json:"guesses"`
+	start   int
+	end     int // inclusive
+}
+
+// Estimate is the strength estimate for a password.
+type Estimate struct {
+	// CharsetEntropy is the naive length * log2(character pool) entropy in bits.
+	CharsetEntropy float64 `json:"charsetEntropy"`
+	// Entropy is log2(Guesses), the bits of entropy accounting for the patterns found.
+	Entropy float64 `json:"entropy"`
+	// Guesses is the estimated number of guesses an attacker who knows common patterns needs.
+	Guesses float64 `json:"guesses"`
+	// Score from 0 (trivially guessable) to 4 (very unguessable).
+	Score int `json:"score"`
+	// Sequence is the sequence of patterns which make up the password for the lowest guess count.
+	Sequence []Match `json:"sequence"`
+}
+
+// Strength estimates the strength of a password by finding the combination of dictionary words, l33t speak,
+// sequences, repeats, keyboard patterns and years which is cheapest to guess, in the style of zxcvbn.
+func Strength(password string) Estimate {
+	runes := []rune(password)
+	est := Estimate{CharsetEntropy: charsetEntropy(runes)}
+	if len(runes) == 0 {
+		return est
+	}
+	if len(runes) > maxEstimateLength {
+		est.Entropy = est.CharsetEntropy
+		est.Guesses = math.Pow(2, est.CharsetEntropy)
+		est.Score = score(est.Guesses)
+		est.Sequence = []Match{bruteforceMatch(runes, 0, len(runes)-1)}
+		return est
+	}
+
+	var matches []Match
+	matches = append(matches, dictionaryMatches(runes)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, spatialMatches(runes)...)
+	matches = append(matches, yearMatches(runes)...)
+
+	est.Guesses, est.Sequence = mostGuessableSequence(runes, matches)
+	est.Entropy = math.Log2(est.Guesses)
+	est.Score = score(est.Guesses)
+	return est
+}
+
+func score(guesses float64) int {
+	for i, threshold := range scoreThresholds {
+		if guesses < threshold {
+			return i
+		}
+	}
+	return len(scoreThresholds)
+}
+
+// charsetEntropy returns length * log2(pool size) where the pool is the sum of the character classes used.
+func charsetEntropy(runes []rune) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(len(runes)) * math.Log2(float64(pool))
+}
+
+// mostGuessableSequence finds the non-overlapping sequence of matches covering the password with the lowest guess
+// count, filling gaps with bruteforce. Like zxcvbn the total is l! * product(guesses) + 10000^(l-1) for l matches
+// which penalizes splitting a password into many small pieces.
+func mostGuessableSequence(runes []rune, matches []Match) (float64, []Match) {
+	n := len(runes)
+	// add bruteforce for every substring so any gap can be covered
+	for i := 0; i < n; i++ {
+		for j := i; j < n; j++ {
+			matches = append(matches, bruteforceMatch(runes, i, j))
+		}
+	}
+	byEnd := make([][]int, n)
+	for i, m := range matches {
+		byEnd[m.end] = append(byEnd[m.end], i)
+	}
+
+	// best[k][l] is the lowest guess product covering runes[:k] with l matches, prev is the match used to get there
+	best := make([][]float64, n+1)
+	prev := make([][]int, n+1)
+	for k := range best {
+		best[k] = make([]float64, n+1)
+		prev[k] = make([]int, n+1)
+		for l := range best[k] {
+			best[k][l] = math.Inf(1)
+			prev[k][l] = -1
+		}
+	}
+	best[0][0] = 1
+	for k := 1; k <= n; k++ {
+		for _, mi := range byEnd[k-1] {
+			m := matches[mi]
+			for l := 1; l <= k; l++ {
+				candidate := best[m.start][l-1] * m.Guesses
+				if candidate < best[k][l] {
+					best[k][l] = candidate
+					prev[k][l] = mi
+				}
+			}
+		}
+	}
+
+	guesses, bestL := math.Inf(1), 0
+	for l := 1; l <= n; l++ {
+		if math.IsInf(best[n][l], 1) {
+			continue
+		}
+		total := factorial(l)*best[n][l] + math.Pow(10000, float64(l-1))
+		if total < guesses {
+			guesses, bestL = total, l
+		}
+	}
+
+	sequence := make([]Match, bestL)
+	for k, l := n, bestL; l > 0; l-- {
+		m := matches[prev[k][l]]
+		sequence[l-1] = m
+		k = m.start
+	}
+	return guesses, sequence
+}
+
+func factorial(n int) float64 {
+	f := 1.0
+	for i := 2; i <= n; i++ {
+		f *= float64(i)
+	}
+	return f
+}
+
+func bruteforceMatch(runes []rune, start, end int) Match {
+	length := end - start + 1
+	guesses := math.Pow(10, float64(length))
+	// single characters are at least 11 guesses and longer runs 51 so patterns are preferred
+	if length == 1 {
+		guesses = math.Max(guesses, 11)
+	} else {
+		guesses = math.Max(guesses, 51)
+	}
+	return Match{Pattern: "bruteforce", Token: string(runes[start : end+1]), Guesses: guesses, start: start, end: end}
+}
+
+func loadDictionary(words string) map[string]int {
+	ranked := make(map[string]int)
+	scanner := bufio.NewScanner(strings.NewReader(words))
+	rank := 1
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" {
+			continue
+		}
+		if _, prs := ranked[word]; !prs {
+			ranked[word] = rank
+			rank++
+		}
+	}
+	return ranked
+}
+
+// dictionaryMatches finds dictionary words in the password including reversed and l33t spellings.
+func dictionaryMatches(runes []rune) []Match {
+	var matches []Match
+	lower := []rune(strings.ToLower(string(runes)))
+	if len(lower) != len(runes) {
+		// lower casing changed the length of some unicode characters, fall back to a rune by rune lower
+		lower = make([]rune, len(runes))
+		for i, r := range runes {
+			lower[i] = unicode.ToLower(r)
+		}
+	}
+	for _, variant := range l33tVariants(lower) {
+		for i := range variant {
+			for j := i + 2; j < len(variant); j++ {
+				word := string(variant[i : j+1])
+				subs := 0
+				for k := i; k <= j; k++ {
+					if variant[k] != lower[k] {
+						subs++
+					}
+				}
+				token := runes[i : j+1]
+				if rank, ok := dictionary[word]; ok {
+					guesses := float64(rank) * uppercaseVariations(token) * l33tVariations(subs)
+					matches = append(matches, Match{Pattern: "dictionary", Token: string(token), Guesses: guesses, start: i, end: j})
+				}
+				if rank, ok := dictionary[reverse(word)]; ok && subs == 0 {
+					guesses := float64(rank) * uppercaseVariations(token) * 2
+					matches = append(matches, Match{Pattern: "dictionary", Token: string(token), Guesses: guesses, start: i, end: j})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// l33tVariants returns the password with each combination of l33t substitutions undone, including no substitution.
+func l33tVariants(lower []rune) [][]rune {
+	variants := [][]rune{lower}
+	for i, r := range lower {
+		subs, ok := l33tTable[r]
+		if !ok {
+			continue
+		}
+		// limit the combinations for passwords made mostly of substitutable characters
+		if len(variants) >= 64 {
+			break
+		}
+		next := make([][]rune, 0, len(variants)*(len(subs)+1))
+		for _, v := range variants {
+			next = append(next, v)
+			for _, sub := range subs {
+				replaced := append([]rune(nil), v...)
+				replaced[i] = sub
+				next = append(next, replaced)
+			}
+		}
+		variants = next
+	}
+	return variants
+}
+
+// uppercaseVariations is the guess multiplier for the capitalization used, common forms like Capitalized or ALL CAPS
+// only double the guesses.
+func uppercaseVariations(token []rune) float64 {
+	var upper, lower int
+	for _, r := range token {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	if lower == 0 || (upper == 1 && (unicode.IsUpper(token[0]) || unicode.IsUpper(token[len(token)-1]))) {
+		return 2
+	}
+	variations := 0.0
+	for i := 1; i <= min(upper, lower); i++ {
+		variations += binomial(upper+lower, i)
+	}
+	return variations
+}
+
+func l33tVariations(subs int) float64 {
+	if subs == 0 {
+		return 1
+	}
+	return math.Pow(2, float64(subs))
+}
+
+func binomial(n, k int) float64 {
+	if k > n {
+		return 0
+	}
+	r := 1.0
+	for d := 1; d <= k; d++ {
+		r *= float64(n - k + d)
+		r /= float64(d)
+	}
+	return r
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// sequenceMatches finds runs of 3 or more characters with a constant step of 1 such as "abc", "987" or "xyz".
+func sequenceMatches(runes []rune) []Match {
+	var matches []Match
+	for i := 0; i < len(runes); {
+		j := i + 1
+		delta := 0
+		if j < len(runes) {
+			delta = int(runes[j]) - int(runes[i])
+		}
+		for j < len(runes) && (delta == 1 || delta == -1) && int(runes[j])-int(runes[j-1]) == delta {
+			j++
+		}
+		if j-i >= 3 {
+			token := runes[i:j]
+			var base float64
+			switch {
+			case strings.ContainsRune("aAzZ019", token[0]):
+				base = 4
+			case unicode.IsDigit(token[0]):
+				base = 10
+			default:
+				base = 26
+			}
+			if delta < 0 {
+				base *= 2
+			}
+			matches = append(matches, Match{Pattern: "sequence", Token: string(token), Guesses: base * float64(len(token)), start: i, end: j - 1})
+			i = j
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+// repeatMatches finds a character or a short string repeated, such as "aaa" or "abcabc".
+func repeatMatches(runes []rune) []Match {
+	var matches []Match
+	n := len(runes)
+	for i := 0; i < n; i++ {
+		for size := 1; size <= (n-i)/2; size++ {
+			base := runes[i : i+size]
+			count := 1
+			for end := i + size*(count+1); end <= n && string(runes[end-size:end]) == string(base); end = i + size*(count+1) {
+				count++
+			}
+			if count < 2 || (size == 1 && count < 3) {
+				continue
+			}
+			guesses := math.Max(charsetGuesses(base), 11) * float64(count)
+			token := runes[i : i+size*count]
+			matches = append(matches, Match{Pattern: "repeat", Token: string(token), Guesses: guesses, start: i, end: i + size*count - 1})
+		}
+	}
+	return matches
+}
+
+// charsetGuesses is the brute force guesses for the token, used as the cost of the repeated base.
+func charsetGuesses(token []rune) float64 {
+	return math.Pow(2, charsetEntropy(token))
+}
+
+// spatialMatches finds runs of 3 or more adjacent keys along a keyboard row, forwards or backwards.
+func spatialMatches(runes []rune) []Match {
+	// roughly the starting positions times the average key degree on a qwerty keyboard
+	const perKey = 94 * 4.6 / 2
+	var matches []Match
+	lower := []rune(strings.ToLower(string(runes)))
+	if len(lower) != len(runes) {
+		return nil
+	}
+	for _, row := range keyboardRows {
+		for _, r := range []string{row, reverse(row)} {
+			for i := 0; i < len(lower); i++ {
+				j := i
+				for j < len(lower) && strings.Contains(r, string(lower[i:j+1])) {
+					j++
+				}
+				if j-i >= 3 {
+					token := runes[i:j]
+					guesses := perKey * float64(len(token)) * uppercaseVariations(token)
+					matches = append(matches, Match{Pattern: "spatial", Token: string(token), Guesses: guesses, start: i, end: j - 1})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// yearMatches finds 4 digit years between 1900 and 2099 which are guessed from the distance to the reference year.
+func yearMatches(runes []rune) []Match {
+	const referenceYear = 2020
+	var matches []Match
+	for i := 0; i+4 <= len(runes); i++ {
+		token := runes[i : i+4]
+		year := 0
+		ok := true
+		for _, r := range token {
+			if r < '0' || r > '9' {
+				ok = false
+				break
+			}
+			year = year*10 + int(r-'0')
+		}
+		if !ok || year < 1900 || year > 2099 {
+			continue
+		}
+		guesses := math.Max(math.Abs(float64(year-referenceYear)), 20)
+		matches = append(matches, Match{Pattern: "year", Token: string(token), Guesses: guesses, start: i, end: i + 3})
+	}
+	return matches
+}
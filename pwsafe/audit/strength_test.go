@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		maxScore int
+		minScore int
+		pattern  string
+	}{
+		{"", 0, 0, ""},
+		{"password", 0, 0, "dictionary"},
+		{"P@ssw0rd", 1, 0, "dictionary"},
+		{"drowssap", 0, 0, "dictionary"},
+		{"abcdefgh", 0, 0, "sequence"},
+		{"aaaaaaaaaa", 0, 0, "repeat"},
+		{"qwertyuiop", 0, 0, "dictionary"},
+		{"zxcvbnm,./", 1, 0, "spatial"},
+		{"monkey1987", 2, 0, "year"},
+		{"correcthorsebatterystaple", 4, 3, "dictionary"},
+		{"x8#Lq2!vPz9@Rw", 4, 4, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.password, func(t *testing.T) {
+			est := Strength(tc.password)
+			assert.LessOrEqual(t, est.Score, tc.maxScore, "%+v", est)
+			assert.GreaterOrEqual(t, est.Score, tc.minScore, "%+v", est)
+			if tc.pattern != "" {
+				var patterns []string
+				for _, m := range est.Sequence {
+					patterns = append(patterns, m.Pattern)
+				}
+				assert.Contains(t, patterns, tc.pattern)
+			}
+		})
+	}
+}
+
+func TestStrengthSequenceCoversPassword(t *testing.T) {
+	tests := []struct {
+		password               string
+		minEntropy, maxEntropy float64
+	}{
+		{"Tr0ub4dor&3", 30, 45},
+		{"summer2019!", 18, 30},
+		{"iloveyouiloveyou", 10, 20},
+		{"ünïcödé-pässwörd", 45, 60},
+	}
+	for _, tc := range tests {
+		est := Strength(tc.password)
+		var joined string
+		for _, m := range est.Sequence {
+			joined += m.Token
+		}
+		assert.Equal(t, tc.password, joined)
+		assert.GreaterOrEqual(t, est.Entropy, tc.minEntropy, tc.password)
+		assert.LessOrEqual(t, est.Entropy, tc.maxEntropy, tc.password)
+		assert.Less(t, est.Entropy, est.CharsetEntropy, tc.password)
+	}
+}
+
+func TestStrengthLongPassword(t *testing.T) {
+	long := ""
+	for i := 0; i < 30; i++ {
+		long += "abcd"
+	}
+	est := Strength(long)
+	assert.Equal(t, 4, est.Score)
+	assert.Equal(t, est.CharsetEntropy, est.Entropy)
+}
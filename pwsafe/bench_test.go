@@ -0,0 +1,102 @@
+package pwsafe
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// benchDB returns a db with the given number of records each with long notes, using a low iteration count so the
+// benchmarks measure the record handling rather than the key stretching.
+func benchDB(tb testing.TB, records int) *V3 {
+	db := NewV3("bench", "password")
+	db.Iter = 2048
+	db.calculateStretchKey("password")
+	notes := strings.Repeat("These are some long notes for the benchmark record. ", 20)
+	for i := 0; i < records; i++ {
+		db.SetRecord(Record{
+			Group:    fmt.Sprintf("group %d", i%50),
+			Title:    fmt.Sprintf("record %d", i),
+			Username: fmt.Sprintf("user%d@example.com", i),
+			Password: fmt.Sprintf("password %d", i),
+			URL:      fmt.Sprintf("https://%d.example.com/login", i),
+			Notes:    notes,
+		})
+	}
+	return db
+}
+
+// reportPerRecord adds allocations and bytes allocated per record metrics for the benchmark loop run by fn.
+func reportPerRecord(b *testing.B, records int, fn func()) {
+	b.ReportAllocs()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fn()
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+	total := float64(b.N * records)
+	b.ReportMetric(float64(after.Mallocs-before.Mallocs)/total, "allocs/record")
+	b.ReportMetric(float64(after.TotalAlloc-before.TotalAlloc)/total, "B/record")
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	for _, records := range []int{100, 10000} {
+		b.Run(fmt.Sprintf("records=%d", records), func(b *testing.B) {
+			var buf bytes.Buffer
+			if err := benchDB(b, records).Encrypt(&buf); err != nil {
+				b.Fatal(err)
+			}
+			encrypted := buf.Bytes()
+			b.SetBytes(int64(len(encrypted)))
+			reportPerRecord(b, records, func() {
+				var db V3
+				if _, err := db.Decrypt(bytes.NewReader(encrypted), "password"); err != nil {
+					b.Fatal(err)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	for _, records := range []int{100, 10000} {
+		b.Run(fmt.Sprintf("records=%d", records), func(b *testing.B) {
+			db := benchDB(b, records)
+			var buf bytes.Buffer
+			if err := db.Encrypt(&buf); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(buf.Len()))
+			reportPerRecord(b, records, func() {
+				buf.Reset()
+				if err := db.Encrypt(&buf); err != nil {
+					b.Fatal(err)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	for _, records := range []int{10000, 100000} {
+		db := benchDB(b, records)
+		for _, indexed := range []bool{true, false} {
+			b.Run(fmt.Sprintf("records=%d/indexed=%t", records, indexed), func(b *testing.B) {
+				terms := []string{"record", "99"}
+				b.ReportAllocs()
+				for b.Loop() {
+					if indexed {
+						db.Search("record 99", false)
+					} else {
+						db.searchRecords(terms, false)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package pwsafe
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConcurrentAccess exercises the db from many goroutines, run with -race to detect unsynchronized access.
+func TestConcurrentAccess(t *testing.T) {
+	db := NewV3("concurrent", "password")
+	db.Iter = 2048
+	db.calculateStretchKey("password")
+	for i := 0; i < 50; i++ {
+		db.SetRecord(Record{Title: fmt.Sprintf("title %d", i), Group: "group", Password: "secret"})
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				id := db.SetRecord(Record{Title: fmt.Sprintf("writer %d record %d", w, i), Password: "secret"})
+				if i%2 == 0 {
+					db.DeleteRecord(id)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				assert.NotEmpty(t, db.Search("title", true))
+				db.List()
+				db.Groups()
+				db.NeedsSave()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				for _, record := range db.Snapshot() {
+					if got, ok := db.Record(record.UUID); ok {
+						assert.Equal(t, record.UUID, got.UUID)
+					}
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				var buf bytes.Buffer
+				assert.NoError(t, db.Encrypt(&buf))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Each writer kept every other record
+	assert.Equal(t, 50+4*25, len(db.Snapshot()))
+	var buf bytes.Buffer
+	assert.NoError(t, db.Encrypt(&buf))
+	var reread V3
+	_, err := reread.Decrypt(&buf, "password")
+	assert.Nil(t, err)
+	equal, err := reread.Equal(db)
+	assert.True(t, equal, err)
+}
+
+func TestConcurrentLock(t *testing.T) {
+	db := NewV3("concurrent", "password")
+	db.Iter = 2048
+	db.calculateStretchKey("password")
+	db.SetRecord(Record{Title: "title", Password: "secret"})
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				db.Search("title", false)
+				db.Snapshot()
+				db.Locked()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				assert.NoError(t, db.Lock())
+				// another writer may have unlocked it already
+				if err := db.Unlock("password"); err != nil {
+					assert.EqualError(t, err, "the db is not locked")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.False(t, db.Locked())
+	assert.Equal(t, 1, len(db.Snapshot()))
+}
+
+// TestEqualLocksOneAtATime checks Equal doesn't hold the lock of one db while waiting for the other, otherwise
+// a.Equal(b) and b.Equal(a) deadlock when writers are waiting on both.
+func TestEqualLocksOneAtATime(t *testing.T) {
+	a, b := NewV3("test", "password"), NewV3("test", "password")
+	b.Header = a.Header
+
+	b.mu.Lock()
+	done := make(chan bool)
+	go func() {
+		equal, _ := a.Equal(b)
+		done <- equal
+	}()
+	// give Equal time to reach b's lock
+	time.Sleep(50 * time.Millisecond)
+	if assert.True(t, a.mu.TryLock(), "a is locked while waiting for b") {
+		a.mu.Unlock()
+	}
+	b.mu.Unlock()
+	assert.True(t, <-done)
+}
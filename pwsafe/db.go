@@ -4,30 +4,38 @@
 package pwsafe
 
 import (
-	"crypto/hmac"
+	"cmp"
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
+	"maps"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pborman/uuid"
 )
 
 // V3 The type representing a password safe v3 database
+// The methods are safe for concurrent use, the exported fields are not guarded so code sharing a db between goroutines
+// should use the methods such as Record and Snapshot rather than reading Records directly.
 type V3 struct {
-	CBCIV         [16]byte //Random initial value for CBC
-	EncryptionKey [32]byte
-	Header        header
-	HMAC          [32]byte //32bytes keyed-hash MAC with SHA-256 as the hash function.
-	HMACKey       [32]byte
-	Iter          uint32 //the number of iterations on the hash function to create the stretched key
-	LastMod       time.Time
-	LastSavePath  string
-	Records       map[[16]byte]Record //the key is the record's UUID
-	Salt          [32]byte
-	StretchedKey  [sha256.Size]byte
+	CBCIV        [16]byte //Random initial value for CBC
+	Header       header
+	HMAC         [32]byte //32bytes keyed-hash MAC with SHA-256 as the hash function.
+	Iter         uint32   //the number of iterations on the hash function to create the stretched key
+	LastMod      time.Time
+	LastSavePath string
+	Records      map[[16]byte]Record //the key is the record's UUID
+	Salt         [32]byte
+	keys         *secretKeys  // the stretched, encryption and HMAC keys
+	locked       []byte       // the encrypted header and records while the db is locked
+	journal      journal      // changes for Undo and Redo
+	index        *searchIndex // the tokens of Records for Search
+	order        recordOrder  // the position of Records in the file
+	mu           sync.RWMutex
 }
 
 // NewV3 - create and initialize a new pwsafe.V3 db
@@ -35,6 +43,7 @@ func NewV3(name, password string) *V3 {
 	var db V3
 	db.Header = newHeader(name)
 	db.Records = make(map[[16]byte]Record)
+	db.index = newSearchIndex()
 
 	// Set the password
 	db.SetPassword(password)
@@ -43,32 +52,116 @@ func NewV3(name, password string) *V3 {
 
 // DeleteRecord Removes a record from the db by its UUID
 func (db *V3) DeleteRecord(id [16]byte) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.unavailable() {
+		return
+	}
+	if record, prs := db.Records[id]; prs {
+		db.journal.record(change{records: []recordChange{{id: id, before: &record}}})
+	}
 	delete(db.Records, id)
+	db.reindex(id)
 	db.LastMod = time.Now()
 }
 
-// RecordByTitle returns the first record found with a matching title.
-func (db V3) RecordByTitle(title string) (Record, bool) {
+// RecordByTitle returns the first record with a matching title, ordered by group, username and UUID so the same record
+// is returned every time. Titles are only unique within a group, use RecordsByTitle to find all of them or
+// RecordByPath to find the one in a group.
+func (db *V3) RecordByTitle(title string) (Record, bool) {
+	records := db.RecordsByTitle(title)
+	if len(records) == 0 {
+		return Record{}, false
+	}
+	return records[0], true
+}
+
+// RecordsByTitle returns the records with a matching title sorted by group, username and UUID.
+func (db *V3) RecordsByTitle(title string) []Record {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var records []Record
 	for _, record := range db.Records {
 		if record.Title == title {
-			return record, true
+			records = append(records, record)
 		}
 	}
-	return Record{}, false
+	slices.SortFunc(records, func(a, b Record) int {
+		return cmp.Or(
+			strings.Compare(a.Group, b.Group),
+			strings.Compare(a.Username, b.Username),
+			slices.Compare(a.UUID[:], b.UUID[:]),
+		)
+	})
+	return records
+}
+
+// RecordByPath returns the record with exactly the given group and title, the group is empty for records without one.
+// It returns ErrRecordNotFound if there is none and an *AmbiguousRecordError if several records have the group and
+// title, whatever their usernames.
+func (db *V3) RecordByPath(group, title string) (Record, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.recordByPath(group, title)
+}
+
+// recordByPath is RecordByPath with the lock held.
+func (db *V3) recordByPath(group, title string) (Record, error) {
+	var found Record
+	var matches [][16]byte
+	for id, record := range db.Records {
+		if record.Group == group && record.Title == title {
+			matches = append(matches, id)
+			found = record
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Record{}, ErrRecordNotFound
+	case 1:
+		return found, nil
+	}
+	slices.SortFunc(matches, func(a, b [16]byte) int { return slices.Compare(a[:], b[:]) })
+	return Record{}, &AmbiguousRecordError{Group: group, Title: title, UUIDs: matches}
+}
+
+// Record returns a copy of the record with the given UUID.
+func (db *V3) Record(id [16]byte) (Record, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	record, ok := db.Records[id]
+	return record, ok
+}
+
+// Snapshot returns a copy of all the records in the db in file order, see Encrypt. Changes made to the db after it
+// returns are not reflected in the snapshot so it can be iterated while other goroutines modify the db.
+func (db *V3) Snapshot() []Record {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	records := make([]Record, 0, len(db.Records))
+	for _, id := range db.orderedIDs() {
+		records = append(records, db.Records[id])
+	}
+	return records
 }
 
 // Equal compares the content of two V3 DBs except for LastSave fields and fields with transient or changing values.
+// The other db is copied before this one is locked so the locks of the two are never held together, otherwise
+// a.Equal(b) and b.Equal(a) could deadlock with writers waiting on each.
 func (db *V3) Equal(other *V3) (bool, error) {
-	if matches, err := db.Header.Equal(other.Header); !matches || err != nil {
+	otherHeader, otherRecords := other.contents()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if matches, err := db.Header.Equal(otherHeader); !matches || err != nil {
 		return matches, err
 	}
 
 	// compare records
-	if len(db.Records) != len(other.Records) {
-		return false, fmt.Errorf("record lengths don't match, %v != %v", len(db.Records), len(other.Records))
+	if len(db.Records) != len(otherRecords) {
+		return false, fmt.Errorf("record lengths don't match, %v != %v", len(db.Records), len(otherRecords))
 	}
 	for uuidVal, record := range db.Records {
-		otherRecord, prs := other.Records[uuidVal]
+		otherRecord, prs := otherRecords[uuidVal]
 		if !prs {
 			return false, fmt.Errorf("record with UUID %x not found in other db", uuidVal)
 		}
@@ -80,8 +173,19 @@ func (db *V3) Equal(other *V3) (bool, error) {
 	return true, nil
 }
 
+// contents returns a copy of the header and records.
+func (db *V3) contents() (header, map[[16]byte]Record) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	h := db.Header
+	h.EmptyGroups = slices.Clone(h.EmptyGroups) // renaming a group changes it in place
+	return h, maps.Clone(db.Records)
+}
+
 // Groups Returns an slice of strings which match all groups used by records in the DB
-func (db V3) Groups() []string {
+func (db *V3) Groups() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	groups := make([]string, 0, len(db.Records))
 	groupSet := make(map[string]bool)
 	for _, value := range db.Records {
@@ -94,8 +198,17 @@ func (db V3) Groups() []string {
 	return groups
 }
 
+// EmptyGroups returns the groups without records kept in the db header.
+func (db *V3) EmptyGroups() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return slices.Clone(db.Header.EmptyGroups)
+}
+
 // List Returns the titles of all the records in the db.
-func (db V3) List() []string {
+func (db *V3) List() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	entries := make([]string, 0, len(db.Records))
 	for _, value := range db.Records {
 		entries = append(entries, value.Title)
@@ -105,7 +218,9 @@ func (db V3) List() []string {
 }
 
 // ListByGroup Returns the list of record titles that have the given group.
-func (db V3) ListByGroup(group string) []string {
+func (db *V3) ListByGroup(group string) []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	entries := make([]string, 0, len(db.Records))
 	for _, value := range db.Records {
 		if value.Group == group {
@@ -119,8 +234,33 @@ func (db V3) ListByGroup(group string) []string {
 // Search returns hex-encoded UUIDs of records matching all whitespace-separated terms in query.
 // When namesOnly is true only title and group are searched; otherwise username,
 // URL, and notes are included. Password is never searched.
-func (db V3) Search(query string, namesOnly bool) []string {
+// The search uses an index kept up to date by the methods changing records. Each search checks the indexed fields
+// against Records first so records added, changed or removed directly in the map are reindexed before searching.
+func (db *V3) Search(query string, namesOnly bool) []string {
 	terms := strings.Fields(strings.ToLower(query))
+	db.mu.RLock()
+	if len(terms) == 0 || db.index == nil {
+		defer db.mu.RUnlock()
+		return db.searchRecords(terms, namesOnly)
+	}
+	if db.index.current(db.Records) {
+		defer db.mu.RUnlock()
+		return db.index.search(terms, namesOnly)
+	}
+	db.mu.RUnlock()
+
+	// Records was changed directly, update the index with the write lock
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.index == nil {
+		return db.searchRecords(terms, namesOnly)
+	}
+	db.index.update(db.Records)
+	return db.index.search(terms, namesOnly)
+}
+
+// searchRecords is Search without the index.
+func (db *V3) searchRecords(terms []string, namesOnly bool) []string {
 	if len(terms) == 0 {
 		var results []string
 		for _, rec := range db.Records {
@@ -153,12 +293,20 @@ func (db V3) Search(query string, namesOnly bool) []string {
 }
 
 // NeedsSave Returns true if the db has unsaved modifiations
-func (db V3) NeedsSave() bool {
+func (db *V3) NeedsSave() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	return db.Header.LastSave.Before(db.LastMod)
 }
 
-// SetPassword Sets the password that will be used to encrypt the file on next save
+// SetPassword Sets the password that will be used to encrypt the file on next save, returning ErrLocked if the db is
+// locked or closed.
 func (db *V3) SetPassword(pw string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.unavailable() {
+		return ErrLocked
+	}
 	// First recalculate the Salt and set iter
 	db.Iter = 86000
 	if _, err := rand.Read(db.Salt[:]); err != nil {
@@ -170,8 +318,45 @@ func (db *V3) SetPassword(pw string) error {
 }
 
 // SetRecord Adds or updates a record in the db, returning the record's UUID
+// A locked or closed db is left unchanged and the zero UUID returned, use SetRecordUnique to get ErrLocked instead.
 func (db *V3) SetRecord(record Record) [16]byte {
-	now := time.Now()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.setRecord(record)
+}
+
+// DuplicateRecordError is returned by SetRecordUnique when another record has the same group, title and username.
+type DuplicateRecordError struct {
+	Group, Title, Username string
+	UUID                   [16]byte // the existing record
+}
+
+func (e *DuplicateRecordError) Error() string {
+	return fmt.Sprintf("record %x already has the title %q and username %q in group %q", e.UUID, e.Title, e.Username, e.Group)
+}
+
+// SetRecordUnique is SetRecord enforcing the rule of the reference Password Safe client that no two records have the
+// same group, title and username. It returns a *DuplicateRecordError without changing the db if another record has
+// them, or ErrLocked if the db is locked or closed.
+func (db *V3) SetRecordUnique(record Record) ([16]byte, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.unavailable() {
+		return [16]byte{}, ErrLocked
+	}
+	for id, other := range db.Records {
+		if id != record.UUID && other.Group == record.Group && other.Title == record.Title && other.Username == record.Username {
+			return [16]byte{}, &DuplicateRecordError{Group: record.Group, Title: record.Title, Username: record.Username, UUID: id}
+		}
+	}
+	return db.setRecord(record), nil
+}
+
+// setRecord is SetRecord with the lock held.
+func (db *V3) setRecord(record Record) [16]byte {
+	if db.unavailable() {
+		return [16]byte{}
+	}
 	if record.UUID == [16]byte{} {
 		record.UUID = [16]byte(uuid.NewRandom().Array())
 	}
@@ -182,34 +367,83 @@ func (db *V3) SetRecord(record Record) [16]byte {
 		if equal {
 			return record.UUID
 		}
-	} else {
-		record.CreateTime = now
 	}
 
-	if prs && record.CreateTime.IsZero() {
-		record.CreateTime = oldRecord.CreateTime
+	now := time.Now()
+	record = stampRecord(record, oldRecord, prs, now)
+	db.Records[record.UUID] = record
+	db.order.add(record.UUID)
+	db.reindex(record.UUID)
+	db.LastMod = now
+	rc := recordChange{id: record.UUID, after: &record}
+	if prs {
+		rc.before = &oldRecord
 	}
+	db.journal.record(change{records: []recordChange{rc}})
+	return record.UUID
+}
 
-	record.ModTime = now
+// ReplaceRecord replaces the record with the UUID id by record, which may have a different UUID, as a single change
+// for Undo. A record with a new UUID keeps the position of the one it replaces, unless the UUID is already in use
+// when that record is replaced too. It returns the record's UUID, or the zero UUID if the db is locked or closed.
+func (db *V3) ReplaceRecord(id [16]byte, record Record) [16]byte {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if record.UUID == [16]byte{} {
+		record.UUID = id
+	}
+	oldRecord, prs := db.Records[id]
+	if db.unavailable() || !prs || record.UUID == id {
+		return db.setRecord(record)
+	}
+
+	now := time.Now()
+	record = stampRecord(record, oldRecord, true, now)
+	replaced := recordChange{id: record.UUID, after: &record}
+	if existing, ok := db.Records[record.UUID]; ok {
+		replaced.before = &existing
+	} else {
+		db.order.move(id, record.UUID)
+	}
+	delete(db.Records, id)
+	db.reindex(id)
 	db.Records[record.UUID] = record
+	db.order.add(record.UUID)
+	db.reindex(record.UUID)
 	db.LastMod = now
+	db.journal.record(change{records: []recordChange{{id: id, before: &oldRecord}, replaced}})
 	return record.UUID
 }
 
-// calculateHMAC calculate and set db.HMAC for the unencrypted data using HMACKey
-func (db *V3) calculateHMAC(unencrypted []byte) {
-	hmacHash := hmac.New(sha256.New, db.HMACKey[:])
-	hmacHash.Write(unencrypted)
-	copy(db.HMAC[:], hmacHash.Sum(nil))
+// stampRecord sets the times SetRecord maintains on a record replacing oldRecord, or on a new record when prs is false.
+func stampRecord(record, oldRecord Record, prs bool, now time.Time) Record {
+	if !prs {
+		record.CreateTime = now
+	} else if record.CreateTime.IsZero() {
+		record.CreateTime = oldRecord.CreateTime
+	}
+
+	// A password change is recorded and restarts the expiry clock when the record has an expiry interval
+	passwordChanged := !prs || oldRecord.Password != record.Password
+	if prs && passwordChanged {
+		record.PasswordModTime = now
+	}
+	if passwordChanged && record.PasswordExpiryInterval > 0 && record.PasswordExpiryInterval <= PasswordExpiryIntervalMax {
+		record.PasswordExpiry = passwordExpiryFrom(now, record.PasswordExpiryInterval)
+	}
+
+	record.ModTime = now
+	return record
 }
 
 // calculateStretchKey Using the db Salt and Iter along with the passwd calculate the stretch key
 func (db *V3) calculateStretchKey(passwd string) {
 	iterations := int(db.Iter)
 	salted := append([]byte(passwd), db.Salt[:]...)
-	stretched := sha256.Sum256(salted)
+	keys := db.secrets()
+	keys.stretched = sha256.Sum256(salted)
+	clear(salted)
 	for i := 0; i < iterations; i++ {
-		stretched = sha256.Sum256(stretched[:])
+		keys.stretched = sha256.Sum256(keys.stretched[:])
 	}
-	db.StretchedKey = stretched
 }
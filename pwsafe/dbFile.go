@@ -1,6 +1,9 @@
 package pwsafe
 
-import "os"
+import (
+	"os"
+	"path/filepath"
+)
 
 //OpenPWSafeFile Opens a password safe v3 file and decrypts with the supplied password
 func OpenPWSafeFile(dbPath string, passwd string) (*V3, error) {
@@ -21,6 +24,8 @@ func OpenPWSafeFile(dbPath string, passwd string) (*V3, error) {
 }
 
 //WritePWSafeFile Writes a pwsafe.DB to disk, using either the specified path or the LastSavedPath
+// The db is written to a temporary file in the same directory which only replaces the file once it is complete, so a
+// db which can't be encrypted, such as a locked or closed one, leaves the file unchanged.
 func WritePWSafeFile(v3db *V3, path string) error {
 	var savePath string
 	if path == "" {
@@ -29,12 +34,48 @@ func WritePWSafeFile(v3db *V3, path string) error {
 		savePath = path
 		v3db.LastSavePath = path
 	}
-	// Open the file
-	f, err := os.Create(savePath)
+	f, err := os.CreateTemp(filepath.Dir(savePath), filepath.Base(savePath)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	// Removing the temporary file fails harmlessly once it has been renamed
+	defer os.Remove(f.Name())
+
+	// Keep the permissions of the file being replaced, a new file is only readable by its owner
+	if info, err := os.Stat(savePath); err == nil {
+		if err := f.Chmod(info.Mode().Perm()); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := v3db.Encrypt(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), savePath)
+}
+
+// OpenPWSafeFileWithOptions is OpenPWSafeFile with options, returning the warnings of a recovered db, see
+// DecryptOptions.
+func OpenPWSafeFileWithOptions(dbPath string, passwd string, opts DecryptOptions) (*V3, []error, error) {
+	var db V3
+
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return &db, nil, err
+	}
 	defer f.Close()
 
-	return v3db.Encrypt(f)
+	_, warnings, err := db.DecryptWithOptions(f, passwd, opts)
+
+	db.LastSavePath = dbPath
+
+	return &db, warnings, err
 }
@@ -22,22 +22,45 @@ func TestKeys(t *testing.T) {
 
 	// tests the stretchedKey
 	db.calculateStretchKey("password")
-	assert.Equal(t, db.StretchedKey, expectedKey)
+	assert.Equal(t, db.StretchedKey(), expectedKey)
 
 	keyBuf := &bytes.Buffer{}
 	assert.NoError(t, db.refreshEncryptedKeys(keyBuf))
-	createdEncryptionKey := db.EncryptionKey
-	createdHMACKey := db.HMACKey
+	createdEncryptionKey := db.EncryptionKey()
+	createdHMACKey := db.HMACKey()
 
 	// extract the keys from the encrypted bytes and compare to the original
 	db.extractKeys(keyBuf.Bytes())
-	assert.Equal(t, createdEncryptionKey, db.EncryptionKey)
-	assert.Equal(t, createdHMACKey, db.HMACKey)
+	assert.Equal(t, createdEncryptionKey, db.EncryptionKey())
+	assert.Equal(t, createdHMACKey, db.HMACKey())
+}
+
+func TestClose(t *testing.T) {
+	db, err := OpenPWSafeFile("./test_dbs/simple.dat", "password")
+	assert.Nil(t, err)
+	keys := db.keys
+	assert.NotEqual(t, [32]byte{}, keys.encryption)
+
+	db.Close()
+	assert.Equal(t, secretKeys{}, *keys, "the key material must be zeroed")
+	assert.Nil(t, db.keys)
+	assert.Empty(t, db.Records)
+	assert.Equal(t, header{}, db.Header)
+
+	var buf bytes.Buffer
+	assert.Error(t, db.Encrypt(&buf))
+	assert.Equal(t, 0, buf.Len())
+
+	// Closing twice is harmless and the db can be reopened from the file
+	db.Close()
+	db, err = OpenPWSafeFile("./test_dbs/simple.dat", "password")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(db.Records))
 }
 
 func TestInvalidFile(t *testing.T) {
 	_, err := OpenPWSafeFile("./db.go", "password")
-	assert.Equal(t, err, errors.New("file is not a valid Password Safe v3 file"))
+	assert.ErrorIs(t, err, ErrNotPWS3)
 	_, err = OpenPWSafeFile("./notafile", "password")
 	assert.NotNil(t, err)
 }
@@ -109,3 +132,84 @@ func TestDeleteRecordByUUID(t *testing.T) {
 	assert.True(t, ok)
 	assert.NotEqual(t, target.UUID, remaining.UUID)
 }
+
+func TestRecordsByTitle(t *testing.T) {
+	db := NewV3("test", "password")
+	db.SetRecord(Record{Title: "Google", Group: "Work", Username: "b"})
+	db.SetRecord(Record{Title: "Google", Group: "Personal", Username: "z"})
+	db.SetRecord(Record{Title: "Google", Group: "Work", Username: "a"})
+	db.SetRecord(Record{Title: "Other", Group: "Personal"})
+
+	records := db.RecordsByTitle("Google")
+	assert.Equal(t, 3, len(records))
+	var order []string
+	for _, record := range records {
+		order = append(order, record.Group+"/"+record.Username)
+	}
+	assert.Equal(t, []string{"Personal/z", "Work/a", "Work/b"}, order)
+	assert.Empty(t, db.RecordsByTitle("missing"))
+
+	// RecordByTitle always returns the first of them
+	for range 10 {
+		record, ok := db.RecordByTitle("Google")
+		assert.True(t, ok)
+		assert.Equal(t, records[0].UUID, record.UUID)
+	}
+}
+
+func TestRecordByPath(t *testing.T) {
+	db := NewV3("test", "password")
+	work := db.SetRecord(Record{Title: "Google", Group: "Work"})
+	noGroup := db.SetRecord(Record{Title: "Google"})
+	dup1 := db.SetRecord(Record{Title: "dup", Group: "Work", Username: "a"})
+	dup2 := db.SetRecord(Record{Title: "dup", Group: "Work", Username: "b"})
+	dup3 := db.SetRecord(Record{Title: "dup", Group: "Work", Username: "a"})
+
+	record, err := db.RecordByPath("Work", "Google")
+	assert.NoError(t, err)
+	assert.Equal(t, work, record.UUID)
+	record, err = db.RecordByPath("", "Google")
+	assert.NoError(t, err)
+	assert.Equal(t, noGroup, record.UUID)
+
+	_, err = db.RecordByPath("Work.Sub", "Google")
+	assert.ErrorIs(t, err, ErrRecordNotFound)
+
+	_, err = db.RecordByPath("Work", "dup")
+	var ambiguous *AmbiguousRecordError
+	assert.True(t, errors.As(err, &ambiguous))
+	assert.ElementsMatch(t, [][16]byte{dup1, dup2, dup3}, ambiguous.UUIDs, "usernames aren't used to choose")
+}
+
+func TestSetRecordUnique(t *testing.T) {
+	db := NewV3("test", "password")
+	existing, err := db.SetRecordUnique(Record{Title: "Google", Group: "Work", Username: "user1", Password: "pass1"})
+	assert.NoError(t, err)
+
+	// The same title and username in another group or a different username are allowed
+	_, err = db.SetRecordUnique(Record{Title: "Google", Group: "Personal", Username: "user1"})
+	assert.NoError(t, err)
+	other, err := db.SetRecordUnique(Record{Title: "Google", Group: "Work", Username: "user2"})
+	assert.NoError(t, err)
+
+	_, err = db.SetRecordUnique(Record{Title: "Google", Group: "Work", Username: "user1", Password: "pass2"})
+	var duplicate *DuplicateRecordError
+	assert.True(t, errors.As(err, &duplicate))
+	assert.Equal(t, existing, duplicate.UUID)
+	assert.Equal(t, 3, len(db.Records), "a duplicate must not be added")
+
+	// Renaming a record onto another is refused but updating a record in place is not
+	record, _ := db.Record(other)
+	record.Username = "user1"
+	_, err = db.SetRecordUnique(record)
+	assert.True(t, errors.As(err, &duplicate))
+	stored, _ := db.Record(other)
+	assert.Equal(t, "user2", stored.Username)
+
+	record, _ = db.Record(existing)
+	record.Password = "changed"
+	_, err = db.SetRecordUnique(record)
+	assert.NoError(t, err)
+	stored, _ = db.Record(existing)
+	assert.Equal(t, "changed", stored.Password)
+}
@@ -1,110 +1,169 @@
 package pwsafe
 
 import (
+	"bufio"
 	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 
 	"github.com/pborman/uuid"
 	"golang.org/x/crypto/twofish"
 )
 
-// Decrypt Decrypts the data in the reader using the given password and populates the information into the db
+// Decrypt Decrypts the data in the reader using the given password and populates the information into the db.
+// The encrypted section is decrypted, parsed and added to a running HMAC one block at a time so only the parsed
+// header and records are held in memory.
 func (db *V3) Decrypt(reader io.Reader, passwd string) (int, error) {
-	cr := &CountingReader{Reader: reader}
+	n, _, err := db.DecryptWithOptions(reader, passwd, DecryptOptions{})
+	return n, err
+}
+
+// DecryptWithOptions is Decrypt with options, the returned warnings are the problems skipped when recovering a damaged
+// db, see DecryptOptions.
+func (db *V3) DecryptWithOptions(reader io.Reader, passwd string, opts DecryptOptions) (int, []error, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.journal.reset()
+	cr := &CountingReader{Reader: bufio.NewReader(reader)}
 
 	// The TAG is 4 ascii characters, should be "PWS3"
 	tag := make([]byte, 4)
 	if _, err := io.ReadFull(cr, tag); err != nil {
-		return cr.BytesRead, err
+		return cr.BytesRead, nil, err
 	}
 	if string(tag) != "PWS3" {
-		return cr.BytesRead, errors.New("file is not a valid Password Safe v3 file")
+		return cr.BytesRead, nil, ErrNotPWS3
 	}
 
 	// Read the Salt
 	if _, err := io.ReadFull(cr, db.Salt[:]); err != nil {
-		return cr.BytesRead, err
+		return cr.BytesRead, nil, err
 	}
 
 	// Read iter
 	if err := binary.Read(cr, binary.LittleEndian, &db.Iter); err != nil {
-		return cr.BytesRead, err
+		return cr.BytesRead, nil, err
 	}
 
 	// Verify the password
 	db.calculateStretchKey(passwd)
 	var keyHash [sha256.Size]byte
 	if _, err := io.ReadFull(cr, keyHash[:]); err != nil {
-		return cr.BytesRead, err
+		return cr.BytesRead, nil, err
 	}
-	if keyHash != sha256.Sum256(db.StretchedKey[:]) {
-		return cr.BytesRead, errors.New("invalid password")
+	if keyHash != sha256.Sum256(db.keys.stretched[:]) {
+		return cr.BytesRead, nil, ErrInvalidPassword
 	}
 
 	//extract the encryption and hmac keys
 	keyData := make([]byte, 64)
 	if _, err := io.ReadFull(cr, keyData); err != nil {
-		return cr.BytesRead, err
+		return cr.BytesRead, nil, err
 	}
 	db.extractKeys(keyData)
 
 	if _, err := io.ReadFull(cr, db.CBCIV[:]); err != nil {
-		return cr.BytesRead, err
+		return cr.BytesRead, nil, err
 	}
 
-	// All following fields are encrypted with twofish in CBC mode until the EOF, find the EOF
-	var encryptedDB []byte
-	var encryptedSize int
-	for {
-		blockBytes := make([]byte, twofish.BlockSize)
-		if _, err := io.ReadFull(cr, blockBytes); err != nil {
-			return cr.BytesRead, err
-		}
-
-		if string(blockBytes) == "PWS3-EOFPWS3-EOF" {
-			break
-		} else {
-			encryptedSize += twofish.BlockSize
-			encryptedDB = append(encryptedDB, blockBytes...)
-		}
-	}
-
-	block, err := twofish.NewCipher(db.EncryptionKey[:])
+	// All following fields are encrypted with twofish in CBC mode until the EOF block
+	block, err := twofish.NewCipher(db.keys.encryption[:])
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
-	decrypter := cipher.NewCBCDecrypter(block, db.CBCIV[:])
-	decryptedDB := make([]byte, encryptedSize) // The EOF and HMAC are after the encrypted section
-	decrypter.CryptBlocks(decryptedDB, encryptedDB)
-
-	// Verify expected end of data
-	expectedHMAC := make([]byte, 32)
-	if _, err := io.ReadFull(cr, expectedHMAC); err != nil {
-		return cr.BytesRead, err
+	fields := &fieldReader{
+		r:     cr,
+		cbc:   cipher.NewCBCDecrypter(block, db.CBCIV[:]),
+		hmac:  hmac.New(sha256.New, db.keys.hmac[:]),
+		limit: -1,
+	}
+	defer clear(fields.block[:])
+	defer func() { clear(fields.buf) }()
+	if opts.Recover {
+		if err := fields.startRecovery(cr); err != nil {
+			return cr.BytesRead, nil, err
+		}
 	}
 
 	//UnMarshal the decrypted DB, first the header
-	header, hdrSize, headerHMACData, err := UnmarshalHeader(decryptedDB)
-	if err != nil {
-		return cr.BytesRead, errors.New("error parsing the unencrypted header - " + err.Error())
+	var header header
+	fields.index = -1
+	ended := false // set when recovering from encrypted data which ends part way through an entry
+	if err := fields.readEntry(&header); err != nil {
+		if err == errEndOfEncrypted {
+			err = errors.New("no END field found when UnMarshaling")
+		}
+		switch {
+		case opts.Recover:
+			fields.warn(err)
+			ended = true
+		case fields.readErr != nil:
+			return cr.BytesRead, nil, fields.readErr
+		default:
+			return cr.BytesRead, nil, fmt.Errorf("error parsing the unencrypted header - %w", err)
+		}
 	}
 	db.Header = header
 
-	_, recordHMACData, err := db.unmarshalRecords(decryptedDB[hdrSize:])
-	if err != nil {
-		return cr.BytesRead, errors.New("error parsing the unencrypted records - " + err.Error())
+	db.Records = make(map[[16]byte]Record)
+	db.order.reset()
+	for index := 0; !ended; index++ {
+		record := &Record{}
+		fields.index = index
+		err := fields.readEntry(record)
+		if err == errEndOfEncrypted {
+			break
+		}
+		if opts.Recover {
+			if err != nil {
+				fields.warn(err)
+				ended = true
+			}
+			if !fields.salvage(record, index, db.Records) {
+				continue
+			}
+		}
+		if record.UUID == [16]byte{} {
+			record.UUID = [16]byte(uuid.NewRandom().Array())
+		}
+		db.Records[record.UUID] = *record
+		db.order.add(record.UUID)
+		if opts.Recover {
+			continue
+		}
+		if fields.readErr != nil {
+			return cr.BytesRead, nil, fields.readErr
+		}
+		if err != nil {
+			return cr.BytesRead, nil, fmt.Errorf("error parsing the unencrypted records - error parsing record - %w", err)
+		}
 	}
-	hmacData := append(headerHMACData, recordHMACData...)
+
+	db.index = buildSearchIndex(db.Records)
 
 	// Verify HMAC - The HMAC is only calculated on the header/field values not length/type
-	db.calculateHMAC(hmacData)
+	expectedHMAC := fields.storedHMAC
+	if !opts.Recover {
+		expectedHMAC = make([]byte, 32)
+		if _, err := io.ReadFull(cr, expectedHMAC); err != nil {
+			return cr.BytesRead, nil, err
+		}
+	}
+	copy(db.HMAC[:], fields.hmac.Sum(nil))
 	if !hmac.Equal(db.HMAC[:], expectedHMAC) {
-		return cr.BytesRead, errors.New("error calculated HMAC does not match read HMAC")
+		err := ErrHMACMismatch
+		if !opts.Recover {
+			return cr.BytesRead, nil, err
+		}
+		if len(expectedHMAC) != len(db.HMAC) {
+			err = errors.New("the HMAC is missing, the file is truncated")
+		}
+		fields.warnings = append(fields.warnings, err)
 	}
 
 	// Ensure the DB has a UUID
@@ -112,7 +171,114 @@ func (db *V3) Decrypt(reader io.Reader, passwd string) (int, error) {
 		db.Header.UUID = [16]byte(uuid.NewRandom().Array())
 	}
 
-	return cr.BytesRead, nil
+	return cr.BytesRead, fields.warnings, nil
+}
+
+// errInvalidFieldLength is returned by the fieldReader for a field running past the end of the encrypted data.
+var errInvalidFieldLength = errors.New("invalid field length")
+
+// errEndOfEncrypted is returned by the fieldReader when the "PWS3-EOFPWS3-EOF" block ending the encrypted data is read.
+var errEndOfEncrypted = errors.New("end of encrypted data")
+
+// fieldReader decrypts and reads the length, type and value fields of the encrypted section one block at a time,
+// writing each field value to the running HMAC.
+type fieldReader struct {
+	r       io.Reader
+	cbc     cipher.BlockMode // nil when the data isn't encrypted
+	hmac    hash.Hash        // nil when the data isn't encrypted
+	block   [twofish.BlockSize]byte
+	buf     []byte // reused for field data, setField must copy anything it keeps
+	offset  int    // offset in the decrypted data of the next block
+	start   int    // offset in the decrypted data of the field last read
+	readErr error  // set when reading from r failed rather than parsing
+
+	// Set when recovering, see startRecovery
+	recover    bool
+	limit      int     // the length of the encrypted data before the EOF block or -1 if unknown
+	storedHMAC []byte  // the HMAC following the EOF block
+	index      int     // the record being read, -1 for the header
+	warnings   []error // the problems skipped
+}
+
+// readBlock reads and decrypts the next block, returning errEndOfEncrypted at the EOF block.
+func (fr *fieldReader) readBlock() error {
+	if _, err := io.ReadFull(fr.r, fr.block[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		fr.readErr = err
+		return err
+	}
+	if string(fr.block[:]) == "PWS3-EOFPWS3-EOF" {
+		return errEndOfEncrypted
+	}
+	if fr.cbc != nil {
+		fr.cbc.CryptBlocks(fr.block[:], fr.block[:])
+	}
+	fr.offset += twofish.BlockSize
+	return nil
+}
+
+// readField returns the type and data of the next field, the data is only valid until the next call.
+// Each field starts on a block boundary with a 4 byte length and 1 byte type, the value runs on into following blocks.
+func (fr *fieldReader) readField() (byte, []byte, error) {
+	if err := fr.readBlock(); err != nil {
+		return 0, nil, err
+	}
+	fr.start = fr.offset - twofish.BlockSize
+	fieldLength := int(binary.LittleEndian.Uint32(fr.block[:4]))
+	btype := fr.block[4]
+	if fr.limit >= 0 && fieldLength > twofish.BlockSize-5+fr.limit-fr.offset {
+		return 0, nil, fr.fieldError(btype, fmt.Errorf("%w %d, exceeds the encrypted data", errInvalidFieldLength, fieldLength))
+	}
+	fr.buf = append(fr.buf[:0], fr.block[5:5+min(fieldLength, twofish.BlockSize-5)]...)
+	for len(fr.buf) < fieldLength {
+		if err := fr.readBlock(); err != nil {
+			if err == errEndOfEncrypted {
+				return 0, nil, fr.fieldError(btype, fmt.Errorf("%w %d, exceeds the encrypted data", errInvalidFieldLength, fieldLength))
+			}
+			return 0, nil, err
+		}
+		fr.buf = append(fr.buf, fr.block[:min(fieldLength-len(fr.buf), twofish.BlockSize)]...)
+	}
+	if fr.hmac != nil {
+		fr.hmac.Write(fr.buf)
+	}
+	return btype, fr.buf, nil
+}
+
+// readEntry reads fields into setter until the END field, errEndOfEncrypted is returned only if the encrypted data
+// ends before the entry starts. When recovering fields which can't be parsed are skipped with a warning, a field with
+// an invalid length skips only its first block so reading picks up again at the next field.
+func (fr *fieldReader) readEntry(setter fieldSetter) error {
+	for first := true; ; first = false {
+		btype, data, err := fr.readField()
+		if err == errEndOfEncrypted && !first {
+			return errors.New("no END field found when UnMarshaling")
+		}
+		if fr.recover && errors.Is(err, errInvalidFieldLength) {
+			fr.warn(err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if btype == recordEndOfEntry { // Using RecordEndOfEntry as generic end marker, assuming it's same for header
+			return nil
+		}
+		if err := setter.setField(btype, data); err != nil {
+			if fr.recover {
+				fr.warn(fr.fieldError(btype, err))
+				continue
+			}
+			return fr.fieldError(btype, err)
+		}
+	}
+}
+
+// fieldError returns a *FieldError for the field last read.
+func (fr *fieldReader) fieldError(btype byte, err error) *FieldError {
+	return &FieldError{RecordIndex: fr.index, FieldType: btype, Offset: fr.start, Err: err}
 }
 
 // CountingReader wraps an io.Reader and counts the bytes read
@@ -129,81 +295,15 @@ func (r *CountingReader) Read(p []byte) (n int, err error) {
 
 // Pull encryptionKey and HMAC key from the 64byte keyData
 func (db *V3) extractKeys(keyData []byte) {
-	c, _ := twofish.NewCipher(db.StretchedKey[:])
-	k1 := make([]byte, 16)
-	c.Decrypt(k1, keyData[:16])
-	k2 := make([]byte, 16)
-	c.Decrypt(k2, keyData[16:32])
-	copy(db.EncryptionKey[:], append(k1, k2...))
-
-	l1 := make([]byte, 16)
-	c.Decrypt(l1, keyData[32:48])
-	l2 := make([]byte, 16)
-	c.Decrypt(l2, keyData[48:])
-	copy(db.HMACKey[:], append(l1, l2...))
+	keys := db.secrets()
+	c, _ := twofish.NewCipher(keys.stretched[:])
+	c.Decrypt(keys.encryption[:16], keyData[:16])
+	c.Decrypt(keys.encryption[16:], keyData[16:32])
+	c.Decrypt(keys.hmac[:16], keyData[32:48])
+	c.Decrypt(keys.hmac[16:], keyData[48:])
 }
 
 // fieldSetter interface for types that can set fields by ID
 type fieldSetter interface {
 	setField(id byte, data []byte) error
 }
-
-// UnMarshal the records returning records length, a byte array of data for hmac calculations and error or nil
-// The EOF string records end with is "PWS3-EOFPWS3-EOF"
-func (db *V3) unmarshalRecords(records []byte) (int, []byte, error) {
-	recordStart := 0
-	var hmacData []byte
-	db.Records = make(map[[16]byte]Record)
-	for recordStart < len(records) {
-		record := &Record{}
-		recordLength, recordData, err := unmarshalRecord(records[recordStart:], record)
-		if record.UUID == [16]byte{} {
-			record.UUID = [16]byte(uuid.NewRandom().Array())
-		}
-		db.Records[record.UUID] = *record
-		if err != nil {
-			return recordStart, hmacData, errors.New("error parsing record - " + err.Error())
-		}
-		hmacData = append(hmacData, recordData...)
-		recordStart += recordLength
-	}
-
-	if recordStart > len(records) {
-		return recordStart, hmacData, errors.New("encountered a record with invalid length")
-	}
-	return recordStart, hmacData, nil
-}
-
-// UnMarshal a single record from the given records []byte, writing to fields in recordFieldMap, return record size, raw record Data and error or nil
-// Individual records stop with an END field
-// This function is used both to UnMarshal the header and individual records in the DB
-func unmarshalRecord(records []byte, setter fieldSetter) (int, []byte, error) {
-	var rdata []byte
-	fieldStart := 0
-	for {
-		if fieldStart > len(records) {
-			return 0, rdata, errors.New("no END field found when UnMarshaling")
-		}
-		fieldLength := int(binary.LittleEndian.Uint32(records[fieldStart : fieldStart+4]))
-		btype := records[fieldStart+4 : fieldStart+5][0]
-		if fieldStart+fieldLength+5 > len(records) {
-			return 0, rdata, fmt.Errorf("invalid field length %d at offset %d, exceeds records length %d", fieldLength, fieldStart, len(records))
-		}
-		data := records[fieldStart+5 : fieldStart+fieldLength+5]
-		rdata = append(rdata, data...)
-		fieldStart += fieldLength + 5
-		//The next field must start on a block boundary
-		blockmod := fieldStart % twofish.BlockSize
-		if blockmod != 0 {
-			fieldStart += twofish.BlockSize - blockmod
-		}
-
-		if btype == recordEndOfEntry { // Using RecordEndOfEntry as generic end marker, assuming it's same for header
-			return fieldStart, rdata, nil
-		}
-
-		if err := setter.setField(btype, data); err != nil {
-			return fieldStart, rdata, err
-		}
-	}
-}
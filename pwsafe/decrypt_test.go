@@ -1,13 +1,18 @@
 package pwsafe
 
 import (
-	"errors"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/twofish"
 )
 
 func TestSimpleDB(t *testing.T) {
@@ -30,7 +35,8 @@ func TestSimpleDB(t *testing.T) {
 func TestBadHMAC(t *testing.T) {
 	// This test relies on the simple password db found at ./test_db/badHMAC.dat
 	_, err := OpenPWSafeFile("./test_dbs/badHMAC.dat", "password")
-	assert.Equal(t, errors.New("error calculated HMAC does not match read HMAC"), err)
+	assert.ErrorIs(t, err, ErrHMACMismatch)
+	assert.EqualError(t, err, "error calculated HMAC does not match read HMAC")
 }
 
 func TestThreeDB(t *testing.T) {
@@ -125,7 +131,29 @@ func TestDBModifications(t *testing.T) {
 }
 func TestBadPassword(t *testing.T) {
 	_, err := OpenPWSafeFile("./test_dbs/simple.dat", "badpass")
-	assert.Equal(t, err, errors.New("invalid password"))
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+}
+
+func TestDecryptStreaming(t *testing.T) {
+	data, err := os.ReadFile("./test_dbs/three.dat")
+	assert.Nil(t, err)
+
+	var db V3
+	read, err := db.Decrypt(bytes.NewReader(data), "three3#;")
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), read)
+	assert.Equal(t, 3, len(db.Records))
+
+	// the EOF block is missing so the reader ends mid stream
+	var truncated V3
+	_, err = truncated.Decrypt(bytes.NewReader(data[:len(data)-48]), "three3#;")
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// the EOF block is early so the last record is missing its END field
+	var short V3
+	endless := append(append([]byte{}, data[:len(data)-64]...), data[len(data)-48:]...)
+	_, err = short.Decrypt(bytes.NewReader(endless), "three3#;")
+	assert.ErrorContains(t, err, "no END field found")
 }
 
 func TestRecordFieldVariations_EmptyFields(t *testing.T) {
@@ -522,3 +550,49 @@ func TestDuplicateTitles(t *testing.T) {
 	}
 	assert.ElementsMatch(t, []string{"work.user@example.com", "personal.user@example.com"}, usernames)
 }
+
+// testFieldCBC returns the CBC mode for the field reader and writer tests, a zero key and IV are fine for them.
+func testFieldCBC(tb testing.TB, encrypt bool) cipher.BlockMode {
+	block, err := twofish.NewCipher(make([]byte, 32))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	iv := make([]byte, twofish.BlockSize)
+	if encrypt {
+		return cipher.NewCBCEncrypter(block, iv)
+	}
+	return cipher.NewCBCDecrypter(block, iv)
+}
+
+// encryptEntry returns the fields written by writeFields encrypted as they are in a db followed by the EOF block, and
+// the HMAC of the field values.
+func encryptEntry(tb testing.TB, writeFields func(write func(id byte, data []byte) error) error) ([]byte, []byte, error) {
+	var buf bytes.Buffer
+	fields := &fieldWriter{w: &buf, cbc: testFieldCBC(tb, true), hmac: hmac.New(sha256.New, nil)}
+	if err := writeFields(fields.writeField); err != nil {
+		return nil, nil, err
+	}
+	buf.WriteString("PWS3-EOFPWS3-EOF")
+	return buf.Bytes(), fields.hmac.Sum(nil), nil
+}
+
+// encryptPlaintext returns decrypted field data padded with zeros to a whole block, encrypted and followed by the EOF
+// block, for building malformed entries.
+func encryptPlaintext(tb testing.TB, plaintext []byte) []byte {
+	padded := make([]byte, (len(plaintext)+twofish.BlockSize-1)/twofish.BlockSize*twofish.BlockSize)
+	copy(padded, plaintext)
+	testFieldCBC(tb, true).CryptBlocks(padded, padded)
+	return append(padded, "PWS3-EOFPWS3-EOF"...)
+}
+
+// decryptEntry reads the first entry in encrypted into setter, errors report the entry as the header.
+func decryptEntry(tb testing.TB, encrypted []byte, setter fieldSetter) error {
+	fields := &fieldReader{
+		r:     bytes.NewReader(encrypted),
+		cbc:   testFieldCBC(tb, false),
+		hmac:  hmac.New(sha256.New, nil),
+		limit: -1,
+		index: -1,
+	}
+	return fields.readEntry(setter)
+}
@@ -1,11 +1,15 @@
 package pwsafe
 
 import (
+	"bufio"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"time"
 
@@ -14,33 +18,53 @@ import (
 
 var Version = "dev"
 
-// Encrypt Encrypt the data in the db building it up in memory then writing to the writer, returns bytesWritten, error
+// Encrypt Encrypts the db writing it to the writer. Each field is marshaled, encrypted and added to a running HMAC
+// as it is written so the cleartext of the whole db is never held in a single buffer. Records are written in the order
+// they were read with new records at the end so repeated saves don't reorder the file.
 func (db *V3) Encrypt(dbBuf io.Writer) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.keys == nil {
+		return errors.New("the db is closed, locked or has no password set")
+	}
+	// Check every record before anything is written so an invalid record doesn't leave a partial file
+	if err := db.validateRecords(); err != nil {
+		return err
+	}
+
 	//update the LastSave time in the DB
 	db.Header.LastSave = time.Now()
 	db.Header.Version = [2]byte{0x10, 0x03} // DB Format version 0x0310
 	db.Header.LastSaveBy = []byte("github.com/tkuhlman/gopwsafe " + Version)
 
+	return db.writeEncrypted(dbBuf)
+}
+
+// writeEncrypted writes the header and records as they are in the V3 format with freshly generated encryption and
+// HMAC keys.
+func (db *V3) writeEncrypted(dbBuf io.Writer) error {
+	w := bufio.NewWriter(dbBuf)
+
 	// Set unencrypted DB headers
-	if err := binary.Write(dbBuf, binary.LittleEndian, []byte("PWS3")); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, []byte("PWS3")); err != nil {
 		return err
 	}
 
 	// Add salt and iter neither of which can change without knowing the password as the stretchedkey will need recalculating.
 	// use db.SetPassword() to change the password
-	if err := binary.Write(dbBuf, binary.LittleEndian, db.Salt); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, db.Salt); err != nil {
 		return err
 	}
-	if err := binary.Write(dbBuf, binary.LittleEndian, db.Iter); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, db.Iter); err != nil {
 		return err
 	}
 
 	// Add the stretchedKey Hash and refresh the encryption keys adding them encrypted
-	stretchedSHA := sha256.Sum256(db.StretchedKey[:])
-	if err := binary.Write(dbBuf, binary.LittleEndian, stretchedSHA); err != nil {
+	stretchedSHA := sha256.Sum256(db.keys.stretched[:])
+	if err := binary.Write(w, binary.LittleEndian, stretchedSHA); err != nil {
 		return err
 	}
-	if err := db.refreshEncryptedKeys(dbBuf); err != nil {
+	if err := db.refreshEncryptedKeys(w); err != nil {
 		return err
 	}
 
@@ -49,97 +73,110 @@ func (db *V3) Encrypt(dbBuf io.Writer) error {
 	if err != nil {
 		return err
 	}
-	if err := binary.Write(dbBuf, binary.LittleEndian, db.CBCIV); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, db.CBCIV); err != nil {
 		return err
 	}
 
-	// marshal the core db values
-
-	var unencryptedBytes []byte
-	// Note the version field needs to be first and is required
-
-	headerBytes, headerValues := db.Header.marshal()
-	unencryptedBytes = append(unencryptedBytes, headerBytes...)
-
-	recordBytes, recordValues, err := db.marshalRecords()
+	// encrypt and write the header then the records, the version field needs to be first and is required
+	dbTwoFish, err := twofish.NewCipher(db.keys.encryption[:])
 	if err != nil {
 		return err
 	}
-	unencryptedBytes = append(unencryptedBytes, recordBytes...)
-
-	// encrypt and write the dbBlocks
-	dbTwoFish, _ := twofish.NewCipher(db.EncryptionKey[:])
-	cbcTwoFish := cipher.NewCBCEncrypter(dbTwoFish, db.CBCIV[:])
-	for i := 0; i < len(unencryptedBytes); i += twofish.BlockSize {
-		block := unencryptedBytes[i : i+twofish.BlockSize]
-		encrypted := make([]byte, twofish.BlockSize)
-		cbcTwoFish.CryptBlocks(encrypted, block)
-		if err := binary.Write(dbBuf, binary.LittleEndian, encrypted); err != nil {
+	fields := &fieldWriter{
+		w:    w,
+		cbc:  cipher.NewCBCEncrypter(dbTwoFish, db.CBCIV[:]),
+		hmac: hmac.New(sha256.New, db.keys.hmac[:]),
+	}
+	defer clear(fields.block[:])
+	if err := db.Header.writeFields(fields.writeField); err != nil {
+		return err
+	}
+	// Records added directly to the map keep the position they are first written in
+	for _, id := range db.orderedIDs() {
+		db.order.add(id)
+		record := db.Records[id]
+		if err := record.writeFields(fields.writeField); err != nil {
 			return err
 		}
 	}
 
 	// Add the EOF and HMAC
-	if err := binary.Write(dbBuf, binary.LittleEndian, []byte("PWS3-EOFPWS3-EOF")); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, []byte("PWS3-EOFPWS3-EOF")); err != nil {
 		return err
 	}
-	hmacBytes := append(headerValues, recordValues...)
-	db.calculateHMAC(hmacBytes)
-	if err := binary.Write(dbBuf, binary.LittleEndian, db.HMAC); err != nil {
+	copy(db.HMAC[:], fields.hmac.Sum(nil))
+	if err := binary.Write(w, binary.LittleEndian, db.HMAC); err != nil {
 		return err
 	}
 
-	return nil
+	return w.Flush()
 }
 
-// marshalRecords return the binary format for the Records as specified in the spec and the record values used for hmac calculations
-func (db *V3) marshalRecords() (records []byte, dataBytes []byte, err error) {
+// validateRecords checks the records can be written, for each record UUID, Title and Password fields are mandatory
+// all others are optional.
+func (db *V3) validateRecords() error {
 	for _, record := range db.Records {
-
-		// for each record UUID, Title and Password fields are mandatory all others are optional
 		if record.Title == "" || record.Password == "" {
-			return nil, nil, fmt.Errorf("title or password is not set, invalid record, title %s", record.Title)
+			return fmt.Errorf("title or password is not set, invalid record, title %s", record.Title)
 		}
-
-		// finally call marshalRecord for this record
-		rBytes, hmacBytes, err := record.marshal()
-		if err != nil {
-			return nil, nil, err
+		if record.PasswordExpiryInterval > PasswordExpiryIntervalMax {
+			return fmt.Errorf("PasswordExpiryInterval %d exceeds maximum of %d", record.PasswordExpiryInterval, PasswordExpiryIntervalMax)
 		}
-		records = append(records, rBytes...)
-		dataBytes = append(dataBytes, hmacBytes...)
 	}
+	return nil
+}
 
-	return records, dataBytes, nil
+// fieldWriter encrypts and writes fields in the length, type, value format one block at a time, writing each field
+// value to the running HMAC.
+type fieldWriter struct {
+	w     io.Writer
+	cbc   cipher.BlockMode
+	hmac  hash.Hash
+	block [twofish.BlockSize]byte
 }
 
-// pseudoRandomBytes generates a slice of bytes filled with pseudo random data
-func pseudoRandomBytes(size int) (r []byte) {
-	r = make([]byte, size)
-	_, err := rand.Read(r)
-	if err != nil {
-		// Fallback to zero padding if rand fails, though this should be rare/impossible in most envs
-		// Best effort for padding
-		return r
+// writeField writes a field starting on a block boundary, the last block is padded with random bytes.
+func (fw *fieldWriter) writeField(id byte, data []byte) error {
+	fw.hmac.Write(data)
+	binary.LittleEndian.PutUint32(fw.block[:4], uint32(len(data)))
+	fw.block[4] = id
+	n := copy(fw.block[5:], data)
+	filled := 5 + n
+	data = data[n:]
+	for {
+		if filled < twofish.BlockSize {
+			if _, err := rand.Read(fw.block[filled:]); err != nil {
+				return err
+			}
+		}
+		fw.cbc.CryptBlocks(fw.block[:], fw.block[:])
+		if _, err := fw.w.Write(fw.block[:]); err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		filled = copy(fw.block[:], data)
+		data = data[filled:]
 	}
-	return r
 }
 
 // re-calculate and add to the db new encryption key and hmac key then encrypt with and return the encrypted bytes
 func (db *V3) refreshEncryptedKeys(buf io.Writer) error {
-	_, err := rand.Read(db.EncryptionKey[:])
+	keys := db.secrets()
+	_, err := rand.Read(keys.encryption[:])
 	if err != nil {
 		return err
 	}
-	_, err = rand.Read(db.HMACKey[:])
+	_, err = rand.Read(keys.hmac[:])
 	if err != nil {
 		return err
 	}
-	keyTwoFish, err := twofish.NewCipher(db.StretchedKey[:])
+	keyTwoFish, err := twofish.NewCipher(keys.stretched[:])
 	if err != nil {
 		return err
 	}
-	for _, block := range [][]byte{db.EncryptionKey[:16], db.EncryptionKey[16:], db.HMACKey[:16], db.HMACKey[16:]} {
+	for _, block := range [][]byte{keys.encryption[:16], keys.encryption[16:], keys.hmac[:16], keys.hmac[16:]} {
 		encrypted := make([]byte, 16)
 		keyTwoFish.Encrypt(encrypted, block)
 		if err := binary.Write(buf, binary.LittleEndian, encrypted); err != nil {
@@ -1,10 +1,14 @@
 package pwsafe
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestSaveSimpleDB - save simple DB, reopen and verify contents match the original but keys don't
@@ -238,3 +242,64 @@ func TestNewV3(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, true, equal)
 }
+
+func TestWritePWSafeFileFailureLeavesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saved.dat")
+	db := NewV3("saved", "password")
+	db.SetRecord(Record{Title: "saved", Password: "password"})
+	require.NoError(t, WritePWSafeFile(db, path))
+	require.NoError(t, os.Chmod(path, 0640))
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Lock())
+	assert.Error(t, WritePWSafeFile(db, path), "a locked db can't be saved")
+	db.Close()
+	assert.Error(t, WritePWSafeFile(db, path), "a closed db can't be saved")
+
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, saved, current)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the temporary files are removed")
+
+	reopened, err := OpenPWSafeFile(path, "password")
+	require.NoError(t, err)
+	require.NoError(t, WritePWSafeFile(reopened, path))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm(), "saving keeps the file permissions")
+}
+
+func TestEncryptInvalidRecordWritesNothing(t *testing.T) {
+	db := NewV3("invalid", "password")
+	db.SetRecord(Record{Title: "valid", Password: "password"})
+	db.SetRecord(Record{Title: "no password"})
+
+	var buf bytes.Buffer
+	err := db.Encrypt(&buf)
+	assert.ErrorContains(t, err, "title or password is not set")
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestEncryptMatchesWrittenFields(t *testing.T) {
+	// The record fields in the db must have the layout and HMAC input writeFields gives, apart from the random padding
+	db := NewV3("layout", "password")
+	id := db.SetRecord(Record{Title: "layout", Password: "password", Notes: strings.Repeat("n", 40), PasswordExpiryInterval: 7})
+
+	var buf bytes.Buffer
+	assert.NoError(t, db.Encrypt(&buf))
+	var decrypted V3
+	_, err := decrypted.Decrypt(&buf, "password")
+	assert.NoError(t, err)
+
+	record := db.Records[id]
+	expected, expectedHMAC, err := encryptEntry(t, record.writeFields)
+	assert.NoError(t, err)
+	written := decrypted.Records[id]
+	actual, actualHMAC, err := encryptEntry(t, written.writeFields)
+	assert.NoError(t, err)
+	assert.Equal(t, len(expected), len(actual))
+	assert.Equal(t, expectedHMAC, actualHMAC)
+}
@@ -0,0 +1,37 @@
+package pwsafe
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned when a db can't be opened, check for them with errors.Is.
+var (
+	ErrInvalidPassword = errors.New("invalid password")
+	ErrNotPWS3         = errors.New("file is not a valid Password Safe v3 file")
+	ErrHMACMismatch    = errors.New("error calculated HMAC does not match read HMAC")
+)
+
+// FieldError is a field of the header or of a record which can't be parsed, check for it with errors.As.
+type FieldError struct {
+	RecordIndex int   // the index of the record in the file, -1 for the header
+	FieldType   byte  // the type read for the field, it may itself be damaged
+	Offset      int   // the offset of the field in the decrypted data
+	Err         error // the problem with the field
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s field type 0x%02x at offset %d: %v", entryName(e.RecordIndex), e.FieldType, e.Offset, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// entryName names the header, index -1, or a record by its index in the file.
+func entryName(index int) string {
+	if index < 0 {
+		return "header"
+	}
+	return fmt.Sprintf("record %d", index)
+}
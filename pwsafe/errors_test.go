@@ -0,0 +1,79 @@
+package pwsafe
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/twofish"
+)
+
+// editDecrypted encrypts db then lets edit change the decrypted header and records before encrypting them again.
+func editDecrypted(t *testing.T, db *V3, edit func(plain []byte)) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, db.Encrypt(&buf))
+	data := buf.Bytes()
+	end := bytes.Index(data, []byte("PWS3-EOFPWS3-EOF"))
+	block, err := twofish.NewCipher(db.keys.encryption[:])
+	require.NoError(t, err)
+
+	plain := bytes.Clone(data[encryptedStart:end])
+	cipher.NewCBCDecrypter(block, db.CBCIV[:]).CryptBlocks(plain, plain)
+	edit(plain)
+	cipher.NewCBCEncrypter(block, db.CBCIV[:]).CryptBlocks(data[encryptedStart:end], plain)
+	return data
+}
+
+func TestDecryptErrors(t *testing.T) {
+	db, data := damageableDB(t, 1)
+
+	var other V3
+	_, err := other.Decrypt(bytes.NewReader(data), "wrong")
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+	_, err = other.Decrypt(bytes.NewReader(append([]byte("PWS2"), data[4:]...)), "password")
+	assert.ErrorIs(t, err, ErrNotPWS3)
+	_, err = other.Decrypt(bytes.NewReader(append(bytes.Clone(data[:len(data)-1]), data[len(data)-1]^1)), "password")
+	assert.ErrorIs(t, err, ErrHMACMismatch)
+
+	// The header version field is first, give it an invalid length
+	damaged := editDecrypted(t, db, func(plain []byte) { plain[0] = 3 })
+	_, err = other.Decrypt(bytes.NewReader(damaged), "password")
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr), "got %v", err)
+	assert.Equal(t, FieldError{RecordIndex: -1, FieldType: headerVersion, Offset: 0, Err: fieldErr.Err}, *fieldErr)
+	assert.Contains(t, err.Error(), "header field type 0x00 at offset 0: invalid length for Version: 3")
+}
+
+func TestDecryptRecordFieldError(t *testing.T) {
+	db := NewV3("test", "password")
+	db.SetRecord(Record{Title: "first", Password: "pw"})
+	db.SetRecord(Record{Title: "second", Password: "pw"})
+
+	// Turn the title of the second record into a UUID field of the wrong length
+	var offset int
+	damaged := editDecrypted(t, db, func(plain []byte) {
+		offset = bytes.Index(plain, []byte("second")) - 5
+		require.Zero(t, offset%twofish.BlockSize)
+		plain[offset+4] = recordUUID
+	})
+	var other V3
+	_, err := other.Decrypt(bytes.NewReader(damaged), "password")
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr), "got %v", err)
+	assert.Equal(t, 1, fieldErr.RecordIndex)
+	assert.Equal(t, byte(recordUUID), fieldErr.FieldType)
+	assert.Equal(t, offset, fieldErr.Offset)
+	assert.EqualError(t, fieldErr.Err, "invalid length for UUID")
+
+	// When recovering the field is reported as a warning
+	_, warnings, err := other.DecryptWithOptions(bytes.NewReader(damaged), "password", DecryptOptions{Recover: true})
+	require.NoError(t, err)
+	var warned *FieldError
+	require.NotEmpty(t, warnings)
+	assert.True(t, errors.As(warnings[0], &warned))
+	assert.Equal(t, *fieldErr, *warned)
+}
@@ -0,0 +1,42 @@
+package pwsafe
+
+import (
+	"sort"
+	"time"
+)
+
+// Expiring returns the records whose password has already expired and those which will expire within the given duration.
+// Records without a PasswordExpiry are skipped, both slices are sorted by PasswordExpiry with the oldest first.
+func (db *V3) Expiring(within time.Duration) (expired []Record, expiring []Record) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	now := time.Now()
+	cutoff := now.Add(within)
+	for _, record := range db.Records {
+		if record.PasswordExpiry.IsZero() {
+			continue
+		}
+		if !record.PasswordExpiry.After(now) {
+			expired = append(expired, record)
+		} else if !record.PasswordExpiry.After(cutoff) {
+			expiring = append(expiring, record)
+		}
+	}
+	sortByExpiry(expired)
+	sortByExpiry(expiring)
+	return expired, expiring
+}
+
+// passwordExpiryFrom returns the expiry time for a password changed at the given time and interval in days.
+func passwordExpiryFrom(changed time.Time, interval uint32) time.Time {
+	return changed.AddDate(0, 0, int(interval))
+}
+
+func sortByExpiry(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].PasswordExpiry.Equal(records[j].PasswordExpiry) {
+			return records[i].PasswordExpiry.Before(records[j].PasswordExpiry)
+		}
+		return records[i].Title < records[j].Title
+	})
+}
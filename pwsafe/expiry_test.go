@@ -0,0 +1,68 @@
+package pwsafe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiring(t *testing.T) {
+	db := NewV3("test", "password")
+	now := time.Now()
+	db.Records[[16]byte{1}] = Record{UUID: [16]byte{1}, Title: "expired", Password: "pw", PasswordExpiry: now.Add(-48 * time.Hour)}
+	db.Records[[16]byte{2}] = Record{UUID: [16]byte{2}, Title: "older expired", Password: "pw", PasswordExpiry: now.Add(-96 * time.Hour)}
+	db.Records[[16]byte{3}] = Record{UUID: [16]byte{3}, Title: "soon", Password: "pw", PasswordExpiry: now.Add(24 * time.Hour)}
+	db.Records[[16]byte{4}] = Record{UUID: [16]byte{4}, Title: "later", Password: "pw", PasswordExpiry: now.Add(30 * 24 * time.Hour)}
+	db.Records[[16]byte{5}] = Record{UUID: [16]byte{5}, Title: "never", Password: "pw"}
+
+	expired, expiring := db.Expiring(7 * 24 * time.Hour)
+	assert.Len(t, expired, 2)
+	assert.Equal(t, "older expired", expired[0].Title)
+	assert.Equal(t, "expired", expired[1].Title)
+	assert.Len(t, expiring, 1)
+	assert.Equal(t, "soon", expiring[0].Title)
+
+	expired, expiring = db.Expiring(0)
+	assert.Len(t, expired, 2)
+	assert.Empty(t, expiring)
+}
+
+func TestSetRecordPasswordExpiry(t *testing.T) {
+	db := NewV3("test", "password")
+
+	t.Run("New record with interval", func(t *testing.T) {
+		id := db.SetRecord(Record{Title: "interval", Password: "pw", PasswordExpiryInterval: 30})
+		record := db.Records[id]
+		expected := record.ModTime.AddDate(0, 0, 30)
+		assert.True(t, expected.Equal(record.PasswordExpiry), "expected %v got %v", expected, record.PasswordExpiry)
+	})
+
+	t.Run("Password change restarts the interval", func(t *testing.T) {
+		id := db.SetRecord(Record{Title: "change", Password: "pw", PasswordExpiryInterval: 10})
+		record := db.Records[id]
+		record.PasswordExpiry = time.Now().Add(-time.Hour)
+		db.Records[id] = record
+
+		record.Password = "new pw"
+		db.SetRecord(record)
+		updated := db.Records[id]
+		assert.True(t, updated.PasswordExpiry.After(time.Now().AddDate(0, 0, 9)))
+	})
+
+	t.Run("Other edits keep the expiry", func(t *testing.T) {
+		id := db.SetRecord(Record{Title: "notes", Password: "pw", PasswordExpiryInterval: 10})
+		expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+		record := db.Records[id]
+		record.PasswordExpiry = expiry
+		record.Notes = "updated"
+		db.SetRecord(record)
+		assert.True(t, expiry.Equal(db.Records[id].PasswordExpiry))
+	})
+
+	t.Run("No interval leaves a fixed expiry", func(t *testing.T) {
+		expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+		id := db.SetRecord(Record{Title: "fixed", Password: "pw", PasswordExpiry: expiry})
+		assert.True(t, expiry.Equal(db.Records[id].PasswordExpiry))
+	})
+}
@@ -0,0 +1,107 @@
+package pwsafe
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// CharsetUse is whether a character set is used by GeneratePassword.
+type CharsetUse int
+
+const (
+	CharsetIncluded CharsetUse = iota // characters may be used
+	CharsetRequired                   // at least one character is used
+	CharsetExcluded                   // characters are never used
+)
+
+// Character sets for generated passwords.
+const (
+	CharsUpper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	CharsLower   = "abcdefghijklmnopqrstuvwxyz"
+	CharsDigits  = "0123456789"
+	CharsSymbols = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+)
+
+// PasswordOptions configures GeneratePassword, they match the options of the PWA generator.
+type PasswordOptions struct {
+	Length                        int
+	Upper, Lower, Digits, Symbols CharsetUse
+	Exclude                       string // characters never used, even from a required set
+}
+
+// DefaultPasswordOptions are the defaults of the PWA generator.
+var DefaultPasswordOptions = PasswordOptions{
+	Length:  20,
+	Upper:   CharsetRequired,
+	Lower:   CharsetRequired,
+	Digits:  CharsetIncluded,
+	Symbols: CharsetIncluded,
+	Exclude: "\"'<>\\`",
+}
+
+// GeneratePassword returns a random password of opts.Length characters using crypto/rand, with at least one character
+// from each required set.
+func GeneratePassword(opts PasswordOptions) (string, error) {
+	if opts.Length < 1 {
+		return "", fmt.Errorf("invalid password length %d", opts.Length)
+	}
+	var pool, password []byte
+	for _, set := range []struct {
+		chars string
+		use   CharsetUse
+	}{{CharsUpper, opts.Upper}, {CharsLower, opts.Lower}, {CharsDigits, opts.Digits}, {CharsSymbols, opts.Symbols}} {
+		if set.use == CharsetExcluded {
+			continue
+		}
+		var active []byte
+		for i := 0; i < len(set.chars); i++ {
+			if !strings.ContainsRune(opts.Exclude, rune(set.chars[i])) {
+				active = append(active, set.chars[i])
+			}
+		}
+		if len(active) == 0 {
+			continue
+		}
+		pool = append(pool, active...)
+		if set.use == CharsetRequired && len(password) < opts.Length {
+			c, err := randomChoice(active)
+			if err != nil {
+				return "", err
+			}
+			password = append(password, c)
+		}
+	}
+	if len(pool) == 0 {
+		return "", errors.New("no characters available for the password")
+	}
+
+	for len(password) < opts.Length {
+		c, err := randomChoice(pool)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	// Shuffle so the required characters aren't always first
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+	generated := string(password)
+	clear(password)
+	return generated, nil
+}
+
+func randomChoice(chars []byte) (byte, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i.Int64()], nil
+}
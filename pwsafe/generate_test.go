@@ -0,0 +1,36 @@
+package pwsafe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		pw, err := GeneratePassword(DefaultPasswordOptions)
+		assert.NoError(t, err)
+		assert.Len(t, pw, 20)
+		assert.True(t, strings.ContainsAny(pw, CharsUpper), pw)
+		assert.True(t, strings.ContainsAny(pw, CharsLower), pw)
+		assert.False(t, strings.ContainsAny(pw, DefaultPasswordOptions.Exclude), pw)
+		seen[pw] = true
+	}
+	assert.Len(t, seen, 50)
+
+	pw, err := GeneratePassword(PasswordOptions{Length: 8, Upper: CharsetExcluded, Lower: CharsetExcluded, Digits: CharsetRequired, Symbols: CharsetExcluded, Exclude: "0123456789"[1:]})
+	assert.NoError(t, err)
+	assert.Equal(t, "00000000", pw)
+
+	// Required sets are honored for short passwords as far as the length allows
+	pw, err = GeneratePassword(PasswordOptions{Length: 2, Upper: CharsetRequired, Lower: CharsetRequired, Digits: CharsetRequired})
+	assert.NoError(t, err)
+	assert.Len(t, pw, 2)
+
+	_, err = GeneratePassword(PasswordOptions{Length: 0})
+	assert.Error(t, err)
+	_, err = GeneratePassword(PasswordOptions{Length: 8, Upper: CharsetExcluded, Lower: CharsetExcluded, Digits: CharsetExcluded, Symbols: CharsetExcluded})
+	assert.Error(t, err)
+}
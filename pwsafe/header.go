@@ -2,7 +2,6 @@ package pwsafe
 
 import (
 	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"slices"
@@ -112,13 +111,13 @@ func (h *header) setField(id byte, data []byte) error {
 	case headerTree:
 		h.Tree = string(data)
 	case headerLastSave:
-		h.LastSave = time.Unix(int64(binary.LittleEndian.Uint32(data)), 0)
+		return setTime(&h.LastSave, data)
 	case headerLastSaveBy:
-		h.LastSaveBy = data
+		h.LastSaveBy = bytes.Clone(data)
 	case headerLastSaveUser:
-		h.LastSaveUser = data
+		h.LastSaveUser = bytes.Clone(data)
 	case headerLastSaveHost:
-		h.LastSaveHost = data
+		h.LastSaveHost = bytes.Clone(data)
 	case headerName:
 		h.Name = string(data)
 	case headerDescription:
@@ -137,31 +136,12 @@ func (h *header) setField(id byte, data []byte) error {
 	return nil
 }
 
-// marshal returns the binary format for the header and the values used for hmac calculations
-func (h *header) marshal() ([]byte, []byte) {
-	var recordBuf bytes.Buffer
-	var hmacBuf bytes.Buffer
-
-	// Helper to append a field
-	appendField := func(id byte, data any) {
-		size := binary.Size(data)
-		if size <= 0 {
-			return
-		}
-		// Write to HMAC buffer
-		binary.Write(&hmacBuf, binary.LittleEndian, data)
-
-		// Write length
-		binary.Write(&recordBuf, binary.LittleEndian, uint32(size))
-		// Write ID
-		recordBuf.WriteByte(id)
-		// Write Data
-		binary.Write(&recordBuf, binary.LittleEndian, data)
-
-		// Write Padding
-		usedBlockSpace := (size + 5) % twofish.BlockSize
-		if usedBlockSpace != 0 {
-			recordBuf.Write(pseudoRandomBytes(twofish.BlockSize - usedBlockSpace))
+// writeFields calls write with the type and value of each non empty header field in order followed by the END field.
+func (h *header) writeFields(write func(id byte, data []byte) error) error {
+	var err error
+	appendField := func(id byte, data []byte) {
+		if err == nil && len(data) > 0 {
+			err = write(id, data)
 		}
 	}
 
@@ -171,7 +151,7 @@ func (h *header) marshal() ([]byte, []byte) {
 	appendField(headerPreferences, []byte(h.Preferences))
 	appendField(headerTree, []byte(h.Tree))
 	if !h.LastSave.IsZero() {
-		appendField(headerLastSave, uint32(h.LastSave.Unix()))
+		appendField(headerLastSave, encodeTime(h.LastSave))
 	}
 	appendField(headerLastSaveBy, h.LastSaveBy)
 	appendField(headerLastSaveUser, h.LastSaveUser)
@@ -184,47 +164,42 @@ func (h *header) marshal() ([]byte, []byte) {
 	for _, group := range h.EmptyGroups {
 		appendField(headerEmptyGroups, []byte(group))
 	}
+	if err != nil {
+		return err
+	}
 
 	// End of entry
-	recordBuf.Write([]byte{0, 0, 0, 0})
-	recordBuf.WriteByte(headerEndOfEntry)
-	recordBuf.Write(pseudoRandomBytes(twofish.BlockSize - 5))
+	return write(headerEndOfEntry, nil)
+}
+
+// rawFields is a fieldSetter which keeps the data of each field it sets.
+type rawFields struct {
+	setter fieldSetter
+	data   []byte
+}
 
-	return recordBuf.Bytes(), hmacBuf.Bytes()
+func (r *rawFields) setField(id byte, data []byte) error {
+	r.data = append(r.data, data...)
+	return r.setter.setField(id, data)
 }
 
 // UnmarshalHeader takes a byte slice and unmarshals it into a header struct, also returning the next position in the data and raw bytes
-// used so they can be reused for HMAC calculations.
+// used so they can be reused for HMAC calculations. The data is the decrypted fields, read as Decrypt reads them.
 func UnmarshalHeader(data []byte) (header, int, []byte, error) {
-	var h header
-	var rdata []byte
-	fieldStart := 0
-	for {
-		if fieldStart+5 > len(data) {
-			return h, 0, rdata, errors.New("no END field found when UnMarshaling")
-		}
-		fieldLength := int(binary.LittleEndian.Uint32(data[fieldStart : fieldStart+4]))
-		btype := data[fieldStart+4 : fieldStart+5][0]
-		if fieldStart+fieldLength+5 > len(data) {
-			return h, fieldStart, rdata, fmt.Errorf("invalid field length %d at offset %d, exceeds data length %d", fieldLength, fieldStart, len(data))
-		}
-		fieldData := data[fieldStart+5 : fieldStart+fieldLength+5]
-		rdata = append(rdata, fieldData...)
-		fieldStart += fieldLength + 5
-		//The next field must start on a block boundary
-		blockmod := fieldStart % twofish.BlockSize
-		if blockmod != 0 {
-			fieldStart += twofish.BlockSize - blockmod
-		}
+	// Pad to a whole block and end with the EOF block so a missing END field or a field running past the data are
+	// reported as they are for a db
+	padded := make([]byte, (len(data)+twofish.BlockSize-1)/twofish.BlockSize*twofish.BlockSize, len(data)+2*twofish.BlockSize)
+	copy(padded, data)
+	padded = append(padded, "PWS3-EOFPWS3-EOF"...)
 
-		if btype == headerEndOfEntry {
-			return h, fieldStart, rdata, nil
-		}
-
-		if err := h.setField(btype, fieldData); err != nil {
-			// For forward compatibility, maybe we should ignore unknown fields?
-			// But the original code returned error.
-			return h, fieldStart, rdata, err
+	var h header
+	fields := &fieldReader{r: bytes.NewReader(padded), limit: -1, index: -1}
+	raw := &rawFields{setter: &h}
+	if err := fields.readEntry(raw); err != nil {
+		if err == errEndOfEncrypted {
+			err = errors.New("no END field found when UnMarshaling")
 		}
+		return h, fields.offset, raw.data, err
 	}
+	return h, fields.offset, raw.data, nil
 }
@@ -3,10 +3,13 @@ package pwsafe
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/twofish"
 )
 
@@ -47,7 +50,7 @@ func buildHeaderField(fieldType byte, data []byte) []byte {
 	return fieldBuf.Bytes()
 }
 
-func TestUnmarshalHeader_MissingEndField(t *testing.T) {
+func TestReadHeader_MissingEndField(t *testing.T) {
 	// Field 1: Version (type 0x00, data {0x0E, 0x03})
 	versionData := []byte{0x0E, 0x03}
 	versionFieldBytes := buildHeaderField(0x00, versionData)
@@ -59,15 +62,14 @@ func TestUnmarshalHeader_MissingEndField(t *testing.T) {
 	// Concatenate fields - NO END field
 	headerBytes := append(versionFieldBytes, nameFieldBytes...)
 
-	// var h header // h is not used when only checking for error
-	_, _, _, err := UnmarshalHeader(headerBytes) // Use _, _, _, err as return values match this
+	var h header
+	err := decryptEntry(t, encryptPlaintext(t, headerBytes), &h)
 
-	assert.NotNil(t, err, "UnmarshalHeader should return an error for missing END field")
-	// Based on header.go, the error for running out of data before END is found:
+	assert.NotNil(t, err, "reading the header should return an error for missing END field")
 	assert.Equal(t, "no END field found when UnMarshaling", err.Error(), "Error message mismatch")
 }
 
-func TestUnmarshalHeader_UnknownFieldType(t *testing.T) {
+func TestReadHeader_UnknownFieldType(t *testing.T) {
 	// Field 1: Version (type 0x00, data {0x0E, 0x03})
 	versionData := []byte{0x0E, 0x03}
 	versionFieldBytes := buildHeaderField(0x00, versionData)
@@ -83,16 +85,21 @@ func TestUnmarshalHeader_UnknownFieldType(t *testing.T) {
 	headerBytes := append(versionFieldBytes, unknownFieldBytes...)
 	headerBytes = append(headerBytes, endFieldBytes...)
 
-	// var h header // h is not used when only checking for error
-	_, _, _, err := UnmarshalHeader(headerBytes)
+	var h header
+	err := decryptEntry(t, encryptPlaintext(t, headerBytes), &h)
 
-	assert.NotNil(t, err, "UnmarshalHeader should return an error for unknown field type")
+	assert.NotNil(t, err, "reading the header should return an error for unknown field type")
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr))
+	assert.Equal(t, -1, fieldErr.RecordIndex)
+	assert.Equal(t, byte(0xFE), fieldErr.FieldType)
+	assert.Equal(t, len(versionFieldBytes), fieldErr.Offset)
 	// Based on header.go, the error for unknown field type:
 	expectedError := fmt.Sprintf("encountered unknown Header Field type - %v", 0xFE)
-	assert.Equal(t, expectedError, err.Error(), "Error message mismatch")
+	assert.Equal(t, expectedError, fieldErr.Err.Error(), "Error message mismatch")
 }
 
-func TestUnmarshalHeader_FieldLengthExceedsData(t *testing.T) {
+func TestReadHeader_FieldLengthExceedsData(t *testing.T) {
 	// Field 1: Version (type 0x00, data {0x0E, 0x03})
 	versionFieldBytes := buildHeaderField(0x00, []byte{0x0E, 0x03})
 
@@ -107,32 +114,58 @@ func TestUnmarshalHeader_FieldLengthExceedsData(t *testing.T) {
 	binary.LittleEndian.PutUint32(lenBytes, declaredLength)
 	malformedFieldHeader.Write(lenBytes)
 	malformedFieldHeader.WriteByte(fieldTypeProblem)
-	// DO NOT append padding here as UnmarshalHeader will try to read data first based on declaredLength
 
 	headerBytes := append(versionFieldBytes, malformedFieldHeader.Bytes()...)
 	headerBytes = append(headerBytes, actualData...) // Append only the short actual data
 
-	// UnmarshalHeader will try to read `fieldStart+5 : fieldStart+fieldLength+5`.
-	// If fieldLength is 255, this will be fieldStart+5 : fieldStart+255+5.
-	// This is expected to panic due to out-of-bounds slice access.
-	// UnmarshalHeader should now return an error instead of panicking
-	_, _, _, err := UnmarshalHeader(headerBytes)
+	// The field runs into the EOF block, which must be reported rather than read as field data
+	var h header
+	err := decryptEntry(t, encryptPlaintext(t, headerBytes), &h)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "invalid field length", "Error should indicate invalid field length")
+	var fieldErr *FieldError
+	require.True(t, errors.As(err, &fieldErr))
+	assert.Equal(t, fieldTypeProblem, fieldErr.FieldType)
+	assert.Equal(t, len(versionFieldBytes), fieldErr.Offset)
 }
 
-func TestUnmarshalHeader_EmptyOrTooShortInput(t *testing.T) {
-	t.Run("Empty byte slice", func(t *testing.T) {
-		emptyData := []byte{}
-		_, _, _, err := UnmarshalHeader(emptyData)
-		assert.NotNil(t, err, "Should return error with empty data slice")
-		assert.Equal(t, "no END field found when UnMarshaling", err.Error())
+func TestReadHeader_EmptyOrTooShortInput(t *testing.T) {
+	t.Run("No fields", func(t *testing.T) {
+		var h header
+		err := decryptEntry(t, encryptPlaintext(t, nil), &h)
+		assert.ErrorIs(t, err, errEndOfEncrypted)
 	})
 
-	t.Run("Too short for field header", func(t *testing.T) {
-		shortData := []byte{0x01, 0x02, 0x03} // Only 3 bytes
-		_, _, _, err := UnmarshalHeader(shortData)
-		assert.NotNil(t, err, "Should return error with data slice too short for a field header")
-		assert.Equal(t, "no END field found when UnMarshaling", err.Error())
+	t.Run("Truncated encrypted data", func(t *testing.T) {
+		var h header
+		err := decryptEntry(t, []byte{0x01, 0x02, 0x03}, &h)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
 	})
 }
+
+func TestUnmarshalHeader(t *testing.T) {
+	versionField := buildHeaderField(headerVersion, []byte{0x0E, 0x03})
+	nameField := buildHeaderField(headerName, []byte("TestDB"))
+	data := append(append(versionField, nameField...), buildHeaderField(headerEndOfEntry, nil)...)
+	next := []byte("the first record")
+
+	h, pos, raw, err := UnmarshalHeader(append(data, next...))
+	require.NoError(t, err)
+	assert.Equal(t, [2]byte{0x0E, 0x03}, h.Version)
+	assert.Equal(t, "TestDB", h.Name)
+	assert.Equal(t, len(data), pos)
+	assert.Equal(t, []byte("\x0E\x03TestDB"), raw)
+
+	_, _, _, err = UnmarshalHeader(append(versionField, nameField...))
+	assert.EqualError(t, err, "no END field found when UnMarshaling")
+
+	_, _, _, err = UnmarshalHeader(nil)
+	assert.EqualError(t, err, "no END field found when UnMarshaling")
+
+	_, _, _, err = UnmarshalHeader(append(versionField, 0xff, 0, 0, 0, headerName, 'x'))
+	var fieldErr *FieldError
+	require.ErrorAs(t, err, &fieldErr)
+	assert.ErrorIs(t, err, errInvalidFieldLength)
+	assert.Equal(t, -1, fieldErr.RecordIndex)
+	assert.Equal(t, len(versionField), fieldErr.Offset)
+}
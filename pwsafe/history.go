@@ -0,0 +1,95 @@
+package pwsafe
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PasswordHistory is the parsed form of the Record.PasswordHistory field.
+// The field format from the spec is "fmmnnTLPTLP...TLP" where f is 0/1 for history off/on, mm is the max
+// history size and nn the current size both as 2 hex digits, then for each entry T the time the password was set
+// as 8 hex digits, L the password length in characters as 4 hex digits and P the password.
+type PasswordHistory struct {
+	Enabled    bool
+	MaxEntries int
+	Entries    []PasswordHistoryEntry
+}
+
+// PasswordHistoryEntry is a single previous password and the time it was set.
+type PasswordHistoryEntry struct {
+	Password string
+	Set      time.Time
+}
+
+// ParsePasswordHistory parses the PasswordHistory field of a record, an empty field returns an empty history.
+func ParsePasswordHistory(field string) (PasswordHistory, error) {
+	var history PasswordHistory
+	if field == "" {
+		return history, nil
+	}
+	if len(field) < 5 {
+		return history, fmt.Errorf("password history too short, %d characters", len(field))
+	}
+	switch field[0] {
+	case '0':
+	case '1':
+		history.Enabled = true
+	default:
+		return history, fmt.Errorf("invalid password history status %q", field[0])
+	}
+	maxEntries, err := strconv.ParseUint(field[1:3], 16, 8)
+	if err != nil {
+		return history, fmt.Errorf("invalid password history max size %q", field[1:3])
+	}
+	history.MaxEntries = int(maxEntries)
+	count, err := strconv.ParseUint(field[3:5], 16, 8)
+	if err != nil {
+		return history, fmt.Errorf("invalid password history size %q", field[3:5])
+	}
+
+	rest := []rune(field[5:])
+	for i := 0; i < int(count); i++ {
+		if len(rest) < 12 {
+			return history, fmt.Errorf("password history entry %d is truncated", i)
+		}
+		set, err := strconv.ParseUint(string(rest[:8]), 16, 32)
+		if err != nil {
+			return history, fmt.Errorf("invalid time for password history entry %d", i)
+		}
+		length, err := strconv.ParseUint(string(rest[8:12]), 16, 16)
+		if err != nil {
+			return history, fmt.Errorf("invalid length for password history entry %d", i)
+		}
+		rest = rest[12:]
+		if int(length) > len(rest) {
+			return history, fmt.Errorf("password history entry %d length %d exceeds remaining data", i, length)
+		}
+		history.Entries = append(history.Entries, PasswordHistoryEntry{
+			Password: string(rest[:length]),
+			Set:      time.Unix(int64(set), 0),
+		})
+		rest = rest[length:]
+	}
+	if len(rest) != 0 {
+		return history, errors.New("unexpected data after the last password history entry")
+	}
+	return history, nil
+}
+
+// String returns the history in the PasswordHistory field format.
+func (h PasswordHistory) String() string {
+	var b strings.Builder
+	if h.Enabled {
+		b.WriteByte('1')
+	} else {
+		b.WriteByte('0')
+	}
+	fmt.Fprintf(&b, "%02x%02x", h.MaxEntries, len(h.Entries))
+	for _, entry := range h.Entries {
+		fmt.Fprintf(&b, "%08x%04x%s", uint32(entry.Set.Unix()), len([]rune(entry.Password)), entry.Password)
+	}
+	return b.String()
+}
@@ -0,0 +1,52 @@
+package pwsafe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePasswordHistory(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		history, err := ParsePasswordHistory("")
+		assert.NoError(t, err)
+		assert.False(t, history.Enabled)
+		assert.Empty(t, history.Entries)
+	})
+
+	t.Run("Entries", func(t *testing.T) {
+		history, err := ParsePasswordHistory("10a02" + "5f5e100f" + "0003" + "abc" + "5f5e1010" + "0004" + "pässw")
+		assert.Error(t, err, "declared length shorter than the data must fail")
+
+		history, err = ParsePasswordHistory("10a02" + "5f5e100f" + "0003" + "abc" + "5f5e1010" + "0005" + "pässw")
+		assert.NoError(t, err)
+		assert.True(t, history.Enabled)
+		assert.Equal(t, 10, history.MaxEntries)
+		assert.Equal(t, []PasswordHistoryEntry{
+			{Password: "abc", Set: time.Unix(0x5f5e100f, 0)},
+			{Password: "pässw", Set: time.Unix(0x5f5e1010, 0)},
+		}, history.Entries)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, field := range []string{"1", "2ff00", "1zz00", "10a01", "10a01zzzzzzzz0001a", "10a015f5e100f0009abc"} {
+			_, err := ParsePasswordHistory(field)
+			assert.Error(t, err, field)
+		}
+	})
+
+	t.Run("Round trip", func(t *testing.T) {
+		history := PasswordHistory{
+			Enabled:    true,
+			MaxEntries: 3,
+			Entries: []PasswordHistoryEntry{
+				{Password: "old one", Set: time.Unix(1600000000, 0)},
+				{Password: "§±¿", Set: time.Unix(1700000000, 0)},
+			},
+		}
+		parsed, err := ParsePasswordHistory(history.String())
+		assert.NoError(t, err)
+		assert.Equal(t, history, parsed)
+	})
+}
@@ -0,0 +1,201 @@
+package pwsafe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tokenFields records which fields of a record a token is found in.
+type tokenFields uint8
+
+const (
+	inNames  tokenFields = 1 << iota // title or group
+	inDetail                         // username, URL or notes
+)
+
+// searchIndex is an inverted index from the lower case, whitespace separated tokens of the searched record fields to
+// the records containing them. Search terms never contain whitespace so a term is a substring of a record's fields
+// exactly when it is a substring of one of its tokens, which lets Search check each distinct token once rather than
+// every record. Passwords are never indexed.
+type searchIndex struct {
+	tokens map[string]map[[16]byte]tokenFields
+	docs   map[[16]byte][]string       // the tokens of each record, used to remove it
+	fields map[[16]byte]searchedFields // the fields each record was indexed with, used to find records changed in Records
+}
+
+// searchedFields are the fields of a record Search looks in.
+type searchedFields struct {
+	title, group, username, url, notes string
+}
+
+func searchedFieldsOf(record Record) searchedFields {
+	return searchedFields{record.Title, record.Group, record.Username, record.URL, record.Notes}
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		tokens: make(map[string]map[[16]byte]tokenFields),
+		docs:   make(map[[16]byte][]string),
+		fields: make(map[[16]byte]searchedFields),
+	}
+}
+
+// buildSearchIndex indexes all the records.
+func buildSearchIndex(records map[[16]byte]Record) *searchIndex {
+	idx := newSearchIndex()
+	for _, record := range records {
+		idx.add(record)
+	}
+	return idx
+}
+
+// add indexes the record replacing any earlier version of it.
+func (idx *searchIndex) add(record Record) {
+	idx.remove(record.UUID)
+	var docTokens []string
+	addTokens := func(field tokenFields, values ...string) {
+		for _, value := range values {
+			for _, token := range strings.Fields(strings.ToLower(value)) {
+				postings, ok := idx.tokens[token]
+				if !ok {
+					postings = make(map[[16]byte]tokenFields)
+					idx.tokens[token] = postings
+				}
+				if _, ok := postings[record.UUID]; !ok {
+					docTokens = append(docTokens, token)
+				}
+				postings[record.UUID] |= field
+			}
+		}
+	}
+	addTokens(inNames, record.Title, record.Group)
+	addTokens(inDetail, record.Username, record.URL, record.Notes)
+	idx.docs[record.UUID] = docTokens
+	idx.fields[record.UUID] = searchedFieldsOf(record)
+}
+
+// remove drops the record from the index.
+func (idx *searchIndex) remove(id [16]byte) {
+	docTokens, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for _, token := range docTokens {
+		postings := idx.tokens[token]
+		delete(postings, id)
+		if len(postings) == 0 {
+			delete(idx.tokens, token)
+		}
+	}
+	delete(idx.docs, id)
+	delete(idx.fields, id)
+}
+
+// current returns true if every record is indexed with its present fields and no others are.
+func (idx *searchIndex) current(records map[[16]byte]Record) bool {
+	if len(idx.fields) != len(records) {
+		return false
+	}
+	for _, record := range records {
+		if fields, ok := idx.fields[record.UUID]; !ok || fields != searchedFieldsOf(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// update indexes the records added or changed and removes those deleted directly in the Records map.
+func (idx *searchIndex) update(records map[[16]byte]Record) {
+	present := make(map[[16]byte]bool, len(records))
+	for _, record := range records {
+		present[record.UUID] = true
+		if fields, ok := idx.fields[record.UUID]; !ok || fields != searchedFieldsOf(record) {
+			idx.add(record)
+		}
+	}
+	for id := range idx.fields {
+		if !present[id] {
+			idx.remove(id)
+		}
+	}
+}
+
+// reindex updates the search index for a change to the record with the given id, if the index has been built.
+func (db *V3) reindex(id [16]byte) {
+	if db.index == nil {
+		return
+	}
+	if record, ok := db.Records[id]; ok {
+		db.index.add(record)
+	} else {
+		db.index.remove(id)
+	}
+}
+
+// search returns the sorted hex UUIDs of records matching all the terms. The candidates come from the postings of
+// the term matching the fewest records, each is then checked against the other terms using its own tokens.
+func (idx *searchIndex) search(terms []string, namesOnly bool) []string {
+	want := inNames | inDetail
+	if namesOnly {
+		want = inNames
+	}
+
+	var rarest []map[[16]byte]tokenFields
+	rarestCount, rarestTerm := -1, 0
+	for i, term := range terms {
+		var postings []map[[16]byte]tokenFields
+		count := 0
+		for token, p := range idx.tokens {
+			if strings.Contains(token, term) {
+				postings = append(postings, p)
+				count += len(p)
+			}
+		}
+		if count == 0 {
+			return nil
+		}
+		if rarestCount < 0 || count < rarestCount {
+			rarest, rarestCount, rarestTerm = postings, count, i
+		}
+	}
+
+	matched := make(map[[16]byte]bool)
+	for _, postings := range rarest {
+		for id, fields := range postings {
+			if fields&want != 0 && !matched[id] && idx.matchesAll(id, terms, rarestTerm, want) {
+				matched[id] = true
+			}
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil
+	}
+	results := make([]string, 0, len(matched))
+	for id := range matched {
+		results = append(results, fmt.Sprintf("%x", id))
+	}
+	sort.Strings(results)
+	return results
+}
+
+// matchesAll returns true if the record has a token in the wanted fields containing each term other than skip.
+func (idx *searchIndex) matchesAll(id [16]byte, terms []string, skip int, want tokenFields) bool {
+	for i, term := range terms {
+		if i == skip {
+			continue
+		}
+		found := false
+		for _, token := range idx.docs[id] {
+			if strings.Contains(token, term) && idx.tokens[token][id]&want != 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package pwsafe
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// assertIndexMatchesScan checks Search through the index returns the same as scanning every record.
+func assertIndexMatchesScan(t *testing.T, db *V3, queries []string) {
+	t.Helper()
+	for _, query := range queries {
+		terms := strings.Fields(strings.ToLower(query))
+		for _, namesOnly := range []bool{true, false} {
+			assert.Equal(t, db.searchRecords(terms, namesOnly), db.Search(query, namesOnly), "query %q names only %t", query, namesOnly)
+		}
+	}
+	assert.Equal(t, len(db.Records), len(db.index.docs), "every record is indexed")
+}
+
+func TestSearchIndex(t *testing.T) {
+	db := NewV3("index", "password")
+	words := []string{"alpha", "Beta", "gamma", "delta.com", "https://example.com/login", "user@example.com", "ALPHA beta"}
+	rng := rand.New(rand.NewPCG(1, 2))
+	word := func() string { return words[rng.IntN(len(words))] }
+	var ids [][16]byte
+	for i := 0; i < 200; i++ {
+		ids = append(ids, db.SetRecord(Record{
+			Title:    word() + fmt.Sprintf(" %d", i),
+			Group:    word(),
+			Username: word(),
+			URL:      word(),
+			Notes:    word() + "\n" + word(),
+			Password: "alpha secret",
+		}))
+	}
+	queries := []string{"", "alpha", "ALP", "beta 1", "example.com", "com/log", "a", "secret", "alpha gamma delta", "missing"}
+	assertIndexMatchesScan(t, db, queries)
+	assert.Empty(t, db.Search("secret", false), "passwords are never indexed")
+
+	// Updates, deletes, undo and group renames keep the index current
+	for i, id := range ids[:50] {
+		record, _ := db.Record(id)
+		record.Title = "updated " + word()
+		record.Notes = ""
+		db.SetRecord(record)
+		if i%3 == 0 {
+			db.DeleteRecord(id)
+		}
+	}
+	assertIndexMatchesScan(t, db, append(queries, "updated"))
+	db.RenameGroup("gamma", "renamed")
+	db.Undo()
+	db.Undo()
+	db.Redo()
+	assertIndexMatchesScan(t, db, append(queries, "updated", "renamed"))
+	for _, postings := range db.index.tokens {
+		assert.NotEmpty(t, postings, "tokens without records are removed")
+	}
+
+	// Records added, changed in place and removed directly in the map are reindexed by the next search
+	db.Records[[16]byte{1}] = Record{UUID: [16]byte{1}, Title: "direct"}
+	assert.Equal(t, []string{fmt.Sprintf("%x", [16]byte{1})}, db.Search("direct", true))
+	db.Records[[16]byte{1}] = Record{UUID: [16]byte{1}, Title: "edited"}
+	assert.Equal(t, []string{fmt.Sprintf("%x", [16]byte{1})}, db.Search("edited", true))
+	assert.Empty(t, db.Search("direct", true))
+	record, _ := db.Record(ids[60])
+	record.Group = "moved"
+	db.Records[ids[60]] = record
+	assert.Equal(t, []string{fmt.Sprintf("%x", ids[60])}, db.Search("moved", true))
+	delete(db.Records, ids[61])
+	assertIndexMatchesScan(t, db, append(queries, "updated", "renamed", "edited", "moved"))
+	assert.NotContains(t, db.index.docs, ids[61])
+}
+
+func TestSearchIndexDecrypt(t *testing.T) {
+	db, err := OpenPWSafeFile("./test_dbs/three.dat", "three3#;")
+	assert.Nil(t, err)
+	assertIndexMatchesScan(t, db, []string{"three", "3", "user", "example"})
+	assert.NoError(t, db.Lock())
+	assert.Nil(t, db.index)
+	assert.NoError(t, db.Unlock("three3#;"))
+	assertIndexMatchesScan(t, db, []string{"three", "3", "user", "example"})
+}
@@ -0,0 +1,244 @@
+package pwsafe
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultUndoLimit is the number of changes kept for Undo unless changed with SetUndoLimit.
+const DefaultUndoLimit = 100
+
+// recordChange is the state of a record before and after a change, nil when the record doesn't exist.
+type recordChange struct {
+	id            [16]byte
+	before, after *Record
+}
+
+// headerInfo holds the header fields which are edited through the journal.
+type headerInfo struct {
+	name, description string
+	emptyGroups       []string
+}
+
+// change is a reversible edit of the db made up of the records and header fields it modified.
+type change struct {
+	records       []recordChange
+	before, after *headerInfo
+}
+
+// journal holds the changes which can be undone and redone, the most recent last.
+type journal struct {
+	undo, redo []change
+	limit      int
+}
+
+// record adds a change made to the db, dropping the oldest change once the limit is reached and the changes undone
+// as they can no longer be redone.
+func (j *journal) record(c change) {
+	limit := j.limit
+	if limit == 0 {
+		limit = DefaultUndoLimit
+	}
+	if limit < 0 {
+		return
+	}
+	j.undo = append(j.undo, c)
+	if len(j.undo) > limit {
+		j.undo = slices.Delete(j.undo, 0, len(j.undo)-limit)
+	}
+	clear(j.redo)
+	j.redo = j.redo[:0]
+}
+
+// reset drops all changes, the journal holds cleartext records so it is reset when they are dropped from the db.
+func (j *journal) reset() {
+	clear(j.undo)
+	clear(j.redo)
+	j.undo, j.redo = nil, nil
+}
+
+// apply sets the records and header to their state after the change, or before it when reverse is true.
+func (db *V3) apply(c change, reverse bool) {
+	for _, rc := range c.records {
+		state := rc.after
+		if reverse {
+			state = rc.before
+		}
+		if state == nil {
+			delete(db.Records, rc.id)
+		} else {
+			db.Records[rc.id] = *state
+			db.order.add(rc.id)
+		}
+		db.reindex(rc.id)
+	}
+	state := c.after
+	if reverse {
+		state = c.before
+	}
+	if state != nil {
+		db.Header.Name = state.name
+		db.Header.Description = state.description
+		db.Header.EmptyGroups = slices.Clone(state.emptyGroups)
+	}
+	db.LastMod = time.Now()
+}
+
+// Undo reverts the most recent change to the records, header or groups returning false if there is nothing to undo.
+func (db *V3) Undo() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(db.journal.undo) == 0 {
+		return false
+	}
+	last := len(db.journal.undo) - 1
+	c := db.journal.undo[last]
+	db.journal.undo = db.journal.undo[:last]
+	db.apply(c, true)
+	db.journal.redo = append(db.journal.redo, c)
+	return true
+}
+
+// Redo reapplies the most recently undone change returning false if there is nothing to redo. Any new change
+// discards the undone changes.
+func (db *V3) Redo() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(db.journal.redo) == 0 {
+		return false
+	}
+	last := len(db.journal.redo) - 1
+	c := db.journal.redo[last]
+	db.journal.redo = db.journal.redo[:last]
+	db.apply(c, false)
+	db.journal.undo = append(db.journal.undo, c)
+	return true
+}
+
+// CanUndo returns true if there is a change to undo.
+func (db *V3) CanUndo() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.journal.undo) > 0
+}
+
+// CanRedo returns true if there is an undone change to redo.
+func (db *V3) CanRedo() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.journal.redo) > 0
+}
+
+// SetUndoLimit sets the number of changes kept for Undo, dropping the oldest if more are held. A limit of zero or less
+// disables the journal.
+func (db *V3) SetUndoLimit(limit int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if limit == 0 {
+		limit = -1
+	}
+	db.journal.limit = limit
+	if limit < 0 {
+		db.journal.reset()
+	} else if len(db.journal.undo) > limit {
+		db.journal.undo = slices.Delete(db.journal.undo, 0, len(db.journal.undo)-limit)
+	}
+}
+
+// SetHeaderInfo sets the name and description of the db, a locked or closed db is left unchanged.
+func (db *V3) SetHeaderInfo(name, description string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.unavailable() || db.Header.Name == name && db.Header.Description == description {
+		return
+	}
+	before := db.Header.info()
+	db.Header.Name = name
+	db.Header.Description = description
+	db.journal.record(change{before: before, after: db.Header.info()})
+	db.LastMod = time.Now()
+}
+
+// AddEmptyGroup adds a group with no records so it is kept in the db, returning false if the group is already in use
+// or the db is locked or closed.
+func (db *V3) AddEmptyGroup(group string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.unavailable() || group == "" || slices.Contains(db.Header.EmptyGroups, group) {
+		return false
+	}
+	for _, record := range db.Records {
+		if record.Group == group {
+			return false
+		}
+	}
+	before := db.Header.info()
+	db.Header.EmptyGroups = append(db.Header.EmptyGroups, group)
+	db.journal.record(change{before: before, after: db.Header.info()})
+	db.LastMod = time.Now()
+	return true
+}
+
+// RenameGroup moves the records and empty groups in the group, including its subgroups, to the new group name
+// returning the number of records moved. Groups are dot separated with subgroups following their parent, so renaming
+// "a" to "b" moves "a.c" to "b.c". The rename is a single change for Undo.
+func (db *V3) RenameGroup(oldGroup, newGroup string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.unavailable() || oldGroup == "" || oldGroup == newGroup {
+		return 0
+	}
+	rename := func(group string) (string, bool) {
+		if group == oldGroup {
+			return newGroup, true
+		}
+		if rest, ok := strings.CutPrefix(group, oldGroup+"."); ok {
+			if newGroup == "" {
+				return rest, true
+			}
+			return newGroup + "." + rest, true
+		}
+		return group, false
+	}
+
+	now := time.Now()
+	var c change
+	for id, record := range db.Records {
+		group, ok := rename(record.Group)
+		if !ok {
+			continue
+		}
+		before := record
+		record.Group = group
+		record.ModTime = now
+		db.Records[id] = record
+		db.reindex(id)
+		c.records = append(c.records, recordChange{id: id, before: &before, after: &record})
+	}
+
+	var emptyRenamed bool
+	before := db.Header.info()
+	for i, group := range db.Header.EmptyGroups {
+		if renamed, ok := rename(group); ok {
+			db.Header.EmptyGroups[i] = renamed
+			emptyRenamed = true
+		}
+	}
+	if emptyRenamed {
+		db.Header.EmptyGroups = slices.DeleteFunc(db.Header.EmptyGroups, func(group string) bool { return group == "" })
+		c.before, c.after = before, db.Header.info()
+	}
+
+	if len(c.records) == 0 && !emptyRenamed {
+		return 0
+	}
+	db.journal.record(c)
+	db.LastMod = now
+	return len(c.records)
+}
+
+// info copies the header fields edited through the journal.
+func (h *header) info() *headerInfo {
+	return &headerInfo{name: h.Name, description: h.Description, emptyGroups: slices.Clone(h.EmptyGroups)}
+}
@@ -0,0 +1,159 @@
+package pwsafe
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUndoRedoRecords(t *testing.T) {
+	db := NewV3("journal", "password")
+	assert.False(t, db.Undo())
+	assert.False(t, db.Redo())
+
+	id := db.SetRecord(Record{Title: "title", Password: "first"})
+	record, _ := db.Record(id)
+	record.Password = "second"
+	db.SetRecord(record)
+	db.DeleteRecord(id)
+	assert.True(t, db.CanUndo())
+	assert.False(t, db.CanRedo())
+
+	// Undo the delete, the edit then the add
+	assert.True(t, db.Undo())
+	record, ok := db.Record(id)
+	assert.True(t, ok)
+	assert.Equal(t, "second", record.Password)
+	assert.True(t, db.Undo())
+	record, _ = db.Record(id)
+	assert.Equal(t, "first", record.Password)
+	assert.True(t, db.Undo())
+	_, ok = db.Record(id)
+	assert.False(t, ok)
+	assert.False(t, db.Undo())
+
+	assert.True(t, db.Redo())
+	record, _ = db.Record(id)
+	assert.Equal(t, "first", record.Password)
+	assert.True(t, db.NeedsSave())
+
+	// A new change discards the undone changes
+	db.SetRecord(Record{Title: "other", Password: "secret"})
+	assert.False(t, db.CanRedo())
+	assert.True(t, db.Undo())
+	assert.True(t, db.Undo())
+	assert.Empty(t, db.Snapshot())
+
+	// Unchanged records and deleting missing records aren't recorded
+	id = db.SetRecord(Record{Title: "title", Password: "first"})
+	record, _ = db.Record(id)
+	db.SetRecord(record)
+	db.DeleteRecord([16]byte{1})
+	assert.True(t, db.Undo())
+	assert.False(t, db.CanUndo())
+}
+
+func TestReplaceRecord(t *testing.T) {
+	db := NewV3("journal", "password")
+	id := db.SetRecord(Record{Title: "a", Password: "secret"})
+	db.SetRecord(Record{Title: "b", Password: "secret"})
+	original, _ := db.Record(id)
+
+	// Changing the UUID is a single change and keeps the record's position
+	record := original
+	record.UUID = [16]byte{1}
+	record.Title = "renamed"
+	assert.Equal(t, record.UUID, db.ReplaceRecord(id, record))
+	_, ok := db.Record(id)
+	assert.False(t, ok)
+	replaced, ok := db.Record(record.UUID)
+	assert.True(t, ok)
+	assert.Equal(t, original.CreateTime, replaced.CreateTime)
+	assert.Equal(t, []string{"renamed", "b"}, titles(db.Snapshot()))
+
+	assert.True(t, db.Undo())
+	assert.Equal(t, []string{"a", "b"}, titles(db.Snapshot()))
+	restored, ok := db.Record(id)
+	assert.True(t, ok)
+	assert.Equal(t, original, restored)
+	assert.True(t, db.Redo())
+	assert.Equal(t, []string{"renamed", "b"}, titles(db.Snapshot()))
+
+	// Keeping the UUID is an update
+	replaced.Title = "updated"
+	assert.Equal(t, replaced.UUID, db.ReplaceRecord(replaced.UUID, replaced))
+	assert.Equal(t, []string{"updated", "b"}, titles(db.Snapshot()))
+	assert.True(t, db.Undo())
+	assert.Equal(t, []string{"renamed", "b"}, titles(db.Snapshot()))
+}
+
+func TestUndoHeaderAndGroups(t *testing.T) {
+	db := NewV3("journal", "password")
+	db.SetRecord(Record{Title: "a", Group: "work", Password: "secret"})
+	db.SetRecord(Record{Title: "b", Group: "work.mail", Password: "secret"})
+	db.SetRecord(Record{Title: "c", Group: "workshop", Password: "secret"})
+	assert.True(t, db.AddEmptyGroup("work.empty"))
+	assert.False(t, db.AddEmptyGroup("work"), "groups with records aren't empty")
+	assert.False(t, db.AddEmptyGroup("work.empty"))
+
+	db.SetHeaderInfo("renamed", "a description")
+	assert.Equal(t, 2, db.RenameGroup("work", "job"))
+	assert.ElementsMatch(t, []string{"job", "job.mail", "workshop"}, db.Groups())
+	assert.Equal(t, []string{"job.empty"}, db.Header.EmptyGroups)
+
+	// The rename is undone in one step
+	assert.True(t, db.Undo())
+	assert.ElementsMatch(t, []string{"work", "work.mail", "workshop"}, db.Groups())
+	assert.Equal(t, []string{"work.empty"}, db.Header.EmptyGroups)
+
+	assert.True(t, db.Undo())
+	assert.Equal(t, "journal", db.Header.Name)
+	assert.Equal(t, "", db.Header.Description)
+	assert.True(t, db.Redo())
+	assert.Equal(t, "renamed", db.Header.Name)
+	assert.Equal(t, "a description", db.Header.Description)
+
+	assert.True(t, db.Undo())
+	assert.True(t, db.Undo())
+	assert.Empty(t, db.Header.EmptyGroups)
+	assert.Equal(t, 0, db.RenameGroup("missing", "other"))
+}
+
+func TestUndoLimit(t *testing.T) {
+	db := NewV3("journal", "password")
+	for i := 0; i < DefaultUndoLimit+10; i++ {
+		db.SetRecord(Record{Title: fmt.Sprintf("title %d", i), Password: "secret"})
+	}
+	undone := 0
+	for db.Undo() {
+		undone++
+	}
+	assert.Equal(t, DefaultUndoLimit, undone)
+	assert.Equal(t, 10, len(db.Snapshot()))
+
+	db.SetUndoLimit(2)
+	for i := 0; i < 5; i++ {
+		db.SetRecord(Record{Title: fmt.Sprintf("limited %d", i), Password: "secret"})
+	}
+	assert.True(t, db.Undo())
+	assert.True(t, db.Undo())
+	assert.False(t, db.Undo())
+
+	db.SetUndoLimit(0)
+	db.SetRecord(Record{Title: "unrecorded", Password: "secret"})
+	assert.False(t, db.CanUndo())
+}
+
+func TestJournalDroppedWithRecords(t *testing.T) {
+	db := NewV3("journal", "password")
+	db.SetRecord(Record{Title: "title", Password: "secret"})
+	assert.NoError(t, db.Lock())
+	assert.False(t, db.CanUndo())
+	assert.NoError(t, db.Unlock("password"))
+	assert.False(t, db.Undo())
+
+	db.SetRecord(Record{Title: "other", Password: "secret"})
+	db.Close()
+	assert.False(t, db.CanUndo())
+}
@@ -0,0 +1,86 @@
+package pwsafe
+
+import "crypto/sha256"
+
+// secretKeys holds the key material for an open db. It is kept in its own allocation which is locked into memory
+// where the platform supports it so the keys aren't swapped to disk, and is zeroed when the db is closed.
+type secretKeys struct {
+	stretched  [sha256.Size]byte
+	encryption [32]byte
+	hmac       [32]byte
+}
+
+func newSecretKeys() *secretKeys {
+	k := &secretKeys{}
+	lockMemory(k.stretched[:])
+	lockMemory(k.encryption[:])
+	lockMemory(k.hmac[:])
+	return k
+}
+
+// wipe zeroes the keys and releases the memory lock.
+func (k *secretKeys) wipe() {
+	clear(k.stretched[:])
+	clear(k.encryption[:])
+	clear(k.hmac[:])
+	unlockMemory(k.stretched[:])
+	unlockMemory(k.encryption[:])
+	unlockMemory(k.hmac[:])
+}
+
+// secrets returns the db keys allocating them on first use.
+func (db *V3) secrets() *secretKeys {
+	if db.keys == nil {
+		db.keys = newSecretKeys()
+	}
+	return db.keys
+}
+
+// StretchedKey returns a copy of the key derived from the password, used to encrypt the encryption and HMAC keys.
+func (db *V3) StretchedKey() [sha256.Size]byte {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.keys == nil {
+		return [sha256.Size]byte{}
+	}
+	return db.keys.stretched
+}
+
+// EncryptionKey returns a copy of the key the records are encrypted with.
+func (db *V3) EncryptionKey() [32]byte {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.keys == nil {
+		return [32]byte{}
+	}
+	return db.keys.encryption
+}
+
+// HMACKey returns a copy of the key used for the HMAC of the db contents.
+func (db *V3) HMACKey() [32]byte {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.keys == nil {
+		return [32]byte{}
+	}
+	return db.keys.hmac
+}
+
+// Close zeroes the key material and drops the header and records so secrets don't outlive their use, for example
+// after a period of inactivity. Go strings can't be overwritten so the record values are released to the garbage
+// collector rather than wiped. The db must be opened again before further use.
+func (db *V3) Close() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.keys != nil {
+		db.keys.wipe()
+		db.keys = nil
+	}
+	clear(db.Records)
+	db.Records = nil
+	db.order.reset()
+	db.index = nil
+	db.Header = header{}
+	db.locked = nil
+	db.journal.reset()
+}
@@ -0,0 +1,72 @@
+package pwsafe
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrLocked is returned when changing a db which is locked or closed.
+var ErrLocked = errors.New("the db is locked or closed")
+
+// Lock encrypts the header and records in memory and discards the cleartext and keys, like the reference client's
+// lock on idle. The records are encrypted as they would be in the file, under fresh keys wrapped by the key stretched
+// from the master password, so only the password can unlock them. While locked the db has no records and can't be
+// saved or changed, LastSavePath and the unsaved changes state are kept.
+func (db *V3) Lock() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.locked != nil {
+		return nil
+	}
+	if db.keys == nil {
+		return errors.New("the db is closed or has no password set")
+	}
+	var buf bytes.Buffer
+	if err := db.writeEncrypted(&buf); err != nil {
+		return err
+	}
+	db.locked = buf.Bytes()
+	db.keys.wipe()
+	db.keys = nil
+	clear(db.Records)
+	db.Records = nil
+	db.order.reset()
+	db.index = nil
+	db.Header = header{}
+	db.journal.reset()
+	return nil
+}
+
+// Unlock verifies the password against the stretched key of the locked db and restores the header and records.
+// The db is left locked if the password is wrong.
+func (db *V3) Unlock(password string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.locked == nil {
+		return errors.New("the db is not locked")
+	}
+	var unlocked V3
+	if _, err := unlocked.Decrypt(bytes.NewReader(db.locked), password); err != nil {
+		unlocked.Close()
+		return err
+	}
+	db.Header = unlocked.Header
+	db.Records = unlocked.Records
+	db.order = unlocked.order
+	db.index = unlocked.index
+	db.keys = unlocked.keys
+	db.locked = nil
+	return nil
+}
+
+// Locked returns true if the db has been locked with Lock and not yet unlocked.
+func (db *V3) Locked() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.locked != nil
+}
+
+// unavailable returns true if the db has no records to change because it is locked or closed, the lock must be held.
+func (db *V3) unavailable() bool {
+	return db.Records == nil
+}
@@ -0,0 +1,115 @@
+package pwsafe
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockUnlock(t *testing.T) {
+	db, err := OpenPWSafeFile("./test_dbs/three.dat", "three3#;")
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, db.Encrypt(&buf))
+	var saved V3
+	_, err = saved.Decrypt(&buf, "three3#;")
+	assert.Nil(t, err)
+
+	// An unsaved edit and a record which couldn't be saved survive locking
+	db.SetRecord(Record{Title: "no password", Group: "test"})
+	assert.True(t, db.NeedsSave())
+	records := len(db.Records)
+	keys := db.keys
+
+	assert.NoError(t, db.Lock())
+	assert.True(t, db.Locked())
+	assert.Equal(t, secretKeys{}, *keys, "the key material must be zeroed")
+	assert.Nil(t, db.keys)
+	assert.Empty(t, db.Records)
+	assert.Equal(t, header{}, db.Header)
+	assert.NoError(t, db.Lock(), "locking a locked db is harmless")
+
+	buf.Reset()
+	assert.Error(t, db.Encrypt(&buf))
+	assert.Equal(t, 0, buf.Len())
+
+	assert.ErrorIs(t, db.Unlock("wrong"), ErrInvalidPassword)
+	assert.True(t, db.Locked())
+	assert.Empty(t, db.Records)
+
+	assert.NoError(t, db.Unlock("three3#;"))
+	assert.False(t, db.Locked())
+	assert.Equal(t, records, len(db.Records))
+	assert.True(t, db.NeedsSave())
+	_, ok := db.RecordByTitle("no password")
+	assert.True(t, ok)
+	equal, err := db.Header.Equal(saved.Header)
+	assert.True(t, equal, err)
+	assert.Error(t, db.Unlock("three3#;"), "the db is no longer locked")
+
+	// The unlocked db saves with the original password
+	for uuid, record := range db.Records {
+		if record.Title == "no password" {
+			db.DeleteRecord(uuid)
+		}
+	}
+	buf.Reset()
+	assert.NoError(t, db.Encrypt(&buf))
+	var reread V3
+	_, err = reread.Decrypt(&buf, "three3#;")
+	assert.Nil(t, err)
+	equal, err = reread.Equal(&saved)
+	assert.True(t, equal, err)
+}
+
+func TestLockClosed(t *testing.T) {
+	var db V3
+	assert.Error(t, db.Lock())
+	assert.False(t, db.Locked())
+
+	locked := NewV3("locked", "password")
+	locked.SetRecord(Record{Title: "title", Password: "secret", ModTime: time.Now()})
+	assert.NoError(t, locked.Lock())
+	locked.Close()
+	assert.False(t, locked.Locked())
+	assert.Error(t, locked.Unlock("password"))
+}
+
+func TestChangesWhileLocked(t *testing.T) {
+	for _, state := range []string{"locked", "closed"} {
+		t.Run(state, func(t *testing.T) {
+			db := NewV3("locked", "password")
+			db.SetRecord(Record{Title: "title", Group: "group", Password: "secret"})
+			require.NoError(t, db.Lock())
+			if state == "closed" {
+				db.Close()
+			}
+
+			assert.Equal(t, [16]byte{}, db.SetRecord(Record{Title: "new", Password: "secret"}))
+			_, err := db.SetRecordUnique(Record{Title: "new", Password: "secret"})
+			assert.ErrorIs(t, err, ErrLocked)
+			assert.ErrorIs(t, db.SetPassword("changed"), ErrLocked)
+			db.DeleteRecord([16]byte{1})
+			db.SetHeaderInfo("renamed", "description")
+			assert.False(t, db.AddEmptyGroup("empty"))
+			assert.Equal(t, 0, db.RenameGroup("group", "renamed"))
+			assert.Empty(t, db.Fix())
+			assert.False(t, db.Undo())
+			assert.Nil(t, db.Records)
+			assert.False(t, db.CanUndo())
+			if state == "closed" {
+				return
+			}
+
+			require.NoError(t, db.Unlock("password"))
+			assert.Len(t, db.Records, 1)
+			_, err = db.RecordByPath("group", "title")
+			assert.NoError(t, err)
+			assert.Equal(t, "locked", db.Header.Name)
+			assert.False(t, db.CanUndo())
+		})
+	}
+}
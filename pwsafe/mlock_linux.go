@@ -0,0 +1,12 @@
+package pwsafe
+
+import "syscall"
+
+// lockMemory is a best effort mlock of the pages holding b, failures such as exceeding RLIMIT_MEMLOCK are ignored.
+func lockMemory(b []byte) {
+	_ = syscall.Mlock(b)
+}
+
+func unlockMemory(b []byte) {
+	_ = syscall.Munlock(b)
+}
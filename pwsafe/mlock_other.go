@@ -0,0 +1,8 @@
+//go:build !linux
+
+package pwsafe
+
+// lockMemory is a no-op where locking memory isn't supported.
+func lockMemory(b []byte) {}
+
+func unlockMemory(b []byte) {}
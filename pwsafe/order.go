@@ -0,0 +1,61 @@
+package pwsafe
+
+import (
+	"cmp"
+	"slices"
+)
+
+// recordOrder numbers the records in the order they were read from the file or added so they are written in the same
+// order on every save, keeping the file stable for diffs and sync tools. Deleted records keep their number so Undo
+// puts them back where they were.
+type recordOrder struct {
+	seq  map[[16]byte]uint64
+	next uint64
+}
+
+// add numbers the record after all the others unless it already has a number.
+func (o *recordOrder) add(id [16]byte) {
+	if o.seq == nil {
+		o.seq = make(map[[16]byte]uint64)
+	}
+	if _, ok := o.seq[id]; !ok {
+		o.seq[id] = o.next
+		o.next++
+	}
+}
+
+// reset drops the numbers along with the records.
+func (o *recordOrder) reset() {
+	o.seq = nil
+	o.next = 0
+}
+
+// orderedIDs returns the UUIDs of the records in file order. Records added directly to the Records map have no number
+// and follow the others sorted by UUID.
+func (db *V3) orderedIDs() [][16]byte {
+	ids := make([][16]byte, 0, len(db.Records))
+	for id := range db.Records {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b [16]byte) int {
+		seqA, okA := db.order.seq[a]
+		seqB, okB := db.order.seq[b]
+		switch {
+		case okA && okB:
+			return cmp.Compare(seqA, seqB)
+		case okA:
+			return -1
+		case okB:
+			return 1
+		}
+		return slices.Compare(a[:], b[:])
+	})
+	return ids
+}
+
+// move gives a record re-keyed under a new UUID the position of its old UUID.
+func (o *recordOrder) move(from, to [16]byte) {
+	if seq, ok := o.seq[from]; ok {
+		o.seq[to] = seq
+	}
+}
@@ -0,0 +1,93 @@
+package pwsafe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func titles(records []Record) []string {
+	var result []string
+	for _, record := range records {
+		result = append(result, record.Title)
+	}
+	return result
+}
+
+// reopen saves the db and reads it back
+func reopen(t *testing.T, db *V3) *V3 {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, db.Encrypt(&buf))
+	var reopened V3
+	_, err := reopened.Decrypt(bytes.NewReader(buf.Bytes()), "password")
+	require.NoError(t, err)
+	return &reopened
+}
+
+func TestRecordOrderStableAcrossSaves(t *testing.T) {
+	db := NewV3("test", "password")
+	var want []string
+	for _, title := range []string{"m", "b", "z", "a", "q", "c", "y", "d"} {
+		db.SetRecord(Record{Title: title, Password: "pw"})
+		want = append(want, title)
+	}
+	assert.Equal(t, want, titles(db.Snapshot()), "records are kept in the order added")
+
+	for range 5 {
+		db = reopen(t, db)
+		assert.Equal(t, want, titles(db.Snapshot()), "records are written in the order they were read")
+	}
+
+	// New records go at the end, updates keep their place
+	db.SetRecord(Record{Title: "new", Password: "pw"})
+	record, ok := db.RecordByTitle("b")
+	require.True(t, ok)
+	record.Password = "changed"
+	db.SetRecord(record)
+	want = append(want, "new")
+	db = reopen(t, db)
+	assert.Equal(t, want, titles(db.Snapshot()))
+}
+
+func TestRecordOrderDeleteAndUndo(t *testing.T) {
+	db := NewV3("test", "password")
+	for _, title := range []string{"one", "two", "three"} {
+		db.SetRecord(Record{Title: title, Password: "pw"})
+	}
+	record, _ := db.RecordByTitle("two")
+	db.DeleteRecord(record.UUID)
+	assert.Equal(t, []string{"one", "three"}, titles(db.Snapshot()))
+
+	assert.True(t, db.Undo())
+	assert.Equal(t, []string{"one", "two", "three"}, titles(db.Snapshot()), "undo restores the record's position")
+	assert.Equal(t, []string{"one", "two", "three"}, titles(reopen(t, db).Snapshot()))
+}
+
+func TestRecordOrderDirectMapAdditions(t *testing.T) {
+	db := NewV3("test", "password")
+	db.SetRecord(Record{Title: "first", Password: "pw"})
+	db.Records[[16]byte{2}] = Record{UUID: [16]byte{2}, Title: "direct 2", Password: "pw"}
+	db.Records[[16]byte{1}] = Record{UUID: [16]byte{1}, Title: "direct 1", Password: "pw"}
+	want := []string{"first", "direct 1", "direct 2"}
+	assert.Equal(t, want, titles(db.Snapshot()), "records without a position follow the others by UUID")
+
+	// Once written they keep their position
+	var buf bytes.Buffer
+	require.NoError(t, db.Encrypt(&buf))
+	db.SetRecord(Record{UUID: [16]byte{0}, Title: "last", Password: "pw"})
+	want = append(want, "last")
+	assert.Equal(t, want, titles(db.Snapshot()))
+	assert.Equal(t, want, titles(reopen(t, db).Snapshot()))
+}
+
+func TestRecordOrderLockUnlock(t *testing.T) {
+	db, err := OpenPWSafeFile("./test_dbs/three.dat", "three3#;")
+	require.NoError(t, err)
+	want := titles(db.Snapshot())
+	require.NoError(t, db.Lock())
+	require.NoError(t, db.Unlock("three3#;"))
+	assert.Equal(t, want, titles(db.Snapshot()))
+}
@@ -0,0 +1,201 @@
+package query
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// Result is a record found by a fuzzy search with its relevance, higher scores are better matches.
+type Result struct {
+	Record pwsafe.Record
+	Score  float64
+}
+
+// Scores for a fuzzy match, each matched character scores scoreMatch plus the bonus for its position, less a penalty
+// for the characters skipped since the previous match.
+const (
+	scoreMatch       = 16
+	bonusBoundary    = 10   // the start of a word
+	bonusConsecutive = 8    // directly following the previous matched character
+	penaltyGap       = 1    // per character skipped between matches, down to a score of 0
+	fuzzyMaxLen      = 1024 // longer field values are only matched up to this length
+)
+
+// fuzzyField is a record field matched by fuzzy search and the weight applied to its score.
+type fuzzyField struct {
+	value  func(r *pwsafe.Record) string
+	weight float64
+	name   bool // matched when only names are searched
+}
+
+var fuzzyFields = []fuzzyField{
+	{textFields["title"], 3, true},
+	{textFields["group"], 1.5, true},
+	{textFields["user"], 2, false},
+	{textFields["url"], 2, false},
+	{textFields["email"], 1.5, false},
+	{textFields["notes"], 1, false},
+}
+
+// recentHalfLife is the time for the boost given to a recently accessed record to halve, a record accessed now scores
+// up to recentBoost times higher.
+const (
+	recentHalfLife = 30 * 24 * time.Hour
+	recentBoost    = 0.5
+)
+
+// Fuzzy ranks the records matching every whitespace separated term of input as a subsequence, ignoring case, of any
+// of the title, group, username, URL, email or notes, or only the title and group when namesOnly is set. Matches at the
+// start of words and runs of consecutive characters score higher so "gh" ranks GitHub above a title merely containing
+// a g and an h, and title matches are weighted above the other fields with notes lowest. Records accessed recently,
+// by AccessTime, are boosted. The results are sorted by score then title, an empty input returns every record.
+func Fuzzy(records []pwsafe.Record, input string, namesOnly bool) []Result {
+	return fuzzyAt(records, input, namesOnly, time.Now())
+}
+
+// FuzzySearch is Fuzzy for the records in the db.
+func FuzzySearch(db *pwsafe.V3, input string, namesOnly bool) []Result {
+	return Fuzzy(db.Snapshot(), input, namesOnly)
+}
+
+func fuzzyAt(records []pwsafe.Record, input string, namesOnly bool, now time.Time) []Result {
+	var terms [][]rune
+	for _, term := range strings.Fields(strings.ToLower(input)) {
+		terms = append(terms, []rune(term))
+	}
+
+	var results []Result
+	var s scorer
+	for i := range records {
+		r := &records[i]
+		total := 0.0
+		for _, term := range terms {
+			best := 0.0
+			for _, f := range fuzzyFields {
+				if namesOnly && !f.name {
+					continue
+				}
+				if score := s.score(term, f.value(r)) * f.weight; score > best {
+					best = score
+				}
+			}
+			if best == 0 {
+				total = -1
+				break
+			}
+			total += best
+		}
+		if total < 0 {
+			continue
+		}
+		if !r.AccessTime.IsZero() && len(terms) > 0 {
+			age := max(now.Sub(r.AccessTime), 0)
+			total *= 1 + recentBoost*math.Exp2(-float64(age)/float64(recentHalfLife))
+		}
+		results = append(results, Result{Record: *r, Score: total})
+	}
+
+	slices.SortStableFunc(results, func(a, b Result) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(strings.ToLower(a.Record.Title), strings.ToLower(b.Record.Title)); c != 0 {
+			return c
+		}
+		return slices.Compare(a.Record.UUID[:], b.Record.UUID[:])
+	})
+	return results
+}
+
+// scorer finds the best scoring alignment of a term as a subsequence of a text, reusing its buffers between calls.
+type scorer struct {
+	text, lower []rune
+	bonus       []int
+	prev, cur   []int
+}
+
+// score returns the best score for the lower case term as a subsequence of text or 0 if it doesn't match.
+func (s *scorer) score(term []rune, text string) float64 {
+	if text == "" || len(term) == 0 {
+		return 0
+	}
+	s.text = append(s.text[:0], []rune(text)...)
+	if len(s.text) > fuzzyMaxLen {
+		s.text = s.text[:fuzzyMaxLen]
+	}
+	n := len(s.text)
+	if n < len(term) {
+		return 0
+	}
+	s.lower = s.lower[:0]
+	s.bonus = s.bonus[:0]
+	for j, c := range s.text {
+		s.lower = append(s.lower, unicode.ToLower(c))
+		s.bonus = append(s.bonus, boundaryBonus(s.text, j))
+	}
+
+	// prev[j] and cur[j] are the best scores with the previous and current term character matched at j, or -1
+	s.prev = slices.Grow(s.prev[:0], n)[:n]
+	s.cur = slices.Grow(s.cur[:0], n)[:n]
+	for j := range n {
+		s.prev[j] = -1
+		if s.lower[j] == term[0] {
+			s.prev[j] = scoreMatch + s.bonus[j]
+		}
+	}
+	for i := 1; i < len(term); i++ {
+		gapBest := -1 // the best score of an earlier match less the gap penalty to j
+		for j := range n {
+			s.cur[j] = -1
+			if j > 0 && gapBest >= 0 {
+				gapBest = max(gapBest-penaltyGap, 0)
+			}
+			if s.lower[j] == term[i] && j > 0 {
+				best := gapBest
+				if s.prev[j-1] >= 0 {
+					best = max(best, s.prev[j-1]+bonusConsecutive)
+				}
+				if best >= 0 {
+					s.cur[j] = best + scoreMatch + s.bonus[j]
+				}
+			}
+			if j > 0 && s.prev[j-1] >= 0 {
+				gapBest = max(gapBest, s.prev[j-1])
+			}
+		}
+		s.prev, s.cur = s.cur, s.prev
+	}
+
+	best := slices.Max(s.prev)
+	if best < 0 {
+		return 0
+	}
+	// Normalize so long values with many chances to match don't beat short close matches
+	return float64(best) / math.Sqrt(float64(n)/float64(len(term)))
+}
+
+// boundaryBonus returns the bonus for a match at position j of text, the start of the text, a character after a
+// separator and an upper case letter after a lower case one start words.
+func boundaryBonus(text []rune, j int) int {
+	if j == 0 {
+		return bonusBoundary
+	}
+	prev, c := text[j-1], text[j]
+	switch {
+	case !unicode.IsLetter(prev) && !unicode.IsDigit(prev) && (unicode.IsLetter(c) || unicode.IsDigit(c)):
+		return bonusBoundary
+	case unicode.IsLower(prev) && unicode.IsUpper(c):
+		return bonusBoundary
+	case unicode.IsLetter(prev) && unicode.IsDigit(c):
+		return bonusBoundary / 2
+	}
+	return 0
+}
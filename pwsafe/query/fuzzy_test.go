@@ -0,0 +1,90 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+func resultTitles(results []Result) []string {
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Record.Title)
+	}
+	return got
+}
+
+func TestFuzzy(t *testing.T) {
+	records := []pwsafe.Record{
+		{Title: "Garage Hinges"},
+		{Title: "Laughing"},
+		{Title: "GitHub"},
+		{Title: "Shopping", Notes: "the github account for work"},
+		{Title: "Mail", Username: "gh-user"},
+	}
+
+	results := fuzzyAt(records, "gh", false, now)
+	assert.Equal(t, "GitHub", results[0].Record.Title)
+	assert.Equal(t, []string{"GitHub", "Laughing", "Garage Hinges", "Mail", "Shopping"}, resultTitles(results))
+	for i := 1; i < len(results); i++ {
+		assert.GreaterOrEqual(t, results[i-1].Score, results[i].Score)
+	}
+
+	// Title matches rank above notes matches, only names are searched when namesOnly is set
+	assert.Equal(t, []string{"GitHub", "Shopping"}, resultTitles(fuzzyAt(records, "github", false, now)))
+	assert.Equal(t, []string{"GitHub"}, resultTitles(fuzzyAt(records, "github", true, now)))
+
+	// Every term must match
+	assert.Equal(t, []string{"Shopping"}, resultTitles(fuzzyAt(records, "git work", false, now)))
+	assert.Empty(t, fuzzyAt(records, "zzz", false, now))
+	assert.Empty(t, fuzzyAt(records, "hubgit", true, now), "the characters must be in order")
+
+	// An empty input returns everything sorted by title
+	assert.Equal(t, []string{"Garage Hinges", "GitHub", "Laughing", "Mail", "Shopping"}, resultTitles(fuzzyAt(records, " ", false, now)))
+}
+
+func TestFuzzyAccessTimeBoost(t *testing.T) {
+	records := []pwsafe.Record{
+		{Title: "Bank one", AccessTime: now.AddDate(-1, 0, 0)},
+		{Title: "Bank two", AccessTime: now.Add(-time.Hour)},
+		{Title: "Bank six"},
+	}
+	assert.Equal(t, []string{"Bank two", "Bank one", "Bank six"}, resultTitles(fuzzyAt(records, "bank", false, now)))
+
+	// The boost fades with time
+	boosted := fuzzyAt(records[1:2], "bank", false, now)[0].Score
+	later := fuzzyAt(records[1:2], "bank", false, now.AddDate(0, 2, 0))[0].Score
+	plain := fuzzyAt(records[2:], "bank", false, now)[0].Score
+	assert.Greater(t, boosted, later)
+	assert.Greater(t, later, plain)
+}
+
+func TestScorer(t *testing.T) {
+	var s scorer
+	score := func(term, text string) float64 { return s.score([]rune(term), text) }
+
+	assert.Zero(t, score("abc", "ab"))
+	assert.Zero(t, score("ba", "ab"))
+	assert.Greater(t, score("ab", "ab"), 0.0)
+	assert.Greater(t, score("gh", "GitHub"), score("gh", "Laughing"), "word boundaries")
+	assert.Greater(t, score("git", "xgitxxx"), score("git", "xgxixtx"), "consecutive characters")
+	assert.Greater(t, score("mail", "Mail"), score("mail", "Mail for the whole family"), "shorter values")
+	assert.Greater(t, score("ü", "Über"), 0.0)
+}
+
+func BenchmarkFuzzy(b *testing.B) {
+	var records []pwsafe.Record
+	for i := 0; i < 1000; i++ {
+		records = append(records, pwsafe.Record{
+			Title: "Some Record Title", Group: "group.subgroup", Username: "username@example.com",
+			URL: "https://www.example.com/login", Notes: "These are some notes for the record",
+		})
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		Fuzzy(records, "exlog", false)
+	}
+}
@@ -0,0 +1,157 @@
+package query
+
+import (
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenText tokenKind = iota
+	tokenPhrase
+	tokenRegex
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+)
+
+// token is a lexed part of a query, field is set for qualified terms.
+type token struct {
+	kind  tokenKind
+	field string
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenAnd:
+		return "AND"
+	case tokenOr:
+		return "OR"
+	case tokenNot:
+		return "NOT"
+	case tokenLParen:
+		return `"("`
+	case tokenRParen:
+		return `")"`
+	}
+	return "term " + t.value
+}
+
+// lex splits the query into tokens.
+func lex(query string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, pos: i})
+			i++
+		case c == '|':
+			tokens = append(tokens, token{kind: tokenOr, pos: i})
+			i++
+		case c == '-' && i+1 < len(query) && !isSpace(query[i+1]):
+			tokens = append(tokens, token{kind: tokenNot, pos: i})
+			i++
+		default:
+			tok, next, err := lexTerm(query, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			i = next
+		}
+	}
+	return tokens, nil
+}
+
+// lexTerm lexes the term starting at start returning it and the position after it.
+func lexTerm(query string, start int) (token, int, error) {
+	tok := token{kind: tokenText, pos: start}
+	i := start
+	if name, _, ok := strings.Cut(query[i:], ":"); ok && isFieldName(name) {
+		tok.field = strings.ToLower(name)
+		i += len(name) + 1
+	}
+
+	if i < len(query) && (query[i] == '"' || query[i] == '/') {
+		delim := query[i]
+		value, next, ok := delimited(query, i+1, delim)
+		if !ok {
+			if delim == '"' {
+				return tok, 0, &SyntaxError{Pos: i, Msg: "unclosed quote"}
+			}
+			return tok, 0, &SyntaxError{Pos: i, Msg: "unclosed regular expression"}
+		}
+		tok.kind = tokenPhrase
+		if delim == '/' {
+			tok.kind = tokenRegex
+		}
+		tok.value = value
+		return tok, next, nil
+	}
+
+	end := i
+	for end < len(query) && !isSpace(query[end]) && query[end] != '(' && query[end] != ')' {
+		end++
+	}
+	tok.value = query[i:end]
+	if tok.field == "" {
+		switch tok.value {
+		case "AND":
+			tok.kind = tokenAnd
+		case "OR":
+			tok.kind = tokenOr
+		case "NOT":
+			tok.kind = tokenNot
+		}
+	} else if tok.value == "" {
+		return tok, 0, &SyntaxError{Pos: start, Msg: "missing value for " + tok.field}
+	}
+	return tok, end, nil
+}
+
+// delimited reads up to the closing delim, a backslash escapes the delimiter. Escapes are kept in regular
+// expressions other than for the delimiter itself.
+func delimited(query string, start int, delim byte) (string, int, bool) {
+	var value strings.Builder
+	for i := start; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if i+1 < len(query) && query[i+1] == delim {
+				value.WriteByte(delim)
+				i++
+				continue
+			}
+			if delim == '"' && i+1 < len(query) && query[i+1] == '\\' {
+				value.WriteByte('\\')
+				i++
+				continue
+			}
+		case delim:
+			return value.String(), i + 1, true
+		}
+		value.WriteByte(query[i])
+	}
+	return "", 0, false
+}
+
+func isFieldName(name string) bool {
+	name = strings.ToLower(name)
+	if _, ok := textFields[name]; ok {
+		return true
+	}
+	_, ok := dateFields[name]
+	return ok
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,438 @@
+// Package query implements a small query language for finding records in a Password Safe db.
+//
+// A query is a list of terms which must all match, terms are separated by spaces and can be combined with OR, AND and
+// NOT, or "-" before a term, and grouped with parentheses. The operators must be upper case, a lower case "or" is
+// searched for as text. Plain terms match the title, group, username, URL, email or notes ignoring case. A term can be
+// limited to a field with a qualifier, title:, group:, user:, url:, email: or notes:. Phrases with spaces are quoted,
+// "like this", and regular expressions are written between slashes, /like.*this/, both with or without a qualifier.
+//
+// The modified: and expires: qualifiers compare the ModTime and PasswordExpiry of a record with a date, 2006-01-02 or
+// RFC 3339, or an age in hours, days, weeks or years like 12h, 30d, 2w or 1y, prefixed with <, <=, > or >=.
+// For modified an age is how long ago the record was modified, modified:<30d matches records modified in the last 30
+// days, for expires it is how long until the password expires, expires:<7d matches passwords expiring within 7 days
+// including those already expired. A date without a comparison matches the whole day. Records without the time set
+// never match a date predicate.
+//
+// The password is never searched.
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// Query is a compiled query, it is safe for concurrent use.
+type Query struct {
+	root node
+	src  string
+}
+
+// SyntaxError describes a query which can't be compiled.
+type SyntaxError struct {
+	Pos int    // the byte offset in the query of the error
+	Msg string // a description of the error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("query syntax error at %d: %s", e.Pos, e.Msg)
+}
+
+// Compile parses the query, relative dates are resolved against the current time.
+func Compile(query string) (*Query, error) {
+	return compile(query, time.Now())
+}
+
+// MustCompile is like Compile but panics if the query can't be compiled.
+func MustCompile(query string) *Query {
+	q, err := Compile(query)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func compile(query string, now time.Time) (*Query, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{tokens: tokens, now: now, end: len(query)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, &SyntaxError{Pos: p.tokens[p.pos].pos, Msg: "unexpected " + p.tokens[p.pos].String()}
+	}
+	return &Query{root: root, src: query}, nil
+}
+
+// String returns the source of the query.
+func (q *Query) String() string {
+	return q.src
+}
+
+// Match returns true if the record matches the query, an empty query matches every record.
+func (q *Query) Match(record pwsafe.Record) bool {
+	if q.root == nil {
+		return true
+	}
+	return q.root.match(&record)
+}
+
+// Filter returns the records matching the query in the order given.
+func (q *Query) Filter(records []pwsafe.Record) []pwsafe.Record {
+	var matched []pwsafe.Record
+	for _, record := range records {
+		if q.Match(record) {
+			matched = append(matched, record)
+		}
+	}
+	return matched
+}
+
+// Search returns the records in the db matching the query sorted by group then title.
+func Search(db *pwsafe.V3, q *Query) []pwsafe.Record {
+	matched := q.Filter(db.Snapshot())
+	slices.SortFunc(matched, func(a, b pwsafe.Record) int {
+		if c := strings.Compare(a.Group, b.Group); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Title, b.Title); c != 0 {
+			return c
+		}
+		return slices.Compare(a.UUID[:], b.UUID[:])
+	})
+	return matched
+}
+
+// field selects the value of a record a term matches.
+type field func(r *pwsafe.Record) string
+
+var textFields = map[string]field{
+	"title":    func(r *pwsafe.Record) string { return r.Title },
+	"group":    func(r *pwsafe.Record) string { return r.Group },
+	"user":     func(r *pwsafe.Record) string { return r.Username },
+	"username": func(r *pwsafe.Record) string { return r.Username },
+	"url":      func(r *pwsafe.Record) string { return r.URL },
+	"email":    func(r *pwsafe.Record) string { return r.Email },
+	"notes":    func(r *pwsafe.Record) string { return r.Notes },
+}
+
+// defaultFields are matched by terms without a qualifier.
+var defaultFields = []field{
+	textFields["title"], textFields["group"], textFields["user"], textFields["url"], textFields["email"],
+	textFields["notes"],
+}
+
+var dateFields = map[string]func(r *pwsafe.Record) time.Time{
+	"modified": func(r *pwsafe.Record) time.Time { return r.ModTime },
+	"expires":  func(r *pwsafe.Record) time.Time { return r.PasswordExpiry },
+}
+
+type node interface {
+	match(r *pwsafe.Record) bool
+}
+
+type andNode []node
+
+func (n andNode) match(r *pwsafe.Record) bool {
+	for _, child := range n {
+		if !child.match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []node
+
+func (n orNode) match(r *pwsafe.Record) bool {
+	for _, child := range n {
+		if child.match(r) {
+			return true
+		}
+	}
+	return false
+}
+
+type notNode struct{ node }
+
+func (n notNode) match(r *pwsafe.Record) bool {
+	return !n.node.match(r)
+}
+
+// textNode matches a lower case substring of any of the fields.
+type textNode struct {
+	fields []field
+	text   string
+}
+
+func (n textNode) match(r *pwsafe.Record) bool {
+	for _, f := range n.fields {
+		if strings.Contains(strings.ToLower(f(r)), n.text) {
+			return true
+		}
+	}
+	return false
+}
+
+// regexNode matches a regular expression against any of the fields.
+type regexNode struct {
+	fields []field
+	re     *regexp.Regexp
+}
+
+func (n regexNode) match(r *pwsafe.Record) bool {
+	for _, f := range n.fields {
+		if n.re.MatchString(f(r)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dateNode matches a time field after from and before to, either may be zero for an open range.
+type dateNode struct {
+	field    func(r *pwsafe.Record) time.Time
+	from, to time.Time
+}
+
+func (n dateNode) match(r *pwsafe.Record) bool {
+	t := n.field(r)
+	if t.IsZero() {
+		return false
+	}
+	if !n.from.IsZero() && t.Before(n.from) {
+		return false
+	}
+	if !n.to.IsZero() && !t.Before(n.to) {
+		return false
+	}
+	return true
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	now    time.Time
+	end    int
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// parseOr parses terms separated by OR, returning nil for an empty query.
+func (p *parser) parseOr() (node, error) {
+	var terms orNode
+	for {
+		and, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		tok, ok := p.peek()
+		if !ok || tok.kind != tokenOr {
+			if terms == nil {
+				return and, nil
+			}
+			if and == nil {
+				return nil, &SyntaxError{Pos: p.end, Msg: "expected a term after OR"}
+			}
+			return append(terms, and), nil
+		}
+		if and == nil {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: "expected a term before OR"}
+		}
+		terms = append(terms, and)
+		p.pos++
+	}
+}
+
+// parseAnd parses terms separated by AND or only spaces, returning nil if there are none.
+func (p *parser) parseAnd() (node, error) {
+	var terms andNode
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind == tokenOr || tok.kind == tokenRParen {
+			break
+		}
+		if tok.kind == tokenAnd {
+			if len(terms) == 0 {
+				return nil, &SyntaxError{Pos: tok.pos, Msg: "expected a term before AND"}
+			}
+			p.pos++
+			if next, ok := p.peek(); !ok || next.kind == tokenOr || next.kind == tokenRParen || next.kind == tokenAnd {
+				return nil, &SyntaxError{Pos: tok.pos, Msg: "expected a term after AND"}
+			}
+			continue
+		}
+		term, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	switch len(terms) {
+	case 0:
+		return nil, nil
+	case 1:
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	tok, _ := p.peek()
+	switch tok.kind {
+	case tokenNot:
+		p.pos++
+		if next, ok := p.peek(); !ok || next.kind == tokenOr || next.kind == tokenAnd || next.kind == tokenRParen {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: "expected a term after NOT"}
+		}
+		term, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{term}, nil
+	case tokenLParen:
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, ok := p.peek()
+		if !ok || closing.kind != tokenRParen {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: "unclosed parenthesis"}
+		}
+		p.pos++
+		if inner == nil {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: "empty parentheses"}
+		}
+		return inner, nil
+	}
+	p.pos++
+	return p.term(tok)
+}
+
+// term builds the node matching a text, phrase or regex token.
+func (p *parser) term(tok token) (node, error) {
+	if date, ok := dateFields[tok.field]; ok {
+		if tok.kind != tokenText {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: tok.field + " needs a date or age"}
+		}
+		return p.dateTerm(tok, date)
+	}
+
+	fields := defaultFields
+	if tok.field != "" {
+		fields = []field{textFields[tok.field]}
+	}
+	if tok.kind == tokenRegex {
+		re, err := regexp.Compile("(?i)" + tok.value)
+		if err != nil {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: err.Error()}
+		}
+		return regexNode{fields: fields, re: re}, nil
+	}
+	return textNode{fields: fields, text: strings.ToLower(tok.value)}, nil
+}
+
+// dateTerm builds the node for a date predicate such as <30d or >=2024-01-01.
+func (p *parser) dateTerm(tok token, date func(r *pwsafe.Record) time.Time) (node, error) {
+	value := tok.value
+	op := ""
+	for _, prefix := range []string{"<=", ">=", "<", ">", "="} {
+		if rest, ok := strings.CutPrefix(value, prefix); ok {
+			op, value = prefix, rest
+			break
+		}
+	}
+
+	if days, hours, ok := parseAge(value); ok {
+		if op == "" || op == "=" {
+			return nil, &SyntaxError{Pos: tok.pos, Msg: "an age needs a comparison, for example <" + value}
+		}
+		// Convert the comparison of the age to one of the time
+		sign := 1
+		if tok.field == "modified" {
+			sign = -1
+			op = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<="}[op]
+		}
+		at := p.now.AddDate(0, 0, sign*days).Add(time.Duration(sign*hours) * time.Hour)
+		return compareNode(date, op, at, at), nil
+	}
+
+	from, to, err := parseDate(value, p.now.Location())
+	if err != nil {
+		return nil, &SyntaxError{Pos: tok.pos, Msg: err.Error()}
+	}
+	if op == "" || op == "=" {
+		return dateNode{field: date, from: from, to: to}, nil
+	}
+	return compareNode(date, op, from, to), nil
+}
+
+// compareNode returns the node for the comparison with a time span from start to end, for a single point in time
+// both are the same.
+func compareNode(date func(r *pwsafe.Record) time.Time, op string, start, end time.Time) node {
+	switch op {
+	case "<":
+		return dateNode{field: date, to: start}
+	case "<=":
+		if start.Equal(end) {
+			end = end.Add(time.Nanosecond)
+		}
+		return dateNode{field: date, to: end}
+	case ">":
+		if start.Equal(end) {
+			end = end.Add(time.Nanosecond)
+		}
+		return dateNode{field: date, from: end}
+	default: // >=
+		return dateNode{field: date, from: start}
+	}
+}
+
+// parseAge parses a number up to 65535 followed by h, d, w or y, returning it in days and hours. Ages are added to
+// dates with AddDate as hundreds of years don't fit in a time.Duration.
+func parseAge(s string) (int, int, bool) {
+	if len(s) < 2 {
+		return 0, 0, false
+	}
+	n, err := strconv.ParseUint(s[:len(s)-1], 10, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+	switch s[len(s)-1] {
+	case 'h':
+		return int(n) / 24, int(n) % 24, true
+	case 'd':
+		return int(n), 0, true
+	case 'w':
+		return 7 * int(n), 0, true
+	case 'y':
+		return 365 * int(n), 0, true
+	}
+	return 0, 0, false
+}
+
+// parseDate parses a day or RFC 3339 time returning the span of time it covers.
+func parseDate(s string, loc *time.Location) (time.Time, time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, loc); err == nil {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, t.Add(time.Second), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date or age %q", s)
+}
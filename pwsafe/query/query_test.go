@@ -0,0 +1,136 @@
+package query
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+var now = time.Date(2025, 6, 15, 12, 0, 0, 0, time.UTC)
+
+var records = []pwsafe.Record{
+	{Title: "GitHub", Group: "work.dev", Username: "octocat", URL: "https://github.com", Email: "cat@work.example",
+		ModTime: now.AddDate(0, 0, -3), PasswordExpiry: now.AddDate(0, 0, 5)},
+	{Title: "GitLab", Group: "work.dev", Username: "tanuki", URL: "https://gitlab.com", Notes: "old account",
+		ModTime: now.AddDate(-1, 0, 0), PasswordExpiry: now.AddDate(0, 0, -1)},
+	{Title: "Bank", Group: "personal", Username: "me", Password: "github", Notes: "the main savings account",
+		ModTime: time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)},
+	{Title: "Mail", Group: "personal", Username: "me@example.com", Email: "me@example.com"},
+}
+
+func titles(q *Query) []string {
+	var matched []string
+	for _, record := range q.Filter(records) {
+		matched = append(matched, record.Title)
+	}
+	return matched
+}
+
+func TestQuery(t *testing.T) {
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"GitHub", "GitLab", "Bank", "Mail"}},
+		{"git", []string{"GitHub", "GitLab"}},
+		{"GIT hub", []string{"GitHub"}},
+		{"git AND hub", []string{"GitHub"}},
+		{"hub OR lab", []string{"GitHub", "GitLab"}},
+		{"hub | bank", []string{"GitHub", "Bank"}},
+		{"git -lab", []string{"GitHub"}},
+		{"git NOT lab", []string{"GitHub"}},
+		{"NOT group:work", []string{"Bank", "Mail"}},
+		{"(hub OR lab) group:dev", []string{"GitHub", "GitLab"}},
+		{"group:personal (bank OR mail)", []string{"Bank", "Mail"}},
+		{"user:me", []string{"Bank", "Mail"}},
+		{"username:tanuki", []string{"GitLab"}},
+		{"url:gitlab.com", []string{"GitLab"}},
+		{"https://github.com", []string{"GitHub"}},
+		{"email:example", []string{"GitHub", "Mail"}},
+		{"title:git", []string{"GitHub", "GitLab"}},
+		{"notes:account", []string{"GitLab", "Bank"}},
+		{`"savings account"`, []string{"Bank"}},
+		{`notes:"old account"`, []string{"GitLab"}},
+		{`"account old"`, nil},
+		{"/^git(hub|lab)$/", []string{"GitHub", "GitLab"}},
+		{`title:/^b/`, []string{"Bank"}},
+		{`url:/\.com$/`, []string{"GitHub", "GitLab"}},
+		{"or", []string{"GitHub", "GitLab"}}, // a lower case or is text matching work
+		{"modified:<7d", []string{"GitHub"}},
+		{"modified:>30d", []string{"GitLab", "Bank"}},
+		{"modified:2024-01-02", []string{"Bank"}},
+		{"modified:<2024-01-02", nil},
+		{"modified:<=2024-01-02", []string{"Bank"}},
+		{"modified:>2024-01-02", []string{"GitHub", "GitLab"}},
+		{"modified:>=2024-06-15T12:00:00Z", []string{"GitHub", "GitLab"}},
+		{"expires:<7d", []string{"GitHub", "GitLab"}},
+		{"expires:<" + now.Format(time.RFC3339), []string{"GitLab"}},
+		{"expires:>=1d", []string{"GitHub"}},
+		{"NOT expires:<1y", []string{"Bank", "Mail"}},
+		{"modified:>300y", nil},
+		{"modified:<300y", []string{"GitHub", "GitLab", "Bank"}},
+		{"expires:<65535y", []string{"GitHub", "GitLab"}},
+		{"modified:<36h", nil},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			q, err := compile(c.query, now)
+			assert.NoError(t, err)
+			assert.Equal(t, c.want, titles(q))
+			assert.Equal(t, c.query, q.String())
+		})
+	}
+}
+
+func TestQueryNeverMatchesPassword(t *testing.T) {
+	q := MustCompile("github")
+	assert.False(t, q.Match(records[2]))
+}
+
+func TestQuerySyntaxErrors(t *testing.T) {
+	cases := map[string]int{
+		`"unclosed`:        0,
+		`title:/unclosed`:  6,
+		"(git":             0,
+		"git)":             3,
+		"()":               0,
+		"OR git":           0,
+		"git OR":           6,
+		"AND git":          0,
+		"git AND":          4,
+		"git NOT":          4,
+		"/[/":              0,
+		"user:":            0,
+		"modified:soon":    0,
+		"modified:7d":      0,
+		"expires:/2024/":   0,
+		"expires:=30d":     0,
+		"modified:2024-13": 0,
+		"modified:<65536y": 0,
+	}
+	for query, pos := range cases {
+		t.Run(query, func(t *testing.T) {
+			_, err := compile(query, now)
+			var syntaxErr *SyntaxError
+			if assert.True(t, errors.As(err, &syntaxErr), "error %v", err) {
+				assert.Equal(t, pos, syntaxErr.Pos)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db := pwsafe.NewV3("query", "password")
+	for _, record := range records {
+		db.SetRecord(record)
+	}
+	var got []string
+	for _, record := range Search(db, MustCompile("me OR git")) {
+		got = append(got, record.Group+"/"+record.Title)
+	}
+	assert.Equal(t, []string{"personal/Bank", "personal/Mail", "work.dev/GitHub", "work.dev/GitLab"}, got)
+}
@@ -1,13 +1,10 @@
 package pwsafe
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"time"
-
-	"golang.org/x/crypto/twofish"
 )
 
 const (
@@ -55,7 +52,7 @@ type Record struct {
 	PasswordExpiry         time.Time // 0x0a
 	PasswordExpiryInterval uint32    // 0x11
 	PasswordHistory        string    // 0x0f
-	PasswordModTime        string    // 0x08
+	PasswordModTime        time.Time // 0x08
 	PasswordPolicy         string    // 0x10
 	PasswordPolicyName     string    // 0x18
 	ProtectedEntry         byte      // 0x15
@@ -96,7 +93,7 @@ func (r Record) Equal(otherRecord Record, skipTimes bool) (bool, error) {
 	if r.PasswordHistory != otherRecord.PasswordHistory {
 		return false, fmt.Errorf("records don't match, PasswordHistory: %v != %v", r.PasswordHistory, otherRecord.PasswordHistory)
 	}
-	if r.PasswordModTime != otherRecord.PasswordModTime {
+	if !r.PasswordModTime.Equal(otherRecord.PasswordModTime) {
 		return false, fmt.Errorf("records don't match, PasswordModTime: %v != %v", r.PasswordModTime, otherRecord.PasswordModTime)
 	}
 	if r.PasswordPolicy != otherRecord.PasswordPolicy {
@@ -164,15 +161,15 @@ func (r *Record) setField(id byte, data []byte) error {
 	case recordPassword:
 		r.Password = string(data)
 	case recordCreateTime:
-		r.CreateTime = time.Unix(int64(binary.LittleEndian.Uint32(data)), 0)
+		return setTime(&r.CreateTime, data)
 	case recordPasswordModTime:
-		r.PasswordModTime = string(data)
+		return setTime(&r.PasswordModTime, data)
 	case recordAccessTime:
-		r.AccessTime = time.Unix(int64(binary.LittleEndian.Uint32(data)), 0)
+		return setTime(&r.AccessTime, data)
 	case recordPasswordExpiry:
-		r.PasswordExpiry = time.Unix(int64(binary.LittleEndian.Uint32(data)), 0)
+		return setTime(&r.PasswordExpiry, data)
 	case recordModTime:
-		r.ModTime = time.Unix(int64(binary.LittleEndian.Uint32(data)), 0)
+		return setTime(&r.ModTime, data)
 	case recordURL:
 		r.URL = string(data)
 	case recordAutotype:
@@ -220,76 +217,66 @@ func (r *Record) setField(id byte, data []byte) error {
 	return nil
 }
 
-// marshal returns the binary format for the record and the values used for hmac calculations
-func (r *Record) marshal() ([]byte, []byte, error) {
-	var recordBuf bytes.Buffer
-	var hmacBuf bytes.Buffer
-
-	// Helper to append a field
-	appendField := func(id byte, data any) {
-		size := binary.Size(data)
-		if size <= 0 {
-			return
-		}
-		// Write to HMAC buffer
-		binary.Write(&hmacBuf, binary.LittleEndian, data)
-
-		// Write length
-		binary.Write(&recordBuf, binary.LittleEndian, uint32(size))
-		// Write ID
-		recordBuf.WriteByte(id)
-		// Write Data
-		binary.Write(&recordBuf, binary.LittleEndian, data)
+// writeFields calls write with the type and value of each non empty field in order followed by the END field.
+func (r *Record) writeFields(write func(id byte, data []byte) error) error {
+	if r.PasswordExpiryInterval > PasswordExpiryIntervalMax {
+		return fmt.Errorf("PasswordExpiryInterval %d exceeds maximum of %d", r.PasswordExpiryInterval, PasswordExpiryIntervalMax)
+	}
 
-		// Write Padding
-		usedBlockSpace := (size + 5) % twofish.BlockSize
-		if usedBlockSpace != 0 {
-			recordBuf.Write(pseudoRandomBytes(twofish.BlockSize - usedBlockSpace))
+	var err error
+	appendField := func(id byte, data []byte) {
+		if err == nil && len(data) > 0 {
+			err = write(id, data)
 		}
 	}
+	// appendString wipes the temporary copy of the string value once it is written
+	appendString := func(id byte, value string) {
+		data := []byte(value)
+		appendField(id, data)
+		clear(data)
+	}
 
 	appendField(recordUUID, r.UUID[:])
-	appendField(recordGroup, []byte(r.Group))
-	appendField(recordTitle, []byte(r.Title))
-	appendField(recordUsername, []byte(r.Username))
-	appendField(recordNotes, []byte(r.Notes))
-	appendField(recordPassword, []byte(r.Password))
+	appendString(recordGroup, r.Group)
+	appendString(recordTitle, r.Title)
+	appendString(recordUsername, r.Username)
+	appendString(recordNotes, r.Notes)
+	appendString(recordPassword, r.Password)
 	if !r.CreateTime.IsZero() {
-		appendField(recordCreateTime, uint32(r.CreateTime.Unix()))
+		appendField(recordCreateTime, encodeTime(r.CreateTime))
+	}
+	if !r.PasswordModTime.IsZero() {
+		appendField(recordPasswordModTime, encodeTime(r.PasswordModTime))
 	}
-	appendField(recordPasswordModTime, []byte(r.PasswordModTime))
 	if !r.AccessTime.IsZero() {
-		appendField(recordAccessTime, uint32(r.AccessTime.Unix()))
+		appendField(recordAccessTime, encodeTime(r.AccessTime))
 	}
 	if !r.PasswordExpiry.IsZero() {
-		appendField(recordPasswordExpiry, uint32(r.PasswordExpiry.Unix()))
+		appendField(recordPasswordExpiry, encodeTime(r.PasswordExpiry))
 	}
 	if !r.ModTime.IsZero() {
-		appendField(recordModTime, uint32(r.ModTime.Unix()))
-	}
-	appendField(recordURL, []byte(r.URL))
-	appendField(recordAutotype, []byte(r.Autotype))
-	appendField(recordPasswordHistory, []byte(r.PasswordHistory))
-	appendField(recordPasswordPolicy, []byte(r.PasswordPolicy))
-	if r.PasswordExpiryInterval > 0 && r.PasswordExpiryInterval <= PasswordExpiryIntervalMax {
-		appendField(recordPasswordExpiryInterval, r.PasswordExpiryInterval)
-	} else if r.PasswordExpiryInterval > PasswordExpiryIntervalMax {
-		return nil, nil, fmt.Errorf("PasswordExpiryInterval %d exceeds maximum of %d", r.PasswordExpiryInterval, PasswordExpiryIntervalMax)
-	}
-	appendField(recordRunCommand, []byte(r.RunCommand))
+		appendField(recordModTime, encodeTime(r.ModTime))
+	}
+	appendString(recordURL, r.URL)
+	appendString(recordAutotype, r.Autotype)
+	appendString(recordPasswordHistory, r.PasswordHistory)
+	appendString(recordPasswordPolicy, r.PasswordPolicy)
+	if r.PasswordExpiryInterval > 0 {
+		appendField(recordPasswordExpiryInterval, binary.LittleEndian.AppendUint32(nil, r.PasswordExpiryInterval))
+	}
+	appendString(recordRunCommand, r.RunCommand)
 	appendField(recordDoubleClickAction, r.DoubleClickAction[:])
-	appendField(recordEmail, []byte(r.Email))
+	appendString(recordEmail, r.Email)
 	if r.ProtectedEntry != 0 {
 		appendField(recordProtectedEntry, []byte{r.ProtectedEntry})
 	}
-	appendField(recordOwnSymbolsForPassword, []byte(r.OwnSymbolsForPassword))
+	appendString(recordOwnSymbolsForPassword, r.OwnSymbolsForPassword)
 	appendField(recordShiftDoubleClickAction, r.ShiftDoubleClickAction[:])
-	appendField(recordPasswordPolicyName, []byte(r.PasswordPolicyName))
+	appendString(recordPasswordPolicyName, r.PasswordPolicyName)
+	if err != nil {
+		return err
+	}
 
 	// End of entry
-	recordBuf.Write([]byte{0, 0, 0, 0})
-	recordBuf.WriteByte(recordEndOfEntry)
-	recordBuf.Write(pseudoRandomBytes(twofish.BlockSize - 5))
-
-	return recordBuf.Bytes(), hmacBuf.Bytes(), nil
+	return write(recordEndOfEntry, nil)
 }
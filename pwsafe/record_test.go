@@ -18,10 +18,12 @@ func TestRecord_PasswordExpiryInterval(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, uint32(90), r.PasswordExpiryInterval)
 
-		// Marshal
-		marshaled, _, err := r.marshal()
+		// Write and read back
+		encrypted, _, err := encryptEntry(t, r.writeFields)
 		assert.NoError(t, err)
-		assert.Contains(t, string(marshaled), string(data), "Marshaled data should contain interval")
+		read := &Record{}
+		assert.NoError(t, decryptEntry(t, encrypted, read))
+		assert.Equal(t, uint32(90), read.PasswordExpiryInterval)
 	})
 
 	t.Run("Invalid Interval - Too Logical Large", func(t *testing.T) {
@@ -41,7 +43,7 @@ func TestRecord_PasswordExpiryInterval(t *testing.T) {
 		r.Password = "Test"
 		r.PasswordExpiryInterval = 5000 // Manually set invalid value
 
-		_, _, err := r.marshal()
+		_, _, err := encryptEntry(t, r.writeFields)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "exceeds maximum")
 	})
@@ -65,14 +67,12 @@ func TestRecord_OwnSymbolsForPassword(t *testing.T) {
 			OwnSymbolsForPassword: "!@#$%^&*()-_=+[]{}",
 		}
 
-		// Marshal the record
-		marshaled, _, err := r1.marshal()
+		// Write the record and read it back
+		encrypted, _, err := encryptEntry(t, r1.writeFields)
 		assert.NoError(t, err)
-		assert.NotNil(t, marshaled)
-
-		// Verify the field is in the marshaled data
-		// The marshaled data should contain our symbols
-		assert.Contains(t, string(marshaled), r1.OwnSymbolsForPassword)
+		r2 := &Record{}
+		assert.NoError(t, decryptEntry(t, encrypted, r2))
+		assert.Equal(t, r1.OwnSymbolsForPassword, r2.OwnSymbolsForPassword)
 	})
 
 	t.Run("Empty OwnSymbolsForPassword", func(t *testing.T) {
@@ -83,10 +83,10 @@ func TestRecord_OwnSymbolsForPassword(t *testing.T) {
 			OwnSymbolsForPassword: "",
 		}
 
-		// Marshal should work with empty string
-		marshaled, _, err := r.marshal()
+		// Writing should work with empty string
+		encrypted, _, err := encryptEntry(t, r.writeFields)
 		assert.NoError(t, err)
-		assert.NotNil(t, marshaled)
+		assert.NotNil(t, encrypted)
 	})
 
 	t.Run("Record Equality with OwnSymbolsForPassword", func(t *testing.T) {
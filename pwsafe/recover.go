@@ -0,0 +1,82 @@
+package pwsafe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pborman/uuid"
+	"golang.org/x/crypto/twofish"
+)
+
+// DecryptOptions changes how DecryptWithOptions reads a db.
+type DecryptOptions struct {
+	// Recover reads as much as it can of a damaged db, such as one truncated or partly overwritten by a sync tool,
+	// rather than failing at the first problem. Fields which can't be parsed are skipped, the records read before the
+	// data ends are kept and an HMAC mismatch is accepted, each problem is returned as a warning. The records are
+	// fixed up so the db can be saved as a repaired copy: a record without a title or password gets a placeholder,
+	// see RecoveredPassword, and one sharing the UUID of an earlier record gets a new UUID. Only an entry with no
+	// fields which could be read is dropped. A wrong password or a file which isn't a Password Safe v3 db is still an
+	// error.
+	Recover bool
+}
+
+// RecoveredPassword is the password given to a record recovered without one, so it can be saved. The record's other
+// fields are kept and the user can set the password again.
+const RecoveredPassword = "password lost in recovery"
+
+// startRecovery reads the rest of the encrypted data into memory so the end is known before it is parsed, letting
+// readField spot a damaged field length rather than reading to the end of the file as part of the field.
+func (fr *fieldReader) startRecovery(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	fr.recover = true
+	fr.limit = len(data) - len(data)%twofish.BlockSize
+	for i := 0; i+twofish.BlockSize <= len(data); i += twofish.BlockSize {
+		if string(data[i:i+twofish.BlockSize]) == "PWS3-EOFPWS3-EOF" {
+			fr.limit = i
+			fr.storedHMAC = data[i+twofish.BlockSize : min(i+twofish.BlockSize+sha256.Size, len(data))]
+			break
+		}
+	}
+	fr.r = bytes.NewReader(data[:min(fr.limit+twofish.BlockSize, len(data))])
+	return nil
+}
+
+// warn adds a problem skipped while recovering, a *FieldError already names the entry it is in.
+func (fr *fieldReader) warn(err error) {
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) {
+		err = fmt.Errorf("%s: %w", entryName(fr.index), err)
+	}
+	fr.warnings = append(fr.warnings, err)
+}
+
+// salvage fixes up a record read while recovering so it can be saved, returning false if nothing could be read of it.
+func (fr *fieldReader) salvage(record *Record, index int, records map[[16]byte]Record) bool {
+	if empty, _ := record.Equal(Record{}, false); empty {
+		fr.warn(errors.New("dropped, none of its fields could be read"))
+		return false
+	}
+	if record.Title == "" {
+		record.Title = fmt.Sprintf("Recovered record %d", index)
+		fr.warn(fmt.Errorf("has no title, named it %q", record.Title))
+	}
+	if record.Password == "" {
+		record.Password = RecoveredPassword
+		fr.warn(fmt.Errorf("%q has no password, set it to %q", record.Title, RecoveredPassword))
+	}
+	if _, dup := records[record.UUID]; dup && record.UUID != [16]byte{} {
+		fr.warn(fmt.Errorf("%q has the UUID %x of an earlier record, given a new UUID", record.Title, record.UUID))
+		record.UUID = [16]byte(uuid.NewRandom().Array())
+	}
+	if record.PasswordExpiryInterval > PasswordExpiryIntervalMax {
+		fr.warn(fmt.Errorf("%q has an invalid PasswordExpiryInterval %d, cleared it", record.Title, record.PasswordExpiryInterval))
+		record.PasswordExpiryInterval = 0
+	}
+	return true
+}
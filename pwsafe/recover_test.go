@@ -0,0 +1,146 @@
+package pwsafe
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encryptedStart is the offset of the encrypted header and records in a V3 file.
+const encryptedStart = 4 + 32 + 4 + 32 + 64 + 16
+
+// damageableDB returns an encrypted db with the given number of records.
+func damageableDB(t *testing.T, count int) (*V3, []byte) {
+	t.Helper()
+	db := NewV3("damaged", "password")
+	for i := range count {
+		db.SetRecord(Record{
+			Title:    fmt.Sprintf("record %02d", i),
+			Username: fmt.Sprintf("user%d", i),
+			Password: fmt.Sprintf("password %d", i),
+			Notes:    strings.Repeat("notes ", 10),
+		})
+	}
+	var buf bytes.Buffer
+	require.NoError(t, db.Encrypt(&buf))
+	return db, buf.Bytes()
+}
+
+// recoverBytes decrypts data in recovery mode and checks the result can be saved and reopened normally.
+func recoverBytes(t *testing.T, data []byte) (*V3, []error) {
+	t.Helper()
+	var db V3
+	_, warnings, err := db.DecryptWithOptions(bytes.NewReader(data), "password", DecryptOptions{Recover: true})
+	require.NoError(t, err)
+
+	repaired := reopen(t, &db)
+	equal, err := db.Equal(repaired)
+	require.NoError(t, err)
+	assert.True(t, equal, "the repaired copy must open without recovery")
+	return &db, warnings
+}
+
+func TestRecoverBadHMAC(t *testing.T) {
+	db, warnings, err := OpenPWSafeFileWithOptions("./test_dbs/badHMAC.dat", "password", DecryptOptions{Recover: true})
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0].Error(), "HMAC does not match")
+	assert.Equal(t, 1, len(db.Records))
+
+	// Without recovery the db still fails to open
+	_, _, err = OpenPWSafeFileWithOptions("./test_dbs/badHMAC.dat", "password", DecryptOptions{})
+	assert.Error(t, err)
+}
+
+func TestRecoverUndamaged(t *testing.T) {
+	orig, data := damageableDB(t, 3)
+	db, warnings := recoverBytes(t, data)
+	assert.Empty(t, warnings)
+	assert.Equal(t, titles(orig.Snapshot()), titles(db.Snapshot()))
+}
+
+func TestRecoverTruncated(t *testing.T) {
+	orig, data := damageableDB(t, 10)
+	truncated := data[:len(data)/2]
+	var plain V3
+	_, err := plain.Decrypt(bytes.NewReader(truncated), "password")
+	assert.Error(t, err)
+
+	db, warnings := recoverBytes(t, truncated)
+	require.NotEmpty(t, db.Records)
+	assert.Less(t, len(db.Records), 10)
+	records := db.Snapshot()
+	for i, record := range records {
+		original, ok := orig.Record(record.UUID)
+		require.True(t, ok, "the record %q must keep its UUID", record.Title)
+		if i == len(records)-1 {
+			// The record the data ends in is kept with the fields read before the end
+			assert.Equal(t, RecoveredPassword, record.Password)
+			continue
+		}
+		equal, _ := original.Equal(record, true)
+		assert.True(t, equal, "the record %q must be intact", record.Title)
+	}
+	assert.Contains(t, fmt.Sprint(warnings), "the HMAC is missing, the file is truncated")
+}
+
+func TestRecoverCorruptedBlock(t *testing.T) {
+	orig, data := damageableDB(t, 20)
+	// Flip every bit of a block in the middle of the records, which garbles that block and the one after it
+	damaged := bytes.Clone(data)
+	blocks := (len(data) - encryptedStart - 48) / 16
+	start := encryptedStart + blocks/2*16
+	for i := start; i < start+16; i++ {
+		damaged[i] ^= 0xff
+	}
+	var plain V3
+	_, err := plain.Decrypt(bytes.NewReader(damaged), "password")
+	assert.Error(t, err)
+
+	db, warnings := recoverBytes(t, damaged)
+	assert.NotEmpty(t, warnings)
+	assert.Contains(t, fmt.Sprint(warnings), "HMAC does not match")
+	intact := 0
+	for _, record := range orig.Snapshot() {
+		if recovered, ok := db.Record(record.UUID); ok {
+			if equal, _ := record.Equal(recovered, true); equal {
+				intact++
+			}
+		}
+	}
+	assert.GreaterOrEqual(t, intact, 18, "only the records in the damaged blocks may be lost")
+}
+
+func TestSalvage(t *testing.T) {
+	records := map[[16]byte]Record{{1}: {UUID: [16]byte{1}, Title: "first"}}
+	fr := &fieldReader{index: 3}
+
+	assert.False(t, fr.salvage(&Record{}, 3, records))
+
+	noPassword := Record{Title: "no password", Notes: "kept"}
+	assert.True(t, fr.salvage(&noPassword, 3, records))
+	assert.Equal(t, RecoveredPassword, noPassword.Password)
+	assert.Equal(t, "kept", noPassword.Notes)
+
+	untitled := Record{Password: "pw"}
+	assert.True(t, fr.salvage(&untitled, 3, records))
+	assert.Equal(t, "Recovered record 3", untitled.Title)
+
+	onlyUUID := Record{UUID: [16]byte{2}}
+	assert.True(t, fr.salvage(&onlyUUID, 4, records))
+	assert.Equal(t, "Recovered record 4", onlyUUID.Title)
+	assert.Equal(t, RecoveredPassword, onlyUUID.Password)
+
+	dup := Record{UUID: [16]byte{1}, Title: "dup", Password: "pw", PasswordExpiryInterval: PasswordExpiryIntervalMax + 1}
+	assert.True(t, fr.salvage(&dup, 3, records))
+	assert.NotEqual(t, [16]byte{1}, dup.UUID)
+	assert.Zero(t, dup.PasswordExpiryInterval)
+
+	assert.Len(t, fr.warnings, 7)
+	assert.Contains(t, fr.warnings[0].Error(), "record 3: dropped, none of its fields could be read")
+	assert.Contains(t, fr.warnings[1].Error(), `record 3: "no password" has no password`)
+}
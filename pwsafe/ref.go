@@ -0,0 +1,151 @@
+package pwsafe
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// RefScheme starts a reference to a record field. A reference names the record either by its UUID or by its group and
+// title, followed by the field:
+//
+//	pws://<uuid>/<field>
+//	pws://<group>/<title>/<field>
+//
+// The UUID is 32 hex digits, dashes are allowed as in 01234567-89ab-cdef-0123-456789abcdef. The group is the dot
+// separated group of the record such as Work.DB and is empty for records without one, as in pws:///title/password.
+// A / or % in a group or title is percent encoded. The fields are listed in RefFields.
+const RefScheme = "pws://"
+
+// RefFields are the field names a reference can end with, they are matched without case.
+var RefFields = []string{"autotype", "email", "group", "notes", "password", "runcommand", "title", "url", "username", "uuid"}
+
+var refFieldValues = map[string]func(r Record) string{
+	"autotype":   func(r Record) string { return r.Autotype },
+	"email":      func(r Record) string { return r.Email },
+	"group":      func(r Record) string { return r.Group },
+	"notes":      func(r Record) string { return r.Notes },
+	"password":   func(r Record) string { return r.Password },
+	"runcommand": func(r Record) string { return r.RunCommand },
+	"title":      func(r Record) string { return r.Title },
+	"url":        func(r Record) string { return r.URL },
+	"username":   func(r Record) string { return r.Username },
+	"uuid":       func(r Record) string { return hex.EncodeToString(r.UUID[:]) },
+}
+
+// ErrRecordNotFound is returned when no record matches a reference.
+var ErrRecordNotFound = errors.New("no record matches the reference")
+
+// AmbiguousRecordError is returned when more than one record has the group and title looked up by RecordByPath or a
+// reference.
+type AmbiguousRecordError struct {
+	Group, Title string
+	UUIDs        [][16]byte // the matching records sorted by UUID
+}
+
+func (e *AmbiguousRecordError) Error() string {
+	ids := make([]string, len(e.UUIDs))
+	for i, id := range e.UUIDs {
+		ids[i] = hex.EncodeToString(id[:])
+	}
+	return fmt.Sprintf("%d records have the title %q in group %q, refer to one by UUID: %s",
+		len(e.UUIDs), e.Title, e.Group, strings.Join(ids, ", "))
+}
+
+// MissingFieldError is returned when the field named by a reference isn't set on the record.
+type MissingFieldError struct {
+	UUID  [16]byte
+	Field string
+}
+
+func (e *MissingFieldError) Error() string {
+	return fmt.Sprintf("record %x has no %s", e.UUID, e.Field)
+}
+
+// Ref is a parsed reference to a record field, see RefScheme for the syntax. The record is named by UUID when the
+// title is empty.
+type Ref struct {
+	UUID         [16]byte
+	Group, Title string
+	Field        string // the lower case field name
+}
+
+// ParseRef parses a reference to a record field.
+func ParseRef(ref string) (Ref, error) {
+	path, ok := strings.CutPrefix(ref, RefScheme)
+	if !ok {
+		return Ref{}, fmt.Errorf("reference %q doesn't start with %s", ref, RefScheme)
+	}
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return Ref{}, fmt.Errorf("reference %q: %w", ref, err)
+		}
+		parts[i] = unescaped
+	}
+
+	var r Ref
+	switch len(parts) {
+	case 2:
+		id, err := hex.DecodeString(strings.ReplaceAll(parts[0], "-", ""))
+		if err != nil || len(id) != 16 {
+			return Ref{}, fmt.Errorf("reference %q: %q is not a UUID, use %s<group>/<title>/<field> for a title", ref, parts[0], RefScheme)
+		}
+		r.UUID = [16]byte(id)
+	case 3:
+		if parts[1] == "" {
+			return Ref{}, fmt.Errorf("reference %q has no title", ref)
+		}
+		r.Group, r.Title = parts[0], parts[1]
+	default:
+		return Ref{}, fmt.Errorf("reference %q isn't %s<uuid>/<field> or %s<group>/<title>/<field>", ref, RefScheme, RefScheme)
+	}
+	r.Field = strings.ToLower(parts[len(parts)-1])
+	if _, ok := refFieldValues[r.Field]; !ok {
+		return Ref{}, fmt.Errorf("reference %q: unknown field %q, expected one of %s", ref, r.Field, strings.Join(RefFields, ", "))
+	}
+	return r, nil
+}
+
+// String returns the reference in its canonical form.
+func (r Ref) String() string {
+	if r.Title == "" {
+		return RefScheme + hex.EncodeToString(r.UUID[:]) + "/" + r.Field
+	}
+	return RefScheme + url.PathEscape(r.Group) + "/" + url.PathEscape(r.Title) + "/" + r.Field
+}
+
+// Resolve returns the value of the field named by a reference, see RefScheme for the syntax. It returns
+// ErrRecordNotFound when no record matches, an *AmbiguousRecordError when several records have the group and title and a
+// *MissingFieldError when the field is empty.
+func (db *V3) Resolve(ref string) (string, error) {
+	r, err := ParseRef(ref)
+	if err != nil {
+		return "", err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var record Record
+	if r.Title == "" {
+		var ok bool
+		if record, ok = db.Records[r.UUID]; !ok {
+			return "", fmt.Errorf("%s: %w", ref, ErrRecordNotFound)
+		}
+	} else {
+		if record, err = db.recordByPath(r.Group, r.Title); errors.Is(err, ErrRecordNotFound) {
+			return "", fmt.Errorf("%s: %w", ref, ErrRecordNotFound)
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	value := refFieldValues[r.Field](record)
+	if value == "" {
+		return "", &MissingFieldError{UUID: record.UUID, Field: r.Field}
+	}
+	return value, nil
+}
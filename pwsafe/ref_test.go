@@ -0,0 +1,103 @@
+package pwsafe
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRef(t *testing.T) {
+	id := [16]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+	tests := []struct {
+		ref       string
+		expected  Ref
+		canonical string
+	}{
+		{"pws://0123456789abcdef0123456789abcdef/password", Ref{UUID: id, Field: "password"}, ""},
+		{"pws://01234567-89AB-CDEF-0123-456789ABCDEF/UserName", Ref{UUID: id, Field: "username"}, "pws://0123456789abcdef0123456789abcdef/username"},
+		{"pws://Work.DB/prod/password", Ref{Group: "Work.DB", Title: "prod", Field: "password"}, ""},
+		{"pws:///top/url", Ref{Title: "top", Field: "url"}, ""},
+		{"pws://a%2Fb/c%25d%20e/notes", Ref{Group: "a/b", Title: "c%d e", Field: "notes"}, ""},
+	}
+	for _, test := range tests {
+		r, err := ParseRef(test.ref)
+		require.NoError(t, err, test.ref)
+		assert.Equal(t, test.expected, r, test.ref)
+		canonical := test.canonical
+		if canonical == "" {
+			canonical = test.ref
+		}
+		assert.Equal(t, canonical, r.String())
+		again, err := ParseRef(r.String())
+		require.NoError(t, err)
+		assert.Equal(t, r, again)
+	}
+
+	for ref, msg := range map[string]string{
+		"op://Work/prod/password":          "doesn't start with",
+		"pws://Work/password":              "is not a UUID",
+		"pws://0123/password":              "is not a UUID",
+		"pws://Work//password":             "has no title",
+		"pws://a/b/c/password":             "isn't",
+		"pws://password":                   "isn't",
+		"pws://Work/prod/pin":              "unknown field",
+		"pws://Work/pr%zzod/password":      "invalid URL escape",
+		"pws://0123456789abcdef0123456789": "isn't",
+	} {
+		_, err := ParseRef(ref)
+		require.Error(t, err, ref)
+		assert.Contains(t, err.Error(), msg, ref)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	db := NewV3("refs", "password")
+	prod := db.SetRecord(Record{Title: "prod", Group: "Work.DB", Username: "admin", Password: "prod-pass", URL: "db.example.com"})
+	db.SetRecord(Record{Title: "a/b", Group: "Work", Password: "slash-pass"})
+	db.SetRecord(Record{Title: "top", Password: "top-pass"})
+	dup1 := db.SetRecord(Record{Title: "dup", Group: "Work", Password: "one"})
+	dup2 := db.SetRecord(Record{Title: "dup", Group: "Work", Password: "two"})
+
+	for ref, expected := range map[string]string{
+		"pws://Work.DB/prod/password":          "prod-pass",
+		"pws://Work.DB/prod/username":          "admin",
+		"pws://Work.DB/prod/uuid":              fmt.Sprintf("%x", prod),
+		"pws://Work/a%2Fb/password":            "slash-pass",
+		"pws:///top/password":                  "top-pass",
+		fmt.Sprintf("pws://%x/password", dup1): "one",
+		fmt.Sprintf("pws://%x/PASSWORD", dup2): "two",
+		fmt.Sprintf("pws://%x/url", prod):      "db.example.com",
+		fmt.Sprintf("pws://%x/title", dup2):    "dup",
+		fmt.Sprintf("pws://%x/group", dup2):    "Work",
+	} {
+		value, err := db.Resolve(ref)
+		require.NoError(t, err, ref)
+		assert.Equal(t, expected, value, ref)
+	}
+
+	_, err := db.Resolve("pws://Work.DB/missing/password")
+	assert.ErrorIs(t, err, ErrRecordNotFound)
+	_, err = db.Resolve("pws://ffffffffffffffffffffffffffffffff/password")
+	assert.ErrorIs(t, err, ErrRecordNotFound)
+	_, err = db.Resolve("pws://Work/prod/password")
+	assert.ErrorIs(t, err, ErrRecordNotFound, "the group must match exactly")
+
+	_, err = db.Resolve("pws://Work/dup/password")
+	var ambiguous *AmbiguousRecordError
+	require.True(t, errors.As(err, &ambiguous))
+	assert.ElementsMatch(t, [][16]byte{dup1, dup2}, ambiguous.UUIDs)
+	assert.Contains(t, err.Error(), fmt.Sprintf("%x", dup1))
+	assert.Contains(t, err.Error(), "refer to one by UUID")
+
+	_, err = db.Resolve("pws://Work.DB/prod/email")
+	var missing *MissingFieldError
+	require.True(t, errors.As(err, &missing))
+	assert.Equal(t, prod, missing.UUID)
+	assert.Equal(t, "email", missing.Field)
+
+	_, err = db.Resolve("pws://Work.DB/prod/pin")
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+// Package secretservice exposes the records of a Password Safe v3 db as a collection of the freedesktop.org Secret
+// Service API, https://specifications.freedesktop.org/secret-service-spec/, so applications using libsecret or
+// another Secret Service client can keep their secrets in the db. It is only available on Linux.
+//
+// Every record is an item labelled with its title whose secret is its password. The attributes of an item are the
+// record's group, title, username and url, any other attributes an application sets are kept in a section at the end
+// of the record's notes starting with a [secret-service] line so they are saved with the db.
+package secretservice
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// notesMarker starts the section of the notes holding the attributes which aren't record fields.
+const notesMarker = "[secret-service]"
+
+// Attributes which are record fields.
+const (
+	AttrGroup    = "group"
+	AttrTitle    = "title"
+	AttrUsername = "username"
+	AttrURL      = "url"
+)
+
+// splitNotes separates the notes of a record from the attributes kept at their end.
+func splitNotes(notes string) (string, map[string]string) {
+	attrs := make(map[string]string)
+	var body, section string
+	switch {
+	case strings.HasPrefix(notes, notesMarker+"\n"):
+		section = notes[len(notesMarker)+1:]
+	case strings.Contains(notes, "\n"+notesMarker+"\n"):
+		i := strings.LastIndex(notes, "\n"+notesMarker+"\n")
+		body, section = notes[:i], notes[i+len(notesMarker)+2:]
+	default:
+		return notes, attrs
+	}
+	for _, line := range strings.Split(section, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSuffix(line, "\r"), "="); ok && key != "" {
+			attrs[key] = value
+		}
+	}
+	return body, attrs
+}
+
+// joinNotes appends the attributes to the notes body, the notes are unchanged when there are none.
+func joinNotes(body string, attrs map[string]string) string {
+	if len(attrs) == 0 {
+		return body
+	}
+	var b strings.Builder
+	if body != "" {
+		b.WriteString(body)
+		b.WriteString("\n")
+	}
+	b.WriteString(notesMarker)
+	for _, key := range slices.Sorted(maps.Keys(attrs)) {
+		b.WriteString("\n" + key + "=" + attrs[key])
+	}
+	return b.String()
+}
+
+// attributes returns the attributes of the item for record.
+func attributes(record pwsafe.Record) map[string]string {
+	_, attrs := splitNotes(record.Notes)
+	for key, value := range map[string]string{
+		AttrGroup:    record.Group,
+		AttrTitle:    record.Title,
+		AttrUsername: record.Username,
+		AttrURL:      record.URL,
+	} {
+		if value != "" {
+			attrs[key] = value
+		} else {
+			delete(attrs, key)
+		}
+	}
+	return attrs
+}
+
+// setAttributes replaces the attributes of record which aren't record fields, the fields are only changed for the
+// attributes given so an application can't clear the details of a record it doesn't know about.
+func setAttributes(record *pwsafe.Record, attrs map[string]string) error {
+	extra := make(map[string]string)
+	for key, value := range attrs {
+		if key == "" || strings.ContainsAny(key, "=\r\n") || strings.ContainsAny(value, "\r\n") {
+			return errors.New("attribute names can't be empty or contain '=' or line breaks and values can't contain line breaks")
+		}
+		switch key {
+		case AttrGroup, AttrTitle, AttrUsername, AttrURL:
+		default:
+			extra[key] = value
+		}
+	}
+	for key, field := range map[string]*string{
+		AttrGroup:    &record.Group,
+		AttrTitle:    &record.Title,
+		AttrUsername: &record.Username,
+		AttrURL:      &record.URL,
+	} {
+		if value, ok := attrs[key]; ok {
+			*field = value
+		}
+	}
+	body, _ := splitNotes(record.Notes)
+	record.Notes = joinNotes(body, extra)
+	return nil
+}
+
+// matches returns true if the item has every attribute in search with the same value.
+func matches(attrs, search map[string]string) bool {
+	for key, value := range search {
+		if v, ok := attrs[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
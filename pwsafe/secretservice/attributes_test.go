@@ -0,0 +1,68 @@
+package secretservice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+func TestNotesAttributes(t *testing.T) {
+	tests := []struct {
+		notes string
+		body  string
+		attrs map[string]string
+	}{
+		{"", "", map[string]string{}},
+		{"just notes\n[not the marker]", "just notes\n[not the marker]", map[string]string{}},
+		{"[secret-service]\napplication=chrome", "", map[string]string{"application": "chrome"}},
+		{"line one\r\nline two\n[secret-service]\nb=2=two\r\na=1\nignored", "line one\r\nline two", map[string]string{"a": "1", "b": "2=two"}},
+	}
+	for _, test := range tests {
+		body, attrs := splitNotes(test.notes)
+		assert.Equal(t, test.body, body, test.notes)
+		assert.Equal(t, test.attrs, attrs, test.notes)
+	}
+
+	assert.Equal(t, "body", joinNotes("body", nil))
+	assert.Equal(t, "[secret-service]\na=1", joinNotes("", map[string]string{"a": "1"}))
+	joined := joinNotes("body", map[string]string{"b": "2", "a": "1"})
+	assert.Equal(t, "body\n[secret-service]\na=1\nb=2", joined)
+	body, attrs := splitNotes(joined)
+	assert.Equal(t, "body", body)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, attrs)
+}
+
+func TestRecordAttributes(t *testing.T) {
+	record := pwsafe.Record{Title: "mail", Group: "web", URL: "https://mail.example.com", Notes: "my notes"}
+	assert.Equal(t, map[string]string{"title": "mail", "group": "web", "url": "https://mail.example.com"}, attributes(record))
+
+	require.NoError(t, setAttributes(&record, map[string]string{"username": "me", "xdg:schema": "org.example.Password", "title": "webmail"}))
+	assert.Equal(t, "webmail", record.Title)
+	assert.Equal(t, "web", record.Group, "fields without an attribute are kept")
+	assert.Equal(t, "me", record.Username)
+	assert.Equal(t, "my notes\n[secret-service]\nxdg:schema=org.example.Password", record.Notes)
+	attrs := attributes(record)
+	assert.Equal(t, map[string]string{
+		"title":      "webmail",
+		"group":      "web",
+		"username":   "me",
+		"url":        "https://mail.example.com",
+		"xdg:schema": "org.example.Password",
+	}, attrs)
+	assert.True(t, matches(attrs, map[string]string{"xdg:schema": "org.example.Password", "username": "me"}))
+	assert.True(t, matches(attrs, nil))
+	assert.False(t, matches(attrs, map[string]string{"username": "you"}))
+	assert.False(t, matches(attrs, map[string]string{"application": "chrome"}))
+
+	// Replacing the attributes drops the extra ones which are missing
+	require.NoError(t, setAttributes(&record, map[string]string{"application": "chrome"}))
+	assert.Equal(t, "my notes\n[secret-service]\napplication=chrome", record.Notes)
+	require.NoError(t, setAttributes(&record, map[string]string{}))
+	assert.Equal(t, "my notes", record.Notes)
+
+	assert.Error(t, setAttributes(&record, map[string]string{"a=b": "c"}))
+	assert.Error(t, setAttributes(&record, map[string]string{"a": "line\nbreak"}))
+}
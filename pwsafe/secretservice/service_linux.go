@@ -0,0 +1,556 @@
+package secretservice
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// BusName is the well known name of the Secret Service on the session bus.
+const BusName = "org.freedesktop.secrets"
+
+// DefaultGroup is the group items created by applications are added to when Options doesn't set one.
+const DefaultGroup = "Secret Service"
+
+const (
+	servicePath    = dbus.ObjectPath("/org/freedesktop/secrets")
+	collectionPath = dbus.ObjectPath("/org/freedesktop/secrets/collection/pwsafe")
+	aliasPath      = dbus.ObjectPath("/org/freedesktop/secrets/aliases/default")
+	sessionPrefix  = "/org/freedesktop/secrets/session/"
+	noPrompt       = dbus.ObjectPath("/") // returned when no prompt is needed
+
+	ifaceService    = "org.freedesktop.Secret.Service"
+	ifaceCollection = "org.freedesktop.Secret.Collection"
+	ifaceItem       = "org.freedesktop.Secret.Item"
+	ifaceSession    = "org.freedesktop.Secret.Session"
+	ifaceProperties = "org.freedesktop.DBus.Properties"
+
+	// algorithmPlain is the only supported session algorithm, secrets are sent unencrypted over the bus which is
+	// private to the user. Clients such as libsecret fall back to it when their preferred algorithm is refused.
+	algorithmPlain = "plain"
+)
+
+var (
+	errNoSession    = dbus.NewError("org.freedesktop.Secret.Error.NoSession", []any{"the session does not exist"})
+	errNoSuchObject = dbus.NewError("org.freedesktop.Secret.Error.NoSuchObject", []any{"no such item or collection"})
+)
+
+func unknownInterface(iface string) *dbus.Error {
+	err := dbus.MakeUnknownInterfaceError(iface)
+	return &err
+}
+
+func failed(err error) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.Failed", []any{err.Error()})
+}
+
+func invalidArgs(msg string) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []any{msg})
+}
+
+func notSupported(msg string) *dbus.Error {
+	return dbus.NewError("org.freedesktop.DBus.Error.NotSupported", []any{msg})
+}
+
+// secret is a secret as sent over the bus, the parameters are empty for the plain algorithm.
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// Options configure a Service.
+type Options struct {
+	// Group is the group items created by applications are added to, DefaultGroup when empty.
+	Group string
+	// Save is called after every change to the records, when nil changes are only made in memory.
+	Save func() error
+}
+
+// Service serves the records of a db as the single collection of the Secret Service on a D-Bus connection. The
+// collection is also the default alias. The db stays unlocked while it is served.
+type Service struct {
+	conn  *dbus.Conn
+	db    *pwsafe.V3
+	opts  Options
+	label string
+
+	mu          sync.Mutex // guards the sessions and serializes changes to the records
+	sessions    map[dbus.ObjectPath]string
+	nextSession int
+}
+
+// Export serves the db on conn and requests the Secret Service bus name, it fails if another Secret Service owns it.
+func Export(conn *dbus.Conn, db *pwsafe.V3, opts Options) (*Service, error) {
+	if opts.Group == "" {
+		opts.Group = DefaultGroup
+	}
+	s := &Service{conn: conn, db: db, opts: opts, label: db.Header.Name, sessions: make(map[dbus.ObjectPath]string)}
+	if s.label == "" {
+		s.label = "pwsafe"
+	}
+
+	// Every object is under the service path so each interface is exported once for the subtree and the methods
+	// check the kind of object they are called on.
+	for iface, v := range map[string]any{
+		ifaceService:    serviceAPI{s},
+		ifaceCollection: collectionAPI{s},
+		ifaceItem:       itemAPI{s},
+		ifaceSession:    sessionAPI{s},
+		ifaceProperties: propertiesAPI{s},
+	} {
+		if err := conn.ExportSubtree(v, servicePath, iface); err != nil {
+			s.unexport()
+			return nil, err
+		}
+	}
+	reply, err := conn.RequestName(BusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		s.unexport()
+		return nil, err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		s.unexport()
+		return nil, errors.New("another Secret Service owns " + BusName)
+	}
+	return s, nil
+}
+
+// Close releases the bus name and stops serving the db.
+func (s *Service) Close() error {
+	_, err := s.conn.ReleaseName(BusName)
+	s.unexport()
+	return err
+}
+
+func (s *Service) unexport() {
+	for _, iface := range []string{ifaceService, ifaceCollection, ifaceItem, ifaceSession, ifaceProperties} {
+		s.conn.ExportSubtree(nil, servicePath, iface)
+	}
+}
+
+func messagePath(msg dbus.Message) dbus.ObjectPath {
+	path, _ := msg.Headers[dbus.FieldPath].Value().(dbus.ObjectPath)
+	return path
+}
+
+func itemPath(id [16]byte) dbus.ObjectPath {
+	return collectionPath + "/" + dbus.ObjectPath(hex.EncodeToString(id[:]))
+}
+
+// isCollection returns true for the paths of the collection.
+func isCollection(path dbus.ObjectPath) bool {
+	return path == collectionPath || path == aliasPath
+}
+
+// record returns the record for an item path.
+func (s *Service) record(path dbus.ObjectPath) (pwsafe.Record, *dbus.Error) {
+	for _, prefix := range []dbus.ObjectPath{collectionPath, aliasPath} {
+		if rest, ok := strings.CutPrefix(string(path), string(prefix)+"/"); ok {
+			raw, err := hex.DecodeString(rest)
+			if err != nil || len(raw) != 16 {
+				break
+			}
+			if record, ok := s.db.Record([16]byte(raw)); ok {
+				return record, nil
+			}
+		}
+	}
+	return pwsafe.Record{}, errNoSuchObject
+}
+
+// checkSession returns an error unless the session was opened by sender.
+func (s *Service) checkSession(sender dbus.Sender, session dbus.ObjectPath) *dbus.Error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if owner, ok := s.sessions[session]; !ok || owner != string(sender) {
+		return errNoSession
+	}
+	return nil
+}
+
+// search returns the paths of the items with all the attributes.
+func (s *Service) search(attrs map[string]string) []dbus.ObjectPath {
+	paths := []dbus.ObjectPath{}
+	for _, record := range s.db.Snapshot() {
+		if matches(attributes(record), attrs) {
+			paths = append(paths, itemPath(record.UUID))
+		}
+	}
+	return paths
+}
+
+// secretFor returns the record's password as a secret for the session.
+func secretFor(record pwsafe.Record, session dbus.ObjectPath) secret {
+	return secret{Session: session, Parameters: []byte{}, Value: []byte(record.Password), ContentType: "text/plain"}
+}
+
+// update applies change to the record at path then saves the db, s.mu must be held.
+func (s *Service) update(path dbus.ObjectPath, change func(*pwsafe.Record) *dbus.Error) *dbus.Error {
+	record, dbusErr := s.record(path)
+	if dbusErr != nil {
+		return dbusErr
+	}
+	if dbusErr := change(&record); dbusErr != nil {
+		return dbusErr
+	}
+	s.db.SetRecord(record)
+	if dbusErr := s.save(); dbusErr != nil {
+		return dbusErr
+	}
+	s.conn.Emit(collectionPath, ifaceCollection+".ItemChanged", itemPath(record.UUID))
+	return nil
+}
+
+// save saves the db with Options.Save.
+func (s *Service) save() *dbus.Error {
+	if s.opts.Save == nil {
+		return nil
+	}
+	if err := s.opts.Save(); err != nil {
+		return failed(err)
+	}
+	return nil
+}
+
+// serviceAPI implements org.freedesktop.Secret.Service.
+type serviceAPI struct{ s *Service }
+
+func (api serviceAPI) check(msg dbus.Message) *dbus.Error {
+	if messagePath(msg) != servicePath {
+		return unknownInterface(ifaceService)
+	}
+	return nil
+}
+
+func (api serviceAPI) OpenSession(msg dbus.Message, sender dbus.Sender, algorithm string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if err := api.check(msg); err != nil {
+		return dbus.Variant{}, "", err
+	}
+	if algorithm != algorithmPlain {
+		return dbus.Variant{}, "", notSupported("only the plain algorithm is supported")
+	}
+	s := api.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextSession++
+	path := dbus.ObjectPath(sessionPrefix + "s" + strconv.Itoa(s.nextSession))
+	s.sessions[path] = string(sender)
+	return dbus.MakeVariant(""), path, nil
+}
+
+func (api serviceAPI) CreateCollection(msg dbus.Message, properties map[string]dbus.Variant, alias string) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	if err := api.check(msg); err != nil {
+		return "", "", err
+	}
+	if alias == "default" {
+		return collectionPath, noPrompt, nil
+	}
+	return "", "", notSupported("the db is the only collection")
+}
+
+func (api serviceAPI) SearchItems(msg dbus.Message, attrs map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	if err := api.check(msg); err != nil {
+		return nil, nil, err
+	}
+	return api.s.search(attrs), []dbus.ObjectPath{}, nil
+}
+
+// Unlock returns the objects unchanged, they are never locked.
+func (api serviceAPI) Unlock(msg dbus.Message, objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	if err := api.check(msg); err != nil {
+		return nil, "", err
+	}
+	return objects, noPrompt, nil
+}
+
+// Lock locks nothing, the db stays unlocked while it is served.
+func (api serviceAPI) Lock(msg dbus.Message, objects []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	if err := api.check(msg); err != nil {
+		return nil, "", err
+	}
+	return []dbus.ObjectPath{}, noPrompt, nil
+}
+
+func (api serviceAPI) GetSecrets(msg dbus.Message, sender dbus.Sender, items []dbus.ObjectPath, session dbus.ObjectPath) (map[dbus.ObjectPath]secret, *dbus.Error) {
+	if err := api.check(msg); err != nil {
+		return nil, err
+	}
+	if err := api.s.checkSession(sender, session); err != nil {
+		return nil, err
+	}
+	secrets := make(map[dbus.ObjectPath]secret)
+	for _, path := range items {
+		// Unknown items are left out as the spec allows
+		if record, err := api.s.record(path); err == nil {
+			secrets[path] = secretFor(record, session)
+		}
+	}
+	return secrets, nil
+}
+
+func (api serviceAPI) ReadAlias(msg dbus.Message, name string) (dbus.ObjectPath, *dbus.Error) {
+	if err := api.check(msg); err != nil {
+		return "", err
+	}
+	if name == "default" {
+		return collectionPath, nil
+	}
+	return noPrompt, nil
+}
+
+func (api serviceAPI) SetAlias(msg dbus.Message, name string, collection dbus.ObjectPath) *dbus.Error {
+	if err := api.check(msg); err != nil {
+		return err
+	}
+	return notSupported("aliases can't be changed")
+}
+
+// collectionAPI implements org.freedesktop.Secret.Collection.
+type collectionAPI struct{ s *Service }
+
+func (api collectionAPI) check(msg dbus.Message) *dbus.Error {
+	if !isCollection(messagePath(msg)) {
+		return unknownInterface(ifaceCollection)
+	}
+	return nil
+}
+
+func (api collectionAPI) Delete(msg dbus.Message) (dbus.ObjectPath, *dbus.Error) {
+	if err := api.check(msg); err != nil {
+		return "", err
+	}
+	return "", notSupported("the collection is the db and can't be deleted")
+}
+
+func (api collectionAPI) SearchItems(msg dbus.Message, attrs map[string]string) ([]dbus.ObjectPath, *dbus.Error) {
+	if err := api.check(msg); err != nil {
+		return nil, err
+	}
+	return api.s.search(attrs), nil
+}
+
+// CreateItem adds a record for the item, with replace set the first record with the attributes is updated instead.
+func (api collectionAPI) CreateItem(msg dbus.Message, sender dbus.Sender, properties map[string]dbus.Variant, sec secret, replace bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	if err := api.check(msg); err != nil {
+		return "", "", err
+	}
+	s := api.s
+	if err := s.checkSession(sender, sec.Session); err != nil {
+		return "", "", err
+	}
+	var label string
+	attrs := map[string]string{}
+	for name, value := range properties {
+		var ok bool
+		switch name {
+		case ifaceItem + ".Label":
+			label, ok = value.Value().(string)
+		case ifaceItem + ".Attributes":
+			attrs, ok = value.Value().(map[string]string)
+		default:
+			ok = true
+		}
+		if !ok {
+			return "", "", invalidArgs("invalid type for " + name)
+		}
+	}
+	if len(sec.Value) == 0 {
+		return "", "", invalidArgs("the secret can't be empty")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := pwsafe.Record{Group: s.opts.Group}
+	created := true
+	if replace {
+		if existing := s.search(attrs); len(existing) > 0 {
+			record, _ = s.record(existing[0])
+			created = false
+		}
+	}
+	if err := setAttributes(&record, attrs); err != nil {
+		return "", "", invalidArgs(err.Error())
+	}
+	if label != "" {
+		record.Title = label
+	}
+	if record.Title == "" {
+		return "", "", invalidArgs("the item needs a label")
+	}
+	record.Password = string(sec.Value)
+	id := s.db.SetRecord(record)
+	if err := s.save(); err != nil {
+		return "", "", err
+	}
+	signal := ".ItemChanged"
+	if created {
+		signal = ".ItemCreated"
+	}
+	s.conn.Emit(collectionPath, ifaceCollection+signal, itemPath(id))
+	return itemPath(id), noPrompt, nil
+}
+
+// itemAPI implements org.freedesktop.Secret.Item.
+type itemAPI struct{ s *Service }
+
+func (api itemAPI) Delete(msg dbus.Message) (dbus.ObjectPath, *dbus.Error) {
+	s := api.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, err := s.record(messagePath(msg))
+	if err != nil {
+		return "", err
+	}
+	s.db.DeleteRecord(record.UUID)
+	if err := s.save(); err != nil {
+		return "", err
+	}
+	s.conn.Emit(collectionPath, ifaceCollection+".ItemDeleted", itemPath(record.UUID))
+	return noPrompt, nil
+}
+
+func (api itemAPI) GetSecret(msg dbus.Message, sender dbus.Sender, session dbus.ObjectPath) (secret, *dbus.Error) {
+	record, err := api.s.record(messagePath(msg))
+	if err != nil {
+		return secret{}, err
+	}
+	if err := api.s.checkSession(sender, session); err != nil {
+		return secret{}, err
+	}
+	return secretFor(record, session), nil
+}
+
+func (api itemAPI) SetSecret(msg dbus.Message, sender dbus.Sender, sec secret) *dbus.Error {
+	s := api.s
+	if err := s.checkSession(sender, sec.Session); err != nil {
+		return err
+	}
+	if len(sec.Value) == 0 {
+		return invalidArgs("the secret can't be empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(messagePath(msg), func(record *pwsafe.Record) *dbus.Error {
+		record.Password = string(sec.Value)
+		return nil
+	})
+}
+
+// sessionAPI implements org.freedesktop.Secret.Session.
+type sessionAPI struct{ s *Service }
+
+func (api sessionAPI) Close(msg dbus.Message, sender dbus.Sender) *dbus.Error {
+	path := messagePath(msg)
+	if err := api.s.checkSession(sender, path); err != nil {
+		return err
+	}
+	api.s.mu.Lock()
+	delete(api.s.sessions, path)
+	api.s.mu.Unlock()
+	return nil
+}
+
+// propertiesAPI implements org.freedesktop.DBus.Properties for all the objects.
+type propertiesAPI struct{ s *Service }
+
+func unixTime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.Unix())
+}
+
+func (api propertiesAPI) GetAll(msg dbus.Message, iface string) (map[string]dbus.Variant, *dbus.Error) {
+	s := api.s
+	path := messagePath(msg)
+	switch {
+	case path == servicePath && iface == ifaceService:
+		return map[string]dbus.Variant{"Collections": dbus.MakeVariant([]dbus.ObjectPath{collectionPath})}, nil
+	case isCollection(path) && iface == ifaceCollection:
+		var modified time.Time
+		records := s.db.Snapshot()
+		items := make([]dbus.ObjectPath, 0, len(records))
+		for _, record := range records {
+			items = append(items, itemPath(record.UUID))
+			if record.ModTime.After(modified) {
+				modified = record.ModTime
+			}
+		}
+		return map[string]dbus.Variant{
+			"Items":    dbus.MakeVariant(items),
+			"Label":    dbus.MakeVariant(s.label),
+			"Locked":   dbus.MakeVariant(false),
+			"Created":  dbus.MakeVariant(uint64(0)),
+			"Modified": dbus.MakeVariant(unixTime(modified)),
+		}, nil
+	case iface == ifaceItem:
+		record, err := s.record(path)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]dbus.Variant{
+			"Locked":     dbus.MakeVariant(false),
+			"Attributes": dbus.MakeVariant(attributes(record)),
+			"Label":      dbus.MakeVariant(record.Title),
+			"Created":    dbus.MakeVariant(unixTime(record.CreateTime)),
+			"Modified":   dbus.MakeVariant(unixTime(record.ModTime)),
+		}, nil
+	case strings.HasPrefix(string(path), sessionPrefix) && iface == ifaceSession:
+		return map[string]dbus.Variant{}, nil
+	}
+	return nil, unknownInterface(iface)
+}
+
+func (api propertiesAPI) Get(msg dbus.Message, iface, name string) (dbus.Variant, *dbus.Error) {
+	props, err := api.GetAll(msg, iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	value, ok := props[name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", []any{"unknown property " + name})
+	}
+	return value, nil
+}
+
+// Set changes the label or attributes of an item, the other properties are read only.
+func (api propertiesAPI) Set(msg dbus.Message, iface, name string, value dbus.Variant) *dbus.Error {
+	path := messagePath(msg)
+	if _, err := api.Get(msg, iface, name); err != nil {
+		return err
+	}
+	if iface != ifaceItem || (name != "Label" && name != "Attributes") {
+		return dbus.NewError("org.freedesktop.DBus.Error.PropertyReadOnly", []any{name + " is read only"})
+	}
+	s := api.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(path, func(record *pwsafe.Record) *dbus.Error {
+		switch name {
+		case "Label":
+			label, ok := value.Value().(string)
+			if !ok || label == "" {
+				return invalidArgs("the label must be a non-empty string")
+			}
+			record.Title = label
+		case "Attributes":
+			attrs, ok := value.Value().(map[string]string)
+			if !ok {
+				return invalidArgs("the attributes must be a string map")
+			}
+			if err := setAttributes(record, attrs); err != nil {
+				return invalidArgs(err.Error())
+			}
+		}
+		return nil
+	})
+}
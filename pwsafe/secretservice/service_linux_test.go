@@ -0,0 +1,244 @@
+package secretservice
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+const busConfig = `<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>
+`
+
+// privateBus starts a dbus-daemon for the test and returns its address.
+func privateBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	require.NoError(t, os.WriteFile(config, []byte(strings.Replace(busConfig, "%s", filepath.Join(dir, "bus"), 1)), 0o600))
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	require.NoError(t, err)
+	return strings.TrimSpace(address)
+}
+
+func connect(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// client calls the Secret Service like an application would.
+type client struct {
+	t       *testing.T
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+}
+
+func (c client) call(path dbus.ObjectPath, method string, args ...any) *dbus.Call {
+	return c.conn.Object(BusName, path).Call(method, 0, args...)
+}
+
+func (c client) property(path dbus.ObjectPath, name string) any {
+	c.t.Helper()
+	value, err := c.conn.Object(BusName, path).GetProperty(name)
+	require.NoError(c.t, err)
+	return value.Value()
+}
+
+func (c client) search(attrs map[string]string) []dbus.ObjectPath {
+	c.t.Helper()
+	var unlocked, locked []dbus.ObjectPath
+	require.NoError(c.t, c.call(servicePath, ifaceService+".SearchItems", attrs).Store(&unlocked, &locked))
+	assert.Empty(c.t, locked)
+	return unlocked
+}
+
+func (c client) secret(item dbus.ObjectPath) string {
+	c.t.Helper()
+	var sec secret
+	require.NoError(c.t, c.call(item, ifaceItem+".GetSecret", c.session).Store(&sec))
+	return string(sec.Value)
+}
+
+func dbusErrorName(err error) string {
+	if dbusErr, ok := err.(dbus.Error); ok {
+		return dbusErr.Name
+	}
+	return ""
+}
+
+func TestService(t *testing.T) {
+	address := privateBus(t)
+
+	db := pwsafe.NewV3("team", "password")
+	githubID := db.SetRecord(pwsafe.Record{Title: "github", Group: "dev", Username: "me", Password: "gh-pass", URL: "https://github.com"})
+	db.SetRecord(pwsafe.Record{Title: "bank", Group: "money", Username: "me", Password: "bank-pass"})
+	// The saves happen on the service's goroutines
+	var mu sync.Mutex
+	var saved bytes.Buffer
+	saves := 0
+	savedState := func() (int, []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		return saves, bytes.Clone(saved.Bytes())
+	}
+	s, err := Export(connect(t, address), db, Options{Save: func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		saves++
+		saved.Reset()
+		return db.Encrypt(&saved)
+	}})
+	require.NoError(t, err)
+
+	_, err = Export(connect(t, address), db, Options{})
+	assert.Error(t, err, "only one service can own the name")
+
+	c := client{t: t, conn: connect(t, address)}
+
+	// Sessions
+	var output dbus.Variant
+	err = c.call(servicePath, ifaceService+".OpenSession", "dh-ietf1024-sha256-aes128-cbc-pkcs7", dbus.MakeVariant([]byte{1})).Store(&output, &c.session)
+	assert.Equal(t, "org.freedesktop.DBus.Error.NotSupported", dbusErrorName(err))
+	require.NoError(t, c.call(servicePath, ifaceService+".OpenSession", "plain", dbus.MakeVariant("")).Store(&output, &c.session))
+	assert.True(t, strings.HasPrefix(string(c.session), sessionPrefix))
+
+	// The collection
+	var alias dbus.ObjectPath
+	require.NoError(t, c.call(servicePath, ifaceService+".ReadAlias", "default").Store(&alias))
+	assert.Equal(t, collectionPath, alias)
+	require.NoError(t, c.call(servicePath, ifaceService+".ReadAlias", "other").Store(&alias))
+	assert.Equal(t, noPrompt, alias)
+	assert.Equal(t, []dbus.ObjectPath{collectionPath}, c.property(servicePath, ifaceService+".Collections"))
+	assert.Equal(t, "team", c.property(collectionPath, ifaceCollection+".Label"))
+	assert.Len(t, c.property(aliasPath, ifaceCollection+".Items"), 2)
+	assert.Equal(t, false, c.property(collectionPath, ifaceCollection+".Locked"))
+
+	// Items
+	github := itemPath(githubID)
+	assert.ElementsMatch(t, []dbus.ObjectPath{github}, c.search(map[string]string{"url": "https://github.com"}))
+	assert.Len(t, c.search(map[string]string{"username": "me"}), 2)
+	assert.Empty(t, c.search(map[string]string{"username": "you"}))
+	var found []dbus.ObjectPath
+	require.NoError(t, c.call(collectionPath, ifaceCollection+".SearchItems", map[string]string{"group": "dev"}).Store(&found))
+	assert.Equal(t, []dbus.ObjectPath{github}, found)
+
+	assert.Equal(t, "github", c.property(github, ifaceItem+".Label"))
+	assert.Equal(t, map[string]string{"title": "github", "group": "dev", "username": "me", "url": "https://github.com"},
+		c.property(github, ifaceItem+".Attributes"))
+	assert.NotZero(t, c.property(github, ifaceItem+".Created"))
+	assert.Equal(t, "gh-pass", c.secret(github))
+
+	var secrets map[dbus.ObjectPath]secret
+	require.NoError(t, c.call(servicePath, ifaceService+".GetSecrets", []dbus.ObjectPath{github, collectionPath + "/00"}, c.session).Store(&secrets))
+	require.Len(t, secrets, 1)
+	assert.Equal(t, "gh-pass", string(secrets[github].Value))
+
+	// Sessions belong to the connection which opened them
+	other := client{t: t, conn: connect(t, address), session: c.session}
+	err = other.call(github, ifaceItem+".GetSecret", c.session).Store(&secret{})
+	assert.Equal(t, "org.freedesktop.Secret.Error.NoSession", dbusErrorName(err))
+
+	// Create an item like libsecret does
+	attrs := map[string]string{"xdg:schema": "org.example.Password", "application": "example"}
+	props := map[string]dbus.Variant{
+		ifaceItem + ".Label":      dbus.MakeVariant("Example password"),
+		ifaceItem + ".Attributes": dbus.MakeVariant(attrs),
+	}
+	var item, prompt dbus.ObjectPath
+	require.NoError(t, c.call(collectionPath, ifaceCollection+".CreateItem", props,
+		secret{Session: c.session, Parameters: []byte{}, Value: []byte("example-pass"), ContentType: "text/plain"}, true).Store(&item, &prompt))
+	assert.Equal(t, noPrompt, prompt)
+	assert.Equal(t, []dbus.ObjectPath{item}, c.search(attrs))
+	assert.Equal(t, "example-pass", c.secret(item))
+	count, _ := savedState()
+	assert.Equal(t, 1, count)
+
+	// Replacing updates the same item
+	var replaced dbus.ObjectPath
+	require.NoError(t, c.call(collectionPath, ifaceCollection+".CreateItem", props,
+		secret{Session: c.session, Parameters: []byte{}, Value: []byte("changed"), ContentType: "text/plain"}, true).Store(&replaced, &prompt))
+	assert.Equal(t, item, replaced)
+	assert.Equal(t, "changed", c.secret(item))
+	assert.Len(t, c.property(collectionPath, ifaceCollection+".Items"), 3)
+
+	require.NoError(t, c.call(item, ifaceItem+".SetSecret",
+		secret{Session: c.session, Parameters: []byte{}, Value: []byte("set"), ContentType: "text/plain"}).Err)
+	assert.Equal(t, "set", c.secret(item))
+	require.NoError(t, c.conn.Object(BusName, item).SetProperty(ifaceItem+".Label", dbus.MakeVariant("Renamed")))
+	assert.Equal(t, "Renamed", c.property(item, ifaceItem+".Label"))
+	err = c.conn.Object(BusName, collectionPath).SetProperty(ifaceCollection+".Label", dbus.MakeVariant("x"))
+	assert.Equal(t, "org.freedesktop.DBus.Error.PropertyReadOnly", dbusErrorName(err))
+
+	// The changes and the extra attributes are saved in the db
+	count, data := savedState()
+	assert.Equal(t, 4, count)
+	reopened := &pwsafe.V3{}
+	_, err = reopened.Decrypt(bytes.NewReader(data), "password")
+	require.NoError(t, err)
+	var record pwsafe.Record
+	for _, r := range reopened.Snapshot() {
+		if r.Title == "Renamed" {
+			record = r
+		}
+	}
+	assert.Equal(t, DefaultGroup, record.Group)
+	assert.Equal(t, "set", record.Password)
+	assert.Equal(t, "[secret-service]\napplication=example\nxdg:schema=org.example.Password", record.Notes)
+
+	// Delete
+	require.NoError(t, c.call(item, ifaceItem+".Delete").Store(&prompt))
+	assert.Empty(t, c.search(attrs))
+	err = c.call(item, ifaceItem+".GetSecret", c.session).Store(&secret{})
+	assert.Equal(t, "org.freedesktop.Secret.Error.NoSuchObject", dbusErrorName(err))
+	count, _ = savedState()
+	assert.Equal(t, 5, count)
+
+	// Methods only work on their objects
+	err = c.call(github, ifaceService+".ReadAlias", "default").Store(&alias)
+	assert.Equal(t, "org.freedesktop.DBus.Error.UnknownInterface", dbusErrorName(err))
+
+	require.NoError(t, c.call(c.session, ifaceSession+".Close").Err)
+	err = c.call(github, ifaceItem+".GetSecret", c.session).Store(&secret{})
+	assert.Equal(t, "org.freedesktop.Secret.Error.NoSession", dbusErrorName(err))
+
+	require.NoError(t, s.Close())
+	err = c.call(servicePath, ifaceService+".ReadAlias", "default").Store(&alias)
+	assert.Error(t, err)
+}
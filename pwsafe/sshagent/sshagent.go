@@ -0,0 +1,256 @@
+// Package sshagent serves SSH private keys stored in the notes of Password Safe v3 records with the SSH agent protocol.
+// The keys are only held in memory, they are never written to disk.
+package sshagent
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+// DefaultGroup and DefaultMarker select the records keys are loaded from when Options doesn't set them.
+const (
+	DefaultGroup  = "SSH"
+	DefaultMarker = "[ssh-agent]"
+)
+
+// ErrRefused is returned when a signature request is not confirmed.
+var ErrRefused = errors.New("use of the key was refused")
+
+// ConfirmFunc asks whether the key with the given comment may be used for a signature and returns true if it may.
+type ConfirmFunc func(comment string, key ssh.PublicKey) bool
+
+// Options select the records keys are loaded from and constrain their use.
+type Options struct {
+	// Group selects the records in this group or its subgroups.
+	Group string
+	// Marker selects the records whose notes contain it, anywhere in the db.
+	Marker string
+	// Lifetime removes the loaded keys from the agent after this long, 0 keeps them until the agent stops.
+	Lifetime time.Duration
+	// Confirm is asked before every signature with a loaded key when it is set.
+	Confirm ConfirmFunc
+}
+
+// selected returns true if the record should be loaded.
+func (opts Options) selected(record pwsafe.Record) bool {
+	if opts.Group != "" && (record.Group == opts.Group || strings.HasPrefix(record.Group, opts.Group+".")) {
+		return true
+	}
+	return opts.Marker != "" && strings.Contains(record.Notes, opts.Marker)
+}
+
+// LoadKeys returns the private keys in the notes of the records selected by opts. A record's notes may hold any number
+// of PEM encoded keys in the OpenSSH, PKCS#1, PKCS#8 or EC formats, a key protected by a passphrase is decrypted with
+// the record's password. Keys are commented with the record's group and title.
+// The keys which could be loaded are returned along with an error for every selected record which had none or held a
+// key which couldn't be parsed.
+func LoadKeys(db *pwsafe.V3, opts Options) ([]agent.AddedKey, error) {
+	var keys []agent.AddedKey
+	var errs []error
+	for _, record := range db.Snapshot() {
+		if !opts.selected(record) {
+			continue
+		}
+		name := record.Title
+		if record.Group != "" {
+			name = record.Group + "/" + record.Title
+		}
+		found := 0
+		rest := []byte(record.Notes)
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+				continue
+			}
+			found++
+			key, err := parseKey(block, record.Password)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			added := agent.AddedKey{PrivateKey: key, Comment: name, ConfirmBeforeUse: opts.Confirm != nil}
+			if opts.Lifetime > 0 {
+				added.LifetimeSecs = uint32(max(opts.Lifetime/time.Second, 1))
+			}
+			keys = append(keys, added)
+		}
+		if found == 0 {
+			errs = append(errs, fmt.Errorf("%s: no private key in the notes", name))
+		}
+	}
+	return keys, errors.Join(errs...)
+}
+
+// parseKey parses a PEM private key block, decrypting it with passphrase if needed.
+func parseKey(block *pem.Block, passphrase string) (any, error) {
+	data := pem.EncodeToMemory(block)
+	defer clear(data)
+	key, err := ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passphrase == "" {
+			return nil, errors.New("the key is encrypted and the record has no password")
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+	return key, err
+}
+
+// Agent is an SSH agent holding keys in memory, it adds the confirm before use constraint to the keyring from
+// golang.org/x/crypto/ssh/agent so keys added by clients with ssh-add -c can also require confirmation.
+type Agent struct {
+	keyring agent.ExtendedAgent
+	confirm ConfirmFunc
+
+	mu           sync.Mutex
+	needsConfirm map[string]bool // the marshaled public keys which need confirmation
+}
+
+var _ agent.ExtendedAgent = (*Agent)(nil)
+
+// New returns an agent holding keys, confirm is asked before signing with a key added with ConfirmBeforeUse and keys
+// with that constraint are refused when it is nil.
+func New(keys []agent.AddedKey, confirm ConfirmFunc) (*Agent, error) {
+	a := &Agent{
+		keyring:      agent.NewKeyring().(agent.ExtendedAgent),
+		confirm:      confirm,
+		needsConfirm: make(map[string]bool),
+	}
+	for _, key := range keys {
+		if err := a.Add(key); err != nil {
+			return nil, fmt.Errorf("%s: %w", key.Comment, err)
+		}
+	}
+	return a, nil
+}
+
+// Add adds a key to the agent.
+func (a *Agent) Add(key agent.AddedKey) error {
+	signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	if key.ConfirmBeforeUse && a.confirm == nil {
+		return errors.New("confirm before use is not available")
+	}
+	confirm := key.ConfirmBeforeUse
+	key.ConfirmBeforeUse = false
+	if err := a.keyring.Add(key); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.needsConfirm[string(signer.PublicKey().Marshal())] = confirm
+	return nil
+}
+
+// List returns the public keys in the agent.
+func (a *Agent) List() ([]*agent.Key, error) {
+	return a.keyring.List()
+}
+
+// Sign signs data with the key after asking for confirmation if it is needed.
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags signs data with the key after asking for confirmation if it is needed.
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	a.mu.Lock()
+	confirm := a.needsConfirm[string(key.Marshal())]
+	a.mu.Unlock()
+	if confirm {
+		// Check the key is held before asking, it may have been removed or expired
+		comment, ok, err := a.comment(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok && !a.confirm(comment, key) {
+			return nil, ErrRefused
+		}
+	}
+	return a.keyring.SignWithFlags(key, data, flags)
+}
+
+// comment returns the comment of the key and false if the agent doesn't hold it.
+func (a *Agent) comment(key ssh.PublicKey) (string, bool, error) {
+	keys, err := a.keyring.List()
+	if err != nil {
+		return "", false, err
+	}
+	wanted := key.Marshal()
+	for _, k := range keys {
+		if bytes.Equal(k.Blob, wanted) {
+			return k.Comment, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// Remove removes the key from the agent.
+func (a *Agent) Remove(key ssh.PublicKey) error {
+	a.mu.Lock()
+	delete(a.needsConfirm, string(key.Marshal()))
+	a.mu.Unlock()
+	return a.keyring.Remove(key)
+}
+
+// RemoveAll removes all the keys from the agent.
+func (a *Agent) RemoveAll() error {
+	a.mu.Lock()
+	clear(a.needsConfirm)
+	a.mu.Unlock()
+	return a.keyring.RemoveAll()
+}
+
+// Lock locks the agent with a passphrase, signatures and listing the keys fail until it is unlocked.
+func (a *Agent) Lock(passphrase []byte) error {
+	return a.keyring.Lock(passphrase)
+}
+
+// Unlock unlocks the agent.
+func (a *Agent) Unlock(passphrase []byte) error {
+	return a.keyring.Unlock(passphrase)
+}
+
+// Signers is not supported, it would bypass confirmation.
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	return nil, errors.New("signers are not available from the agent")
+}
+
+// Extension returns agent.ErrExtensionUnsupported, no extensions are supported.
+func (a *Agent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+// Serve serves the agent protocol to the connections accepted by l until it is closed.
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			agent.ServeAgent(a, conn)
+		}()
+	}
+}
@@ -0,0 +1,170 @@
+package sshagent
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/tkuhlman/gopwsafe/pwsafe"
+)
+
+func ed25519PEM(t *testing.T, passphrase string) (ssh.PublicKey, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return sshPub, string(pem.EncodeToMemory(block))
+}
+
+func ecdsaPEM(t *testing.T) (ssh.PublicKey, string) {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(&priv.PublicKey)
+	require.NoError(t, err)
+	return sshPub, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestLoadKeys(t *testing.T) {
+	plainPub, plain := ed25519PEM(t, "")
+	encryptedPub, encrypted := ed25519PEM(t, "passphrase")
+	ecPub, ec := ecdsaPEM(t)
+	_, wrongPassphrase := ed25519PEM(t, "other")
+
+	db := pwsafe.NewV3("keys", "password")
+	db.SetRecord(pwsafe.Record{Title: "plain", Group: "SSH", Password: "unused", Notes: "my laptop key\r\n" + plain})
+	db.SetRecord(pwsafe.Record{Title: "encrypted", Group: "SSH.work", Password: "passphrase", Notes: encrypted + "\n" + ec})
+	db.SetRecord(pwsafe.Record{Title: "marked", Group: "servers", Password: "wrong", Notes: DefaultMarker + "\n" + wrongPassphrase})
+	db.SetRecord(pwsafe.Record{Title: "empty", Group: "SSH", Password: "p", Notes: "lost the key"})
+	db.SetRecord(pwsafe.Record{Title: "unrelated", Group: "SSHish", Password: "p", Notes: plain})
+
+	keys, err := LoadKeys(db, Options{Group: DefaultGroup, Lifetime: time.Hour})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SSH/empty: no private key")
+	require.Len(t, keys, 3)
+	loaded := make(map[string]string)
+	for _, key := range keys {
+		signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+		require.NoError(t, err)
+		loaded[string(signer.PublicKey().Marshal())] = key.Comment
+		assert.Equal(t, uint32(3600), key.LifetimeSecs)
+		assert.False(t, key.ConfirmBeforeUse)
+	}
+	assert.Equal(t, map[string]string{
+		string(plainPub.Marshal()):     "SSH/plain",
+		string(encryptedPub.Marshal()): "SSH.work/encrypted",
+		string(ecPub.Marshal()):        "SSH.work/encrypted",
+	}, loaded)
+
+	keys, err = LoadKeys(db, Options{Marker: DefaultMarker, Confirm: func(string, ssh.PublicKey) bool { return true }})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "servers/marked")
+	assert.Empty(t, keys)
+
+	keys, err = LoadKeys(db, Options{})
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+// serve returns a client connected to the agent over a pipe.
+func serve(t *testing.T, a *Agent) agent.ExtendedAgent {
+	t.Helper()
+	client, server := net.Pipe()
+	go agent.ServeAgent(a, server)
+	t.Cleanup(func() { client.Close() })
+	return agent.NewClient(client)
+}
+
+func TestAgent(t *testing.T) {
+	pub, key := ed25519PEM(t, "")
+	db := pwsafe.NewV3("keys", "password")
+	db.SetRecord(pwsafe.Record{Title: "key", Group: "SSH", Password: "p", Notes: key})
+
+	var asked []string
+	allow := false
+	confirm := func(comment string, key ssh.PublicKey) bool {
+		asked = append(asked, comment)
+		return allow
+	}
+	keys, err := LoadKeys(db, Options{Group: DefaultGroup, Confirm: confirm})
+	require.NoError(t, err)
+	a, err := New(keys, confirm)
+	require.NoError(t, err)
+	client := serve(t, a)
+
+	listed, err := client.List()
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, "SSH/key", listed[0].Comment)
+	assert.Equal(t, pub.Marshal(), listed[0].Blob)
+
+	data := []byte("challenge")
+	_, err = client.Sign(pub, data)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"SSH/key"}, asked)
+
+	allow = true
+	sig, err := client.Sign(pub, data)
+	require.NoError(t, err)
+	assert.NoError(t, pub.Verify(data, sig))
+	assert.Len(t, asked, 2)
+
+	// A key added by a client without the constraint doesn't ask
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, client.Add(agent.AddedKey{PrivateKey: priv, Comment: "added"}))
+	addedPub, err := ssh.NewPublicKey(priv.Public())
+	require.NoError(t, err)
+	sig, err = client.Sign(addedPub, data)
+	require.NoError(t, err)
+	assert.NoError(t, addedPub.Verify(data, sig))
+	assert.Len(t, asked, 2)
+
+	require.NoError(t, client.Remove(pub))
+	listed, err = client.List()
+	require.NoError(t, err)
+	assert.Len(t, listed, 1)
+	_, err = client.Sign(pub, data)
+	assert.Error(t, err)
+	assert.Len(t, asked, 2, "removed keys don't ask")
+
+	_, err = a.Signers()
+	assert.Error(t, err)
+}
+
+func TestAgentWithoutConfirm(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, err = New([]agent.AddedKey{{PrivateKey: priv, Comment: "c", ConfirmBeforeUse: true}}, nil)
+	assert.Error(t, err)
+
+	a, err := New(nil, nil)
+	require.NoError(t, err)
+	client := serve(t, a)
+	assert.Error(t, client.Add(agent.AddedKey{PrivateKey: priv, ConfirmBeforeUse: true}))
+	require.NoError(t, client.Add(agent.AddedKey{PrivateKey: priv}))
+	listed, err := client.List()
+	require.NoError(t, err)
+	assert.Len(t, listed, 1)
+}
@@ -0,0 +1,52 @@
+package pwsafe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// decodeTime decodes a little endian time_t field. The spec stores times in 4 bytes, or 5 bytes for the 40 bit
+// variant able to hold times after 2038, some writers use a 64 bit time_t so 8 bytes are also accepted.
+func decodeTime(data []byte) (time.Time, error) {
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.LittleEndian.Uint32(data)), 0), nil
+	case 5:
+		var buf [8]byte
+		copy(buf[:], data)
+		return time.Unix(int64(binary.LittleEndian.Uint64(buf[:])), 0), nil
+	case 8:
+		// formats before 0x0302 wrote the header save time as 8 hex characters
+		if secs, err := strconv.ParseUint(string(data), 16, 32); err == nil {
+			return time.Unix(int64(secs), 0), nil
+		}
+		return time.Unix(int64(binary.LittleEndian.Uint64(data)), 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid length %d for a time field", len(data))
+}
+
+// setTime decodes the time field data into dst.
+func setTime(dst *time.Time, data []byte) error {
+	t, err := decodeTime(data)
+	if err != nil {
+		return err
+	}
+	*dst = t
+	return nil
+}
+
+// encodeTime encodes t as a 4 byte time_t as the spec prefers, using the 5 byte variant only for times which
+// don't fit in 32 bits. Times before the epoch are clamped to it as time_t fields are unsigned.
+func encodeTime(t time.Time) []byte {
+	secs := t.Unix()
+	if secs < 0 {
+		secs = 0
+	}
+	if secs <= math.MaxUint32 {
+		return binary.LittleEndian.AppendUint32(nil, uint32(secs))
+	}
+	return binary.LittleEndian.AppendUint64(nil, uint64(secs))[:5]
+}
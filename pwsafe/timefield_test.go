@@ -0,0 +1,84 @@
+package pwsafe
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected time.Time
+	}{
+		{"32 bit", []byte{0x00, 0xe1, 0xf5, 0x05}, time.Unix(100000000, 0)},
+		{"40 bit after 2038", []byte{0x00, 0x00, 0x00, 0x00, 0x01}, time.Unix(1<<32, 0)},
+		{"64 bit", []byte{0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00}, time.Unix(1<<31, 0)},
+		{"hex text", []byte("5f5e1000"), time.Unix(0x5f5e1000, 0)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			decoded, err := decodeTime(tc.data)
+			assert.NoError(t, err)
+			assert.True(t, tc.expected.Equal(decoded), "expected %v got %v", tc.expected, decoded)
+		})
+	}
+
+	for _, size := range []int{0, 1, 3, 6, 7, 9} {
+		_, err := decodeTime(make([]byte, size))
+		assert.Error(t, err, "size %d", size)
+	}
+}
+
+func TestEncodeTime(t *testing.T) {
+	assert.Len(t, encodeTime(time.Unix(1700000000, 0)), 4)
+	assert.Len(t, encodeTime(time.Unix(math.MaxUint32, 0)), 4)
+	assert.Equal(t, []byte{0, 0, 0, 0}, encodeTime(time.Unix(-5, 0)))
+
+	after2106 := time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := encodeTime(after2106)
+	assert.Len(t, data, 5)
+	decoded, err := decodeTime(data)
+	assert.NoError(t, err)
+	assert.True(t, after2106.Equal(decoded))
+}
+
+func TestRecordTimesRoundTrip(t *testing.T) {
+	future := time.Date(2150, 6, 1, 12, 0, 0, 0, time.UTC)
+	r := &Record{
+		Title:           "times",
+		Password:        "pw",
+		CreateTime:      time.Unix(1600000000, 0),
+		PasswordModTime: time.Unix(1650000000, 0),
+		AccessTime:      time.Unix(1700000000, 0),
+		PasswordExpiry:  future,
+		ModTime:         time.Unix(1710000000, 0),
+	}
+	data, _, err := encryptEntry(t, r.writeFields)
+	assert.NoError(t, err)
+
+	decoded := &Record{}
+	assert.NoError(t, decryptEntry(t, data, decoded))
+	equal, err := r.Equal(*decoded, false)
+	assert.True(t, equal, err)
+}
+
+func TestSetRecordPasswordModTime(t *testing.T) {
+	db := NewV3("test", "password")
+	id := db.SetRecord(Record{Title: "pmtime", Password: "pw"})
+	assert.True(t, db.Records[id].PasswordModTime.IsZero(), "a new record has no password modification")
+
+	record := db.Records[id]
+	record.Notes = "notes only"
+	db.SetRecord(record)
+	assert.True(t, db.Records[id].PasswordModTime.IsZero())
+
+	record = db.Records[id]
+	record.Password = "changed"
+	db.SetRecord(record)
+	assert.False(t, db.Records[id].PasswordModTime.IsZero())
+	assert.True(t, db.Records[id].PasswordModTime.Equal(db.Records[id].ModTime))
+}
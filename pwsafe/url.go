@@ -0,0 +1,168 @@
+package pwsafe
+
+import (
+	"cmp"
+	"errors"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// URLMatchMode is how closely a record's URL must match for MatchURL, each mode also matches the closer modes.
+type URLMatchMode int
+
+const (
+	// MatchDomain matches URLs with the same registrable domain, e.g. login.example.co.uk and www.example.co.uk
+	MatchDomain URLMatchMode = iota
+	// MatchHost matches URLs with the same host ignoring a www. prefix and the port
+	MatchHost
+	// MatchExact matches URLs with the same host and port where the record's path is a prefix of the URL's path.
+	// The scheme must also match unless the record's URL doesn't include one.
+	MatchExact
+)
+
+func (m URLMatchMode) String() string {
+	switch m {
+	case MatchDomain:
+		return "domain"
+	case MatchHost:
+		return "host"
+	case MatchExact:
+		return "exact"
+	}
+	return "unknown"
+}
+
+// URLMatch is a record found by MatchURL, Mode is the closest mode its URL matches in.
+type URLMatch struct {
+	Record Record
+	Mode   URLMatchMode
+}
+
+// normalizedURL is a URL reduced to the parts compared by MatchURL.
+type normalizedURL struct {
+	scheme string // empty when the URL had no scheme
+	host   string // lower case without a www. prefix
+	port   string // empty for the scheme's default port
+	path   string // without a trailing slash
+	domain string // the registrable domain, or the host for IP addresses and hosts without a public suffix
+}
+
+var defaultPorts = map[string]string{"http": "80", "https": "443", "ftp": "21", "ssh": "22"}
+
+// normalizeURL parses a URL as stored in a record or given to MatchURL, a URL without a scheme such as
+// example.com/login is accepted.
+func normalizeURL(raw string) (normalizedURL, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return normalizedURL{}, errors.New("empty URL")
+	}
+	hasScheme := strings.Contains(raw, "://")
+	if !hasScheme {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return normalizedURL{}, err
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return normalizedURL{}, errors.New("URL has no host")
+	}
+
+	n := normalizedURL{
+		host: strings.TrimPrefix(host, "www."),
+		port: u.Port(),
+		path: strings.TrimSuffix(u.EscapedPath(), "/"),
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if hasScheme {
+		n.scheme = scheme
+	}
+	if n.port == defaultPorts[scheme] {
+		n.port = ""
+	}
+
+	n.domain = n.host
+	if net.ParseIP(n.host) == nil {
+		if domain, err := publicsuffix.EffectiveTLDPlusOne(n.host); err == nil {
+			n.domain = domain
+		}
+	}
+	return n, nil
+}
+
+// match returns how closely the record URL r matches the URL n and false if it doesn't match in mode.
+func (r normalizedURL) match(n normalizedURL, mode URLMatchMode) (URLMatchMode, bool) {
+	if r.domain != n.domain {
+		return 0, false
+	}
+	matched := MatchDomain
+	if r.host == n.host {
+		matched = MatchHost
+		if r.port == n.port && (r.scheme == "" || n.scheme == "" || r.scheme == n.scheme) && pathHasPrefix(n.path, r.path) {
+			matched = MatchExact
+		}
+	}
+	return matched, matched >= mode
+}
+
+// pathHasPrefix returns true if prefix is path or a parent of it.
+func pathHasPrefix(path, prefix string) bool {
+	return path == prefix || prefix == "" || strings.HasPrefix(path, prefix+"/")
+}
+
+// MatchURL returns the records whose URL matches rawURL as closely as mode requires. Both URLs are normalized, the
+// scheme defaults to https, a www. prefix, default ports and trailing slashes are ignored and hosts compare without
+// case. The registrable domain used by MatchDomain is found with an embedded copy of the public suffix list so
+// a.example.co.uk matches b.example.co.uk but not other.co.uk.
+// The matches are ranked closest first by mode, then by the longest matching path, then by the most recently accessed.
+// Records without a URL or with one that can't be parsed are skipped.
+func (db *V3) MatchURL(rawURL string, mode URLMatchMode) ([]URLMatch, error) {
+	target, err := normalizeURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	type ranked struct {
+		URLMatch
+		pathLen int
+	}
+	var matches []ranked
+	for _, record := range db.Records {
+		if record.URL == "" {
+			continue
+		}
+		n, err := normalizeURL(record.URL)
+		if err != nil {
+			continue
+		}
+		if matched, ok := n.match(target, mode); ok {
+			pathLen := 0
+			if matched == MatchExact {
+				pathLen = len(n.path)
+			}
+			matches = append(matches, ranked{URLMatch{Record: record, Mode: matched}, pathLen})
+		}
+	}
+	db.mu.RUnlock()
+
+	slices.SortFunc(matches, func(a, b ranked) int {
+		return cmp.Or(
+			cmp.Compare(b.Mode, a.Mode),
+			cmp.Compare(b.pathLen, a.pathLen),
+			b.Record.AccessTime.Compare(a.Record.AccessTime),
+			strings.Compare(a.Record.Title, b.Record.Title),
+			slices.Compare(a.Record.UUID[:], b.Record.UUID[:]),
+		)
+	})
+	results := make([]URLMatch, len(matches))
+	for i, m := range matches {
+		results[i] = m.URLMatch
+	}
+	return results, nil
+}
@@ -0,0 +1,84 @@
+package pwsafe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	cases := map[string]normalizedURL{
+		"https://www.Example.com/":         {scheme: "https", host: "example.com", path: "", domain: "example.com"},
+		"example.com/login/":               {host: "example.com", path: "/login", domain: "example.com"},
+		"http://example.com:80/a":          {scheme: "http", host: "example.com", path: "/a", domain: "example.com"},
+		"https://example.com:8443":         {scheme: "https", host: "example.com", port: "8443", domain: "example.com"},
+		"https://login.example.co.uk/?q=1": {scheme: "https", host: "login.example.co.uk", domain: "example.co.uk"},
+		"http://192.168.1.1:8080/admin":    {scheme: "http", host: "192.168.1.1", port: "8080", path: "/admin", domain: "192.168.1.1"},
+		"localhost:3000":                   {host: "localhost", port: "3000", domain: "localhost"},
+		"user.github.io":                   {host: "user.github.io", domain: "user.github.io"},
+	}
+	for raw, want := range cases {
+		got, err := normalizeURL(raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, want, got, raw)
+	}
+	for _, raw := range []string{"", "  ", "https://", "http://[::1"} {
+		_, err := normalizeURL(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestMatchURL(t *testing.T) {
+	db := NewV3("urls", "password")
+	add := func(title, url string, accessed time.Time) {
+		db.SetRecord(Record{Title: title, URL: url, Password: "secret", AccessTime: accessed})
+	}
+	now := time.Now()
+	add("root", "https://www.example.co.uk", time.Time{})
+	add("login", "example.co.uk/login", time.Time{})
+	add("login http", "http://example.co.uk/login", time.Time{})
+	add("admin", "https://example.co.uk/admin", time.Time{})
+	add("mail recent", "https://mail.example.co.uk", now)
+	add("mail old", "https://mail.example.co.uk", now.Add(-time.Hour))
+	add("port", "https://example.co.uk:8443/login", time.Time{})
+	add("other", "https://other.co.uk/login", time.Time{})
+	add("github user", "https://user.github.io", time.Time{})
+	add("github other", "https://other.github.io", time.Time{})
+	add("no url", "", time.Time{})
+	add("bad url", "http://[::1", time.Time{})
+
+	titles := func(matches []URLMatch) []string {
+		var got []string
+		for _, m := range matches {
+			got = append(got, m.Record.Title+" "+m.Mode.String())
+		}
+		return got
+	}
+
+	matches, err := db.MatchURL("https://example.co.uk/login/oauth?next=1", MatchDomain)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"login exact", "root exact", "admin host", "login http host", "port host", "mail recent domain", "mail old domain",
+	}, titles(matches))
+
+	matches, err = db.MatchURL("example.co.uk/login", MatchHost)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"login exact", "login http exact", "root exact", "admin host", "port host"}, titles(matches),
+		"a URL without a scheme matches any scheme")
+
+	matches, err = db.MatchURL("https://WWW.example.co.uk:443/admin/users", MatchExact)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin exact", "root exact"}, titles(matches))
+
+	matches, err = db.MatchURL("https://user.github.io/repo", MatchDomain)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"github user exact"}, titles(matches), "github.io is a public suffix")
+
+	matches, err = db.MatchURL("https://unknown.example.com", MatchDomain)
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+
+	_, err = db.MatchURL("", MatchDomain)
+	assert.Error(t, err)
+}
@@ -0,0 +1,258 @@
+package pwsafe
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// IssueKind identifies the type of problem an Issue reports.
+type IssueKind string
+
+const (
+	// IssueMissingTitle is a record without a title, it can't be saved.
+	IssueMissingTitle IssueKind = "missing-title"
+	// IssueMissingPassword is a record without a password, it can't be saved.
+	IssueMissingPassword IssueKind = "missing-password"
+	// IssueDuplicateTitle is a record with the same title and username as another record in its group, which the
+	// reference client doesn't allow.
+	IssueDuplicateTitle IssueKind = "duplicate-title"
+	// IssueInvalidUUID is a record with a zero UUID or one which doesn't match its key in Records.
+	IssueInvalidUUID IssueKind = "invalid-uuid"
+	// IssueExpiryInterval is a PasswordExpiryInterval above PasswordExpiryIntervalMax, it can't be saved.
+	IssueExpiryInterval IssueKind = "expiry-interval"
+	// IssueDanglingAlias is an alias or shortcut record whose base record isn't in the db.
+	IssueDanglingAlias IssueKind = "dangling-alias"
+	// IssueUnknownPolicy is a PasswordPolicyName which isn't one of the named policies in the header. It isn't fixed as
+	// clearing the name loses which policy the record was meant to use.
+	IssueUnknownPolicy IssueKind = "unknown-policy"
+	// IssuePasswordHistory is a PasswordHistory field which can't be parsed.
+	IssuePasswordHistory IssueKind = "password-history"
+	// IssueNamedPolicies is a named password policies header field which can't be parsed.
+	IssueNamedPolicies IssueKind = "named-policies"
+)
+
+// Issue is a problem with a record, or with the header when UUID is zero and Kind is IssueNamedPolicies.
+type Issue struct {
+	Kind         IssueKind
+	UUID         [16]byte // the record's key in Records
+	Title, Group string
+	Message      string
+	Fixable      bool // Fix corrects it without losing anything the user entered
+}
+
+// aliasPassword matches the password of an alias, [[uuid]], or a shortcut, [~uuid~], to the base record's UUID.
+var aliasPassword = regexp.MustCompile(`^(?:\[\[([0-9a-fA-F]{32})\]\]|\[~([0-9a-fA-F]{32})~\])$`)
+
+// Validate checks the header and records for problems which stop the db being saved, which the reference Password
+// Safe client would reject or which leave records that don't work, returning them in file order.
+func (db *V3) Validate() []Issue {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.validate()
+}
+
+// validate is Validate with the lock held.
+func (db *V3) validate() []Issue {
+	var issues []Issue
+	policies, policiesErr := parseNamedPolicies(db.Header.PasswordPolicy)
+	if policiesErr != nil {
+		issues = append(issues, Issue{Kind: IssueNamedPolicies, Message: policiesErr.Error()})
+	}
+
+	titles := make(map[[3]string]int)
+	for _, record := range db.Records {
+		titles[[3]string{record.Group, record.Title, record.Username}]++
+	}
+
+	for _, id := range db.orderedIDs() {
+		record := db.Records[id]
+		add := func(kind IssueKind, fixable bool, format string, a ...any) {
+			issues = append(issues, Issue{
+				Kind:    kind,
+				UUID:    id,
+				Title:   record.Title,
+				Group:   record.Group,
+				Message: fmt.Sprintf(format, a...),
+				Fixable: fixable,
+			})
+		}
+
+		if id == [16]byte{} {
+			add(IssueInvalidUUID, true, "the UUID is zero")
+		} else if record.UUID != id {
+			add(IssueInvalidUUID, true, "the UUID %x doesn't match its key %x", record.UUID, id)
+		}
+		if record.Title == "" {
+			add(IssueMissingTitle, false, "the record has no title")
+		}
+		if record.Password == "" {
+			add(IssueMissingPassword, false, "the record has no password")
+		}
+		if n := titles[[3]string{record.Group, record.Title, record.Username}]; n > 1 && record.Title != "" {
+			add(IssueDuplicateTitle, false, "%d records in the group have the title %q and username %q", n, record.Title,
+				record.Username)
+		}
+		if record.PasswordExpiryInterval > PasswordExpiryIntervalMax {
+			add(IssueExpiryInterval, true, "the password expiry interval %d exceeds the maximum of %d",
+				record.PasswordExpiryInterval, PasswordExpiryIntervalMax)
+		}
+		if base, ok := aliasBase(record.Password); ok {
+			if _, exists := db.Records[base]; !exists {
+				add(IssueDanglingAlias, false, "the base record %x doesn't exist", base)
+			}
+		}
+		if record.PasswordPolicyName != "" && policiesErr == nil && !slices.Contains(policies, record.PasswordPolicyName) {
+			add(IssueUnknownPolicy, false, "there is no password policy named %q", record.PasswordPolicyName)
+		}
+		if _, err := ParsePasswordHistory(record.PasswordHistory); err != nil {
+			add(IssuePasswordHistory, false, "the password history is malformed, %s", err)
+		}
+	}
+	return issues
+}
+
+// Fix corrects the fixable problems found by Validate as a single change for Undo, returning those it fixed.
+// A zero UUID is replaced with a random one and a UUID not matching the record's key is set to the key, and an expiry
+// interval above the maximum is cleared as it is when a record is read.
+func (db *V3) Fix() []Issue {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var fixed []Issue
+	var ids [][16]byte
+	byRecord := make(map[[16]byte][]Issue)
+	for _, issue := range db.validate() {
+		if !issue.Fixable {
+			continue
+		}
+		if _, ok := byRecord[issue.UUID]; !ok {
+			ids = append(ids, issue.UUID)
+		}
+		byRecord[issue.UUID] = append(byRecord[issue.UUID], issue)
+	}
+
+	// Records added directly to the map are given their current position so a re-keyed record keeps it
+	for _, id := range db.orderedIDs() {
+		db.order.add(id)
+	}
+	var c change
+	now := time.Now()
+	for _, id := range ids {
+		before := db.Records[id]
+		record := before
+		for _, issue := range byRecord[id] {
+			switch issue.Kind {
+			case IssueInvalidUUID:
+				record.UUID = id
+				if id == [16]byte{} {
+					record.UUID = [16]byte(uuid.NewRandom().Array())
+				}
+			case IssueExpiryInterval:
+				record.PasswordExpiryInterval = 0
+			}
+			fixed = append(fixed, issue)
+		}
+		record.ModTime = now
+
+		if record.UUID != id {
+			delete(db.Records, id)
+			db.reindex(id)
+			db.order.move(id, record.UUID)
+			c.records = append(c.records, recordChange{id: id, before: &before})
+			c.records = append(c.records, recordChange{id: record.UUID, after: &record})
+		} else {
+			c.records = append(c.records, recordChange{id: id, before: &before, after: &record})
+		}
+		db.Records[record.UUID] = record
+		db.reindex(record.UUID)
+	}
+	if len(fixed) > 0 {
+		db.journal.record(c)
+		db.LastMod = now
+	}
+	return fixed
+}
+
+// aliasBase returns the UUID of the base record if the password is that of an alias or shortcut.
+func aliasBase(password string) ([16]byte, bool) {
+	m := aliasPassword.FindStringSubmatch(password)
+	if m == nil {
+		return [16]byte{}, false
+	}
+	var base [16]byte
+	hex.Decode(base[:], []byte(m[1]+m[2]))
+	return base, true
+}
+
+var errTruncatedPolicies = errors.New("the named password policies are truncated")
+
+// parseNamedPolicies returns the names of the policies in the named password policies header field. The field is
+// NN, the number of policies as 2 hex digits, then for each the name length as 2 hex digits, the name, the flags
+// as 4 hex digits, the length and minimum lower case, upper case, digit and symbol counts as 3 hex digits each, the
+// number of special symbols as 2 hex digits and the symbols.
+func parseNamedPolicies(field string) ([]string, error) {
+	if field == "" {
+		return nil, nil
+	}
+	rest := []rune(field)
+	next := func(n int) (int, error) {
+		if len(rest) < n {
+			return 0, errTruncatedPolicies
+		}
+		v, err := strconv.ParseUint(string(rest[:n]), 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid hex %q in the named password policies", string(rest[:n]))
+		}
+		rest = rest[n:]
+		return int(v), nil
+	}
+	text := func(n int) (string, error) {
+		if len(rest) < n {
+			return "", errTruncatedPolicies
+		}
+		s := string(rest[:n])
+		rest = rest[n:]
+		return s, nil
+	}
+
+	count, err := next(2)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for range count {
+		nameLen, err := next(2)
+		if err != nil {
+			return nil, err
+		}
+		name, err := text(nameLen)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		// the flags then the length and the four minimum counts
+		for _, digits := range []int{4, 3, 3, 3, 3, 3} {
+			if _, err := next(digits); err != nil {
+				return nil, err
+			}
+		}
+		symbols, err := next(2)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := text(symbols); err != nil {
+			return nil, err
+		}
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("unexpected data after the last named password policy")
+	}
+	return names, nil
+}
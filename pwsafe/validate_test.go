@@ -0,0 +1,145 @@
+package pwsafe
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedPolicy returns a named password policies header field entry.
+func namedPolicy(name, symbols string) string {
+	return fmt.Sprintf("%02x%s%04x%03x%03x%03x%03x%03x%02x%s", len(name), name, 0xf000, 20, 1, 1, 1, 1, len(symbols), symbols)
+}
+
+func TestParseNamedPolicies(t *testing.T) {
+	names, err := parseNamedPolicies("")
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+
+	names, err = parseNamedPolicies("02" + namedPolicy("Bank", "!@") + namedPolicy("PIN", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Bank", "PIN"}, names)
+
+	for _, field := range []string{
+		"0",
+		"02" + namedPolicy("Bank", "!@"),
+		"01" + namedPolicy("Bank", "!@") + "x",
+		"zz",
+		"01" + namedPolicy("Bank", "!@")[:10],
+	} {
+		_, err := parseNamedPolicies(field)
+		assert.Error(t, err, field)
+	}
+}
+
+func TestAliasBase(t *testing.T) {
+	base, ok := aliasBase("[[0123456789abcdef0123456789ABCDEF]]")
+	assert.True(t, ok)
+	assert.Equal(t, [16]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}, base)
+	_, ok = aliasBase("[~0123456789abcdef0123456789abcdef~]")
+	assert.True(t, ok)
+	for _, password := range []string{"password", "[[0123]]", "[[0123456789abcdef0123456789abcdef]]x", "[~0123456789abcdef0123456789abcdef]]"} {
+		_, ok := aliasBase(password)
+		assert.False(t, ok, password)
+	}
+}
+
+// issueKinds returns the kinds of issue found for each record title, the header is named "".
+func issueKinds(issues []Issue) map[string][]IssueKind {
+	kinds := make(map[string][]IssueKind)
+	for _, issue := range issues {
+		kinds[issue.Title] = append(kinds[issue.Title], issue.Kind)
+	}
+	return kinds
+}
+
+func TestValidate(t *testing.T) {
+	db := NewV3("test", "password")
+	assert.Empty(t, db.Validate())
+
+	db.Header.PasswordPolicy = "01" + namedPolicy("Bank", "")
+	base := db.SetRecord(Record{Title: "base", Password: "pw", PasswordPolicyName: "Bank"})
+	db.SetRecord(Record{Title: "alias", Password: fmt.Sprintf("[[%x]]", base)})
+	db.SetRecord(Record{Title: "dangling", Password: "[~ffffffffffffffffffffffffffffffff~]"})
+	db.SetRecord(Record{Title: "dup", Group: "g", Username: "a", Password: "pw"})
+	db.SetRecord(Record{Title: "dup", Group: "g", Username: "a", Password: "pw2"})
+	db.SetRecord(Record{Title: "dup", Group: "g", Username: "b", Password: "pw"})
+	db.SetRecord(Record{Title: "dup", Group: "other", Username: "a", Password: "pw"})
+	db.SetRecord(Record{Title: "no password"})
+	db.SetRecord(Record{Password: "pw"})
+	db.SetRecord(Record{Title: "policy", Password: "pw", PasswordPolicyName: "Missing"})
+	db.SetRecord(Record{Title: "history", Password: "pw", PasswordHistory: "1ff01"})
+	db.Records[[16]byte{}] = Record{Title: "zero uuid", Password: "pw"}
+	db.Records[[16]byte{1}] = Record{UUID: [16]byte{2}, Title: "mismatched", Password: "pw", PasswordExpiryInterval: PasswordExpiryIntervalMax + 1}
+
+	issues := db.Validate()
+	assert.Equal(t, map[string][]IssueKind{
+		"dangling":    {IssueDanglingAlias},
+		"dup":         {IssueDuplicateTitle, IssueDuplicateTitle},
+		"no password": {IssueMissingPassword},
+		"":            {IssueMissingTitle},
+		"policy":      {IssueUnknownPolicy},
+		"history":     {IssuePasswordHistory},
+		"zero uuid":   {IssueInvalidUUID},
+		"mismatched":  {IssueInvalidUUID, IssueExpiryInterval},
+	}, issueKinds(issues))
+	for _, issue := range issues {
+		fixable := issue.Kind == IssueInvalidUUID || issue.Kind == IssueExpiryInterval
+		assert.Equal(t, fixable, issue.Fixable, issue.Kind)
+		assert.NotEmpty(t, issue.Message)
+	}
+	assert.Equal(t, "dangling", issues[0].Title, "issues are in file order")
+	for _, issue := range issues {
+		if issue.Kind == IssueDuplicateTitle {
+			assert.Equal(t, `2 records in the group have the title "dup" and username "a"`, issue.Message)
+		}
+	}
+
+	// A header which can't be parsed is reported once and the policy names aren't checked
+	db.Header.PasswordPolicy = "zz"
+	kinds := issueKinds(db.Validate())
+	assert.Equal(t, []IssueKind{IssueNamedPolicies}, kinds[""][:1])
+	assert.NotContains(t, kinds, "policy")
+}
+
+func TestFix(t *testing.T) {
+	db := NewV3("test", "password")
+	db.SetRecord(Record{Title: "first", Password: "pw"})
+	db.SetRecord(Record{Title: "policy", Password: "pw", PasswordPolicyName: "Missing"})
+	db.SetRecord(Record{Title: "no password"})
+	db.Records[[16]byte{}] = Record{Title: "zero uuid", Password: "pw", PasswordExpiryInterval: PasswordExpiryIntervalMax + 1}
+	db.Records[[16]byte{1}] = Record{UUID: [16]byte{2}, Title: "mismatched", Password: "pw"}
+	order := titles(db.Snapshot())
+
+	fixed := db.Fix()
+	assert.Equal(t, map[string][]IssueKind{
+		"zero uuid":  {IssueInvalidUUID, IssueExpiryInterval},
+		"mismatched": {IssueInvalidUUID},
+	}, issueKinds(fixed))
+	assert.Equal(t, map[string][]IssueKind{
+		"policy":      {IssueUnknownPolicy},
+		"no password": {IssueMissingPassword},
+	}, issueKinds(db.Validate()))
+	assert.Equal(t, order, titles(db.Snapshot()), "fixed records keep their position")
+
+	record, ok := db.RecordByTitle("zero uuid")
+	require.True(t, ok)
+	assert.NotEqual(t, [16]byte{}, record.UUID)
+	assert.Zero(t, record.PasswordExpiryInterval)
+	_, ok = db.Records[[16]byte{}]
+	assert.False(t, ok)
+	record, _ = db.Record([16]byte{1})
+	assert.Equal(t, [16]byte{1}, record.UUID)
+	record, _ = db.RecordByTitle("policy")
+	assert.Equal(t, "Missing", record.PasswordPolicyName, "an unknown policy name is kept")
+
+	// The fixes are a single change
+	assert.True(t, db.Undo())
+	assert.Len(t, db.Validate(), 5)
+	assert.Equal(t, order, titles(db.Snapshot()))
+	assert.True(t, db.Redo())
+	assert.Len(t, db.Validate(), 2)
+	assert.Empty(t, db.Fix(), "nothing is left to fix")
+}